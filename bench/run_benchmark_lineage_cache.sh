@@ -0,0 +1,23 @@
+#!/bin/sh
+
+echo Test: lineage cache
+
+function check() {
+    md5sum $1
+    # /bin/rm $1
+}
+
+# clustered inputs: TaxIds of the same subtree are adjacent
+for f in taxids.n*.txt; do
+    sort -n $f > $f.sorted
+done
+
+for f in taxids.n*.txt.sorted; do
+    for c in 0 1048576; do
+        echo == cache-size=$c
+        echo data: $f
+        out=$f.taxonkit-cache-$c.lineage
+        memusg -t -H -s " taxonkit lineage --delimiter \"; \" --threads 4 --cache-size $c < $f > $out "
+        check $out
+    done
+done
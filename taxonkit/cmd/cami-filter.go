@@ -27,6 +27,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -173,7 +174,7 @@ Examples:
 
 		items := make([]string, n)
 		var _line, line string
-		var _taxid int
+		var _taxid uint32
 		var taxid uint32
 		var taxids []string
 		var taxidsUint []uint32
@@ -268,11 +269,11 @@ Examples:
 
 			hasData = true
 
-			_taxid, err = strconv.Atoi(items[fieldTaxid])
+			taxid, err = taxonomy.ParseTaxId(items[fieldTaxid])
 			if err != nil {
+				checkTaxIdRange(err)
 				checkError(fmt.Errorf("failed to parse taxid: %s. line: %s", items[fieldTaxid], line))
 			}
-			taxid = uint32(_taxid)
 
 			rank = items[fieldRank]
 			rankMap[taxid] = rank
@@ -296,12 +297,13 @@ Examples:
 				if taxidS == "" {
 					_taxid = 0
 				} else {
-					_taxid, err = strconv.Atoi(taxidS)
+					_taxid, err = taxonomy.ParseTaxId(taxidS)
 					if err != nil {
+						checkTaxIdRange(err)
 						checkError(fmt.Errorf("failed to parse taxid: %s. taxpath: %s", taxidS, taxpath))
 					}
 				}
-				taxidsUint = append(taxidsUint, uint32(_taxid))
+				taxidsUint = append(taxidsUint, _taxid)
 			}
 
 			targets = append(targets, &Target{
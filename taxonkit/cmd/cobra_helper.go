@@ -21,9 +21,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"syscall"
 
 	"github.com/shenwei356/util/stringutil"
 	"github.com/spf13/cobra"
@@ -31,7 +33,22 @@ import (
 
 func checkError(err error) {
 	if err != nil {
+		// the downstream reader, e.g., "head", has exited. writes to stdout
+		// are handled by the Go runtime with SIGPIPE, while errors of other
+		// writes, e.g., flushing in closing, come here. exit quietly with the
+		// conventional status of SIGPIPE (128+13).
+		if errors.Is(err, syscall.EPIPE) {
+			stopProfiling()
+			discardAtomicOutput()
+			os.Exit(141)
+		}
+
 		log.Error(err)
+		if logToFile { // do not hide the reason of exiting
+			fmt.Fprintf(os.Stderr, "[ERRO] %s\n", err)
+		}
+		stopProfiling()
+		discardAtomicOutput()
 		os.Exit(-1)
 	}
 }
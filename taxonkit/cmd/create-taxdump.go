@@ -33,6 +33,7 @@ import (
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -48,7 +49,29 @@ Input format:
   0. For GTDB taxonomy file, just use --gtdb.
      We use the numeric assembly accession as the taxon at subspecies rank.
      (without the prefix GCA_ and GCF_, and version number).
+     For GTDB metadata file (e.g., bac120_metadata_r207.tsv), use --gtdb-metadata,
+     the columns "accession" and "gtdb_taxonomy" are located via the header line.
+     GTDB lineages are parsed by rank prefixes in order:
+       d__ (superkingdom), p__ (phylum), c__ (class), o__ (order),
+       f__ (family), g__ (genus), s__ (species).
   1. The input file should be tab-delimited, at least one column is needed.
+     Other input formats can be chosen via --format:
+       tsv:           tab-delimited lineages described here (default).
+       gtdb:          GTDB taxonomy files, the same as --gtdb.
+       gtdb-metadata: GTDB metadata files, the same as --gtdb-metadata.
+       mmseqs:        tab-delimited files with the sequence/genome ID in the first
+                      column, and the lineage in the last column, e.g., the output
+                      of "mmseqs createtsv" with "--tax-lineage 1":
+                        -_cellular organisms;d_Bacteria;p_Pseudomonadota;...;s_Escherichia coli
+                      Taxa with rank prefixes of d_ (superkingdom), k_ (kingdom),
+                      p_, c_, o_, f_, g_, and s_ are kept, while those of no rank (-_)
+                      are skipped. IDs in the first column are saved to taxid.map.
+                      Records with no ranked taxa, e.g., unclassified ones, are skipped
+                      with a warning.
+       kraken2:       a Kraken2 database directory, or its "taxonomy" directory,
+                      containing NCBI-style nodes.dmp and names.dmp.
+                      TaxIds, ranks, and names are kept as they are, and
+                      seqid2taxid.map in the database directory is saved to taxid.map.
   2. Ranks can be given either via the first row or the flag --rank-names.
   3. The column containing the genome/assembly accession is recommended to
      generate TaxId mapping file (taxid.map, id -> taxid).
@@ -56,8 +79,17 @@ Input format:
        --field-accession-re,    regular expression to extract the accession
      Note that mutiple TaxIds pointing to the same accession are listed as
      comma-seperated integers. 
+     The mapping can also be saved in the NCBI accession2taxid format
+     (--acc2taxid) for building databases of tools like Kraken2 and Centrifuge,
+     with four tab-delimited columns and a header line:
+       accession, accession.version, taxid, gi
+     where the version suffix (e.g., ".1") is removed in the first column if
+     present, gi is always 0, and an accession with mutiple TaxIds has one
+     line for each TaxId.
 
 Attention:
+  0. TaxIds are deterministic, i.e., they are stable across runs for the same
+     input, as they are computed from hash values of ranks and taxon names.
   1. Duplicated taxon names wit different ranks are allowed since v0.16.0, since
      the rank and taxon name are contatenated for generating the TaxId.
   2. The generated TaxIds are not consecutive numbers, however some tools like MMSeqs2
@@ -72,6 +104,37 @@ Attention:
      So a single version of taxonomic data created by "taxonkit create-taxdump" has no problem,
      it's just the changelog might not be perfect.
 
+  4. To keep TaxIds stable when rebuilding from an updated taxonomy, use --taxid-map.
+     The file (tab-delimited: lineage-key, taxid, [deleted]) is read if existed,
+     and updated after the run, so taxa with known lineage keys reuse their TaxIds,
+     new taxa get TaxIds not used in the file, and TaxIds of removed taxa are saved
+     to delnodes.dmp and never reused for other taxa.
+     A lineage key is made of "rank:name" (lower case) of all non-null taxa from
+     the top to the taxon, joined with ";", e.g., "genus:escherichia" is a different
+     key from "family:enterobacteriaceae;genus:escherichia".
+
+  5. Use --verify to load the generated files again and run the checks of
+     "taxonkit validate", plus that all TaxIds in taxid.map exist in nodes.dmp.
+     It exits with a non-zero status if any problems are found.
+
+Merging multiple taxdump directories (--merge):
+  1. Taxdump directories (e.g., NCBI Taxonomy and a custom one) are given as
+     positional arguments, records of the first one are preferred on conflicts
+     by default (--prefer-first), use --prefer-last to reverse the priority.
+  2. Conflicts are resolved as below:
+       taxid:    the same TaxId is used by different taxa (different ranks or
+                 scientific names), or used as a merged/deleted TaxId in the
+                 preferred taxdump. A new TaxId is assigned to the other taxon.
+       name:     the same taxon (same rank and scientific name) has different TaxIds.
+                 The other TaxId is merged into the preferred one in merged.dmp.
+       parent:   the same taxon has different parents, the preferred one is kept.
+       merged/delnodes: a merged/deleted TaxId is used in the preferred taxdump,
+                 the record is discarded.
+  3. Names of all classes are kept. Resolved conflicts are saved to conflicts.tsv.
+  4. Example:
+       taxonkit create-taxdump --merge ncbi/ custom/ -O merged/
+
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -84,6 +147,93 @@ Attention:
 		}
 		runtime.GOMAXPROCS(config.Threads)
 
+		preferFirst := getFlagBool(cmd, "prefer-first")
+		preferLast := getFlagBool(cmd, "prefer-last")
+		if preferFirst && preferLast {
+			checkError(fmt.Errorf("flag --prefer-first and --prefer-last are not compatible"))
+		}
+
+		if getFlagBool(cmd, "merge") {
+			if len(args) < 2 {
+				checkError(fmt.Errorf("at least two taxdump directories needed for --merge"))
+			}
+			if getFlagBool(cmd, "gtdb") || getFlagBool(cmd, "gtdb-metadata") || getFlagString(cmd, "old-taxdump-dir") != "" || getFlagString(cmd, "taxid-map") != "" {
+				checkError(fmt.Errorf("flag --merge is not compatible with --gtdb, --gtdb-metadata, -x/--old-taxdump-dir, and --taxid-map"))
+			}
+
+			outDir := getFlagString(cmd, "out-dir")
+			if outDir == "" {
+				checkError(fmt.Errorf("flag -O/--out-dir is needed"))
+			}
+			makeOutDir(outDir, getFlagBool(cmd, "force"))
+
+			sources := make([]*taxdumpSource, len(args))
+			for i, dir := range args {
+				log.Infof("loading taxdump files from: %s", dir)
+				sources[i] = loadTaxdumpSource(dir)
+				log.Infof("  %d nodes, %d merged and %d deleted TaxIds loaded",
+					len(sources[i].Nodes), len(sources[i].Merged), len(sources[i].DelNodes))
+			}
+			if preferLast {
+				for i, j := 0, len(sources)-1; i < j; i, j = i+1, j-1 {
+					sources[i], sources[j] = sources[j], sources[i]
+				}
+			}
+
+			merged, conflicts := mergeTaxdumpSources(sources)
+			log.Info()
+
+			writeTaxdump(merged, outDir)
+
+			fileConflicts := filepath.Join(outDir, "conflicts.tsv")
+			writeTaxdumpConflicts(conflicts, fileConflicts)
+			log.Infof("%d resolved conflicts saved to %s", len(conflicts), fileConflicts)
+
+			if getFlagBool(cmd, "verify") {
+				verifyTaxdump(outDir, nil)
+			}
+			return
+		}
+		if preferFirst || preferLast {
+			checkError(fmt.Errorf("flag --prefer-first and --prefer-last should be used along with --merge"))
+		}
+
+		isGTDB := getFlagBool(cmd, "gtdb")
+		gtdbMetadata := getFlagBool(cmd, "gtdb-metadata")
+		var isMMseqs bool
+
+		format := strings.ToLower(getFlagString(cmd, "format"))
+		if format != "tsv" && (isGTDB || gtdbMetadata) {
+			checkError(fmt.Errorf("flag --format is not compatible with --gtdb and --gtdb-metadata"))
+		}
+		switch format {
+		case "tsv":
+		case "gtdb":
+			isGTDB = true
+		case "gtdb-metadata":
+			gtdbMetadata = true
+		case "mmseqs":
+			isMMseqs = true
+		case "kraken2":
+			if len(args) != 1 {
+				checkError(fmt.Errorf("one Kraken2 database or taxonomy directory needed for --format kraken2"))
+			}
+			if getFlagString(cmd, "old-taxdump-dir") != "" || getFlagString(cmd, "taxid-map") != "" || getFlagString(cmd, "acc2taxid") != "" {
+				checkError(fmt.Errorf("flag --format kraken2 is not compatible with -x/--old-taxdump-dir, --taxid-map, and --acc2taxid"))
+			}
+
+			outDir := getFlagString(cmd, "out-dir")
+			if outDir == "" {
+				checkError(fmt.Errorf("flag -O/--out-dir is needed"))
+			}
+			makeOutDir(outDir, getFlagBool(cmd, "force"))
+
+			createTaxdumpFromKraken2(args[0], outDir, getFlagBool(cmd, "verify"))
+			return
+		default:
+			checkError(fmt.Errorf("unsupported input format: %s, available: tsv, gtdb, gtdb-metadata, mmseqs, kraken2", format))
+		}
+
 		fAccession := getFlagNonNegativeInt(cmd, "field-accession")
 		accAssubspe := getFlagBool(cmd, "field-accession-as-subspecies")
 
@@ -91,7 +241,9 @@ Attention:
 
 		var err error
 
-		isGTDB := getFlagBool(cmd, "gtdb")
+		if gtdbMetadata {
+			isGTDB = true
+		}
 
 		reGenomeIDStr := getFlagString(cmd, "field-accession-re")
 
@@ -136,12 +288,19 @@ Attention:
 			numFields = 2
 			hasAccession = true
 			rankNames = []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "no rank"}
+		} else if isMMseqs {
+			numFields = 2
+			hasAccession = true
+			rankNames = mmseqsRanks
 		} else {
 			hasAccession = fAccession > 0
 
 			if accAssubspe && !hasAccession {
 				checkError(fmt.Errorf("flag -S/--field-accession-as-subspecies should be used along with -A/--field-accession "))
 			}
+			if !hasAccession && getFlagString(cmd, "acc2taxid") != "" {
+				checkError(fmt.Errorf("flag --acc2taxid should be used along with -A/--field-accession"))
+			}
 
 			if len(rankNames) == 0 {
 				log.Infof("I will use the first row of input as rank names")
@@ -180,7 +339,10 @@ Attention:
 		if oldTaxdumpDir != "" {
 			log.Infof("loading Taxonomy from: %s", oldTaxdumpDir)
 
-			taxdb, err = taxdump.NewTaxonomyWithRankFromNCBI(filepath.Join(oldTaxdumpDir, "nodes.dmp"))
+			err = withLocalDumpFile(dumpFile(oldTaxdumpDir, "nodes.dmp"), func(file string) (err error) {
+				taxdb, err = taxdump.NewTaxonomyWithRankFromNCBI(file)
+				return err
+			})
 			if err != nil {
 				checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
 			}
@@ -193,7 +355,7 @@ Attention:
 
 			go func() {
 				defer wg.Done()
-				err = taxdb.LoadNamesFromNCBI(filepath.Join(oldTaxdumpDir, "names.dmp"))
+				err = withLocalDumpFile(dumpFile(oldTaxdumpDir, "names.dmp"), taxdb.LoadNamesFromNCBI)
 				if err != nil {
 					checkError(fmt.Errorf("err on loading Taxonomy names: %s", err))
 				}
@@ -202,13 +364,13 @@ Attention:
 
 			go func() {
 				defer wg.Done()
-				file := filepath.Join(oldTaxdumpDir, "delnodes.dmp")
-				existed, err = pathutil.Exists(file)
+				file := dumpFile(oldTaxdumpDir, "delnodes.dmp")
+				existed, err = taxonomy.Exists(file)
 				if err != nil {
 					checkError(fmt.Errorf("err on checking file delnodes.dmp: %s", err))
 				}
 				if existed {
-					err = taxdb.LoadDeletedNodesFromNCBI(file)
+					err = withLocalDumpFile(file, taxdb.LoadDeletedNodesFromNCBI)
 					if err != nil {
 						checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
 					}
@@ -218,13 +380,13 @@ Attention:
 
 			go func() {
 				defer wg.Done()
-				file := filepath.Join(oldTaxdumpDir, "merged.dmp")
-				existed, err = pathutil.Exists(file)
+				file := dumpFile(oldTaxdumpDir, "merged.dmp")
+				existed, err = taxonomy.Exists(file)
 				if err != nil {
 					checkError(fmt.Errorf("err on checking file merged.dmp: %s", err))
 				}
 				if existed {
-					err = taxdb.LoadMergedNodesFromNCBI(file)
+					err = withLocalDumpFile(file, taxdb.LoadMergedNodesFromNCBI)
 					if err != nil {
 						checkError(fmt.Errorf("err on loading Taxonomy merged nodes: %s", err))
 					}
@@ -238,6 +400,14 @@ Attention:
 
 		// ------------------------------------------------------------
 
+		var tmap *taxidMap
+		if taxidMapFile := getFlagString(cmd, "taxid-map"); taxidMapFile != "" {
+			tmap = loadTaxidMap(taxidMapFile)
+			log.Infof("%d records (%d deleted) loaded from TaxId map file: %s", tmap.NLoaded, len(tmap.Deleted), taxidMapFile)
+		}
+
+		// ------------------------------------------------------------
+
 		nullMap := make(map[string]interface{})
 		for _, k := range nulls {
 			nullMap[k] = struct{}{}
@@ -251,6 +421,9 @@ Attention:
 		var reGenomeIDNotCaptured bool
 		var reGenomeIDNotCapturedExample string
 
+		var nMMseqsUnclassified int
+		var mmseqsUnclassifiedExample string
+
 		// child -> parent
 		tree := make(map[uint32]uint32, 1<<16)
 
@@ -281,6 +454,7 @@ Attention:
 
 			var n int
 			isFirstLine := true
+			var gtdbIdxAcc, gtdbIdxTaxonomy, gtdbNumCols int
 
 			var items *[]string
 			if isGTDB || len(rankNames) > 0 {
@@ -310,6 +484,29 @@ Attention:
 				}
 
 				if isFirstLine {
+					if gtdbMetadata {
+						gtdbIdxAcc, gtdbIdxTaxonomy = -1, -1
+						for i, val := range strings.Split(line, "\t") {
+							switch val {
+							case "accession":
+								gtdbIdxAcc = i
+							case "gtdb_taxonomy":
+								gtdbIdxTaxonomy = i
+							}
+						}
+						if gtdbIdxAcc < 0 || gtdbIdxTaxonomy < 0 {
+							checkError(fmt.Errorf(`column "accession" or "gtdb_taxonomy" not found in the header line of GTDB metadata file: %s`, file))
+						}
+						gtdbNumCols = gtdbIdxAcc + 1
+						if gtdbIdxTaxonomy >= gtdbNumCols {
+							gtdbNumCols = gtdbIdxTaxonomy + 1
+						}
+
+						isFirstLine = false
+
+						continue
+					}
+
 					if useFirstRow {
 						items0 = strings.Split(line, "\t")
 						numFields = len(items0)
@@ -374,7 +571,18 @@ Attention:
 				// stringSplitNByByte(line, '\t', numFields, items)
 				*items = strings.Split(line, "\t")
 
-				if !isGTDB {
+				if gtdbMetadata {
+					if len(*items) < gtdbNumCols {
+						checkError(fmt.Errorf("expect at least %d columns, while only %d given at line %d ", gtdbNumCols, len(*items), n))
+					}
+					*items = []string{(*items)[gtdbIdxAcc], (*items)[gtdbIdxTaxonomy]}
+				}
+
+				if isMMseqs {
+					if len(*items) < numFields {
+						checkError(fmt.Errorf("expect at least %d columns, while only %d given at line %d ", numFields, len(*items), n))
+					}
+				} else if !isGTDB {
 					if len(*items) != numFields {
 						if hasAccession && !accAssubspe {
 							checkError(fmt.Errorf("the number (%d, expect %d) of columns at line %d does not match #rank-names + 1 (%d+1): %s", len(*items), numFields, n, len(rankNames), file))
@@ -476,6 +684,55 @@ Attention:
 
 						t.TaxIds[j] = uint32(xxhash.Sum64String(rankNames[j]+strings.ToLower(t.Names[j])) & 2147483647)
 					}
+				} else if isMMseqs {
+					val = (*items)[0]
+					t.Accession = val
+					if reGenomeID != nil {
+						found := reGenomeID.FindAllStringSubmatch(val, 1)
+						if len(found) == 0 {
+							reGenomeIDNotCaptured = true
+							reGenomeIDNotCapturedExample = val
+						} else {
+							t.Accession = found[0][1]
+						}
+					}
+
+					t.Names = make([]string, len(mmseqsRanks))
+					t.TaxIds = make([]uint32, len(mmseqsRanks))
+
+					for _, val = range strings.Split((*items)[len(*items)-1], ";") {
+						if val == "" {
+							continue
+						}
+						if len(val) < 2 || val[1] != '_' {
+							checkError(fmt.Errorf("invalid mmseqs taxonomy lineage format: %s", val))
+						}
+						if val[0] == '-' { // no rank
+							continue
+						}
+						if j, ok = mmseqsRankPrefixes[val[0]]; !ok {
+							checkError(fmt.Errorf("unknown rank prefix of mmseqs taxonomy lineage: %s", val))
+						}
+						t.Names[j] = val[2:]
+					}
+
+					var classified bool
+					for j = 0; j < len(mmseqsRanks); j++ {
+						if _, ok = nullMap[t.Names[j]]; ok {
+							continue
+						}
+
+						t.TaxIds[j] = uint32(xxhash.Sum64String(rankNames[j]+strings.ToLower(t.Names[j])) & 2147483647)
+						classified = true
+					}
+
+					if !classified { // empty lineage, e.g., unclassified sequences
+						nMMseqsUnclassified++
+						if mmseqsUnclassifiedExample == "" {
+							mmseqsUnclassifiedExample = (*items)[0]
+						}
+						continue
+					}
 				} else {
 					// var ok bool
 
@@ -515,6 +772,12 @@ Attention:
 
 				// ------------------------------------
 
+				var keys []string
+				if tmap != nil {
+					keys = tmap.keys(rankNames, &t)
+					tmap.assign(keys, &t)
+				}
+
 				first = true
 				for i = len(t.TaxIds) - 1; i >= 0; i-- {
 					taxid = t.TaxIds[i]
@@ -566,6 +829,9 @@ Attention:
 							log.Infof(`  assign a new TaxId for "%s" (rank: %s): %d -> %d`, names[taxid], rankNames[i], taxid, taxid+1)
 						}
 						taxid++
+						if tmap != nil {
+							taxid = tmap.nextFree(taxid)
+						}
 						t.TaxIds[i] = taxid
 						goto REASSIGNTAXID
 					}
@@ -593,6 +859,10 @@ Attention:
 
 				// the highest node
 				tree[t.TaxIds[prev]] = 1
+
+				if tmap != nil {
+					tmap.record(keys, &t)
+				}
 			}
 
 			if err = scanner.Err(); err != nil {
@@ -605,6 +875,10 @@ Attention:
 			log.Warningf("--gtdb-re-subs failed to extract ID for subspecies, the origninal value is used instead. e.g., %s", reGTDBsubspeNotCapturedExample)
 		}
 
+		if nMMseqsUnclassified > 0 {
+			log.Warningf("%d records with no ranked taxa in the lineage are skipped, e.g., %s", nMMseqsUnclassified, mmseqsUnclassifiedExample)
+		}
+
 		// ------------------------------- taxid.map -------------------------
 
 		if hasAccession {
@@ -645,6 +919,12 @@ Attention:
 			}
 
 			log.Infof("%d records saved to %s", len(acc2taxid), fileAcc2Taxid)
+
+			if file := getFlagString(cmd, "acc2taxid"); file != "" {
+				file = filepath.Join(outDir, file)
+				n := writeAccession2Taxid(file, accs, acc2taxid)
+				log.Infof("%d records saved to %s", n, file)
+			}
 		}
 
 		// ------------------------------- nodes.dmp -------------------------
@@ -841,43 +1121,118 @@ Attention:
 
 				delnodes[child] = struct{}{}
 			}
+		}
 
-			// --------------------------------- write -----------------------------------
-
-			// -------------- write delnodes.dmp ------------
+		// --------------------- removed taxa in TaxId map ---------------------
 
-			taxids := make([]uint32, 0, len(delnodes))
-			for child := range delnodes {
-				taxids = append(taxids, child)
+		if tmap != nil {
+			if delnodes == nil {
+				delnodes = make(map[uint32]interface{}, 1024)
 			}
-			sort.Slice(taxids, func(i, j int) bool {
-				return taxids[i] > taxids[j]
-			})
-
-			for _, child := range taxids {
-				fmt.Fprintf(outfhDelNodes, "%d\t|\n", child)
+			for _, child := range tmap.removed() {
+				if _, ok = tree[child]; ok {
+					continue
+				}
+				if _, ok = merged[child]; ok {
+					continue
+				}
+				delnodes[child] = struct{}{}
 			}
 
-			// -------------- write merged.dmp ------------
+			tmap.write()
+			log.Infof("%d records (%d deleted) saved to %s", len(tmap.Key2Taxid), len(tmap.Deleted), tmap.File)
+		}
 
-			taxids = taxids[:0]
-			for child := range merged {
-				taxids = append(taxids, child)
-			}
-			sort.Slice(taxids, func(i, j int) bool {
-				return taxids[i] < taxids[j]
-			})
+		// --------------------------------- write -----------------------------------
 
-			for _, child := range taxids {
-				fmt.Fprintf(outfhMerged, "%d\t|\t%d\t|\n", child, merged[child])
-			}
+		// -------------- write delnodes.dmp ------------
+
+		taxids = taxids[:0]
+		for child := range delnodes {
+			taxids = append(taxids, child)
+		}
+		sort.Slice(taxids, func(i, j int) bool {
+			return taxids[i] > taxids[j]
+		})
+
+		for _, child := range taxids {
+			fmt.Fprintf(outfhDelNodes, "%d\t|\n", child)
+		}
+
+		// -------------- write merged.dmp ------------
+
+		taxids = taxids[:0]
+		for child := range merged {
+			taxids = append(taxids, child)
+		}
+		sort.Slice(taxids, func(i, j int) bool {
+			return taxids[i] < taxids[j]
+		})
+
+		for _, child := range taxids {
+			fmt.Fprintf(outfhMerged, "%d\t|\t%d\t|\n", child, merged[child])
 		}
 
 		log.Infof("%d records saved to %s", len(merged), fileMerged)
 		log.Infof("%d records saved to %s", len(delnodes), fileDelNodes)
+
+		// ------------------------------- verify -------------------------
+
+		if getFlagBool(cmd, "verify") {
+			checkError(outfhNodes.Close())
+			checkError(outfhNames.Close())
+			checkError(outfhMerged.Close())
+			checkError(outfhDelNodes.Close())
+
+			verifyTaxdump(outDir, acc2taxid)
+		}
 	},
 }
 
+// verifyTaxdump loads generated taxdump files, runs the checks of
+// "taxonkit validate", and checks that TaxIds in taxid.map exist in nodes.dmp.
+func verifyTaxdump(outDir string, acc2taxid map[string]*map[uint32]interface{}) {
+	log.Infof("verifying generated taxdump files in %s", outDir)
+
+	tree, _ := getNodes(dumpFile(outDir, "nodes.dmp"), false)
+	names := getTaxonNames(dumpFile(outDir, "names.dmp"))
+	delnodes := getDelnodesMap(dumpFile(outDir, "delnodes.dmp"))
+	merged := getMergedNodesMap(dumpFile(outDir, "merged.dmp"))
+
+	problems := validateTaxdump(tree, names, delnodes, merged)
+
+	checks := append(append([]string{}, validationChecks...), "taxid-map")
+	var ok bool
+	for _, taxids := range acc2taxid {
+		for taxid := range *taxids {
+			if _, ok = tree[taxid]; !ok {
+				problems["taxid-map"] = append(problems["taxid-map"], taxid)
+			}
+		}
+	}
+
+	var nProblems int
+	var taxids []uint32
+	for _, check := range checks {
+		taxids = problems[check]
+		if len(taxids) == 0 {
+			continue
+		}
+		nProblems += len(taxids)
+
+		sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+		if len(taxids) > 5 {
+			taxids = taxids[:5]
+		}
+		log.Errorf("check %s failed for %d TaxIds, e.g., %s", check, len(problems[check]), joinUint32s(taxids, ","))
+	}
+
+	if nProblems > 0 {
+		checkError(fmt.Errorf("%d problems found in generated taxdump files: %s", nProblems, outDir))
+	}
+	log.Infof("no problems found in generated taxdump files: %s", outDir)
+}
+
 func init() {
 	RootCmd.AddCommand(createTaxDumpCmd)
 
@@ -887,7 +1242,9 @@ func init() {
 	// -------------------------------------------------------------------
 
 	createTaxDumpCmd.Flags().BoolP("gtdb", "", false, "input files are GTDB taxonomy file")
+	createTaxDumpCmd.Flags().BoolP("gtdb-metadata", "", false, `input files are GTDB metadata files with a header line, "--gtdb" is automatically switched on`)
 	createTaxDumpCmd.Flags().StringP("gtdb-re-subs", "", `^\w\w_GC[AF]_(.+)\.\d+$`, `regular expression to extract assembly accession as the subspecies`)
+	createTaxDumpCmd.Flags().StringP("format", "", "tsv", `input format, available values: tsv, gtdb, gtdb-metadata, mmseqs, kraken2`)
 
 	// --------------
 
@@ -905,7 +1262,134 @@ func init() {
 
 	// --------------
 	createTaxDumpCmd.Flags().StringP("old-taxdump-dir", "x", "", `taxdump directory of the previous version, for generating merged.dmp and delnodes.dmp`)
+	createTaxDumpCmd.Flags().BoolP("verify", "", false, `load the generated files again and check them like "taxonkit validate", and that all TaxIds in taxid.map exist in nodes.dmp. exit with a non-zero status if any problems are found`)
+	createTaxDumpCmd.Flags().StringP("acc2taxid", "", "", `also save the accession -> TaxId mapping to this file in -O/--out-dir in the NCBI accession2taxid format, e.g., "genomes.accession2taxid". type "taxonkit create-taxdump --help" for details`)
+	createTaxDumpCmd.Flags().StringP("taxid-map", "", "", `file of lineage-key -> TaxId mapping, read if existed and updated after the run, for assigning stable TaxIds across rebuilds`)
+
+	// --------------
+	createTaxDumpCmd.Flags().BoolP("merge", "", false, `merge multiple taxdump directories given as positional arguments into one`)
+	createTaxDumpCmd.Flags().BoolP("prefer-first", "", false, `for --merge, prefer records of the former taxdump directories on conflicts (default)`)
+	createTaxDumpCmd.Flags().BoolP("prefer-last", "", false, `for --merge, prefer records of the latter taxdump directories on conflicts`)
+
+}
+
+// mmseqsRanks are ranks of the one-letter prefixes in mmseqs taxonomy lineages.
+var mmseqsRanks = []string{"superkingdom", "kingdom", "phylum", "class", "order", "family", "genus", "species"}
+
+// mmseqsRankPrefixes maps rank prefixes in mmseqs taxonomy lineages to indexes of mmseqsRanks.
+var mmseqsRankPrefixes = map[byte]int{'d': 0, 'k': 1, 'p': 2, 'c': 3, 'o': 4, 'f': 5, 'g': 6, 's': 7}
+
+// reAccessionVersion matches accessions with a version suffix, e.g., NC_000913.3.
+var reAccessionVersion = regexp.MustCompile(`^(.+)\.\d+$`)
+
+// writeAccession2Taxid saves the accession -> TaxIds mapping in the NCBI
+// accession2taxid format, and returns the number of records.
+func writeAccession2Taxid(file string, accs []string, acc2taxid map[string]*map[uint32]interface{}) int {
+	outfh, err := xopen.Wopen(file)
+	checkError(err)
+	defer func() {
+		checkError(outfh.Close())
+	}()
+
+	outfh.WriteString("accession\taccession.version\ttaxid\tgi\n")
+
+	var n int
+	taxids := make([]int, 0, 8)
+	var taxid uint32
+	var accNoVer string
+	var m []string
+	for _, acc := range accs {
+		accNoVer = acc
+		if m = reAccessionVersion.FindStringSubmatch(acc); m != nil {
+			accNoVer = m[1]
+		}
+
+		taxids = taxids[:0]
+		for taxid = range *acc2taxid[acc] {
+			taxids = append(taxids, int(taxid))
+		}
+		sort.Ints(taxids)
+
+		for _, t := range taxids {
+			fmt.Fprintf(outfh, "%s\t%s\t%d\t0\n", accNoVer, acc, t)
+			n++
+		}
+	}
+	return n
+}
+
+// createTaxdumpFromKraken2 converts the taxonomy files of a Kraken2 database
+// to taxdump files, and seqid2taxid.map (if existed) to taxid.map.
+func createTaxdumpFromKraken2(dir string, outDir string, verify bool) {
+	taxDir := filepath.Join(dir, "taxonomy")
+	existed, err := pathutil.Exists(filepath.Join(taxDir, "nodes.dmp"))
+	checkError(err)
+	if !existed {
+		taxDir = dir
+		existed, err = pathutil.Exists(filepath.Join(taxDir, "nodes.dmp"))
+		checkError(err)
+		if !existed {
+			checkError(fmt.Errorf("nodes.dmp not found in Kraken2 database directory or its taxonomy directory: %s", dir))
+		}
+	}
+
+	log.Infof("loading Kraken2 taxonomy files from: %s", taxDir)
+	s := loadTaxdumpSource(taxDir)
+	log.Infof("  %d nodes, %d merged and %d deleted TaxIds loaded", len(s.Nodes), len(s.Merged), len(s.DelNodes))
+	log.Info()
+
+	writeTaxdump(s, outDir)
+
+	// ------------------------------- taxid.map -------------------------
+
+	var acc2taxid map[string]*map[uint32]interface{}
+
+	fileSeqid2Taxid := filepath.Join(dir, "seqid2taxid.map")
+	existed, err = pathutil.Exists(fileSeqid2Taxid)
+	checkError(err)
+	if existed {
+		acc2taxid = make(map[string]*map[uint32]interface{}, 1<<16)
+
+		fh, err := xopen.Ropen(fileSeqid2Taxid)
+		checkError(err)
+
+		fileAcc2Taxid := filepath.Join(outDir, "taxid.map")
+		outfh, err := xopen.Wopen(fileAcc2Taxid)
+		checkError(err)
+
+		scanner := bufio.NewScanner(fh)
+		var line string
+		var items []string
+		var taxid uint32
+		var n int
+		for scanner.Scan() {
+			line = strings.Trim(scanner.Text(), "\r\n")
+			if line == "" {
+				continue
+			}
+			items = strings.Split(line, "\t")
+			if len(items) < 2 {
+				checkError(fmt.Errorf("invalid seqid2taxid.map record: %s", line))
+			}
+			taxid, err = taxonomy.ParseTaxId(items[1])
+			if err != nil {
+				checkError(fmt.Errorf("invalid TaxId in seqid2taxid.map: %s", line))
+			}
+			acc2taxid[items[0]] = &map[uint32]interface{}{taxid: struct{}{}}
 
+			fmt.Fprintf(outfh, "%s\t%d\n", items[0], taxid)
+			n++
+		}
+		checkError(scanner.Err())
+		checkError(fh.Close())
+		checkError(outfh.Close())
+
+		log.Infof("%d records saved to %s", n, fileAcc2Taxid)
+	}
+
+	if verify {
+		verifyTaxdump(outDir, acc2taxid)
+	}
 }
 
 type _Taxon struct {
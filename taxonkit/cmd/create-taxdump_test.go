@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/spf13/cobra"
+)
+
+// createTaxdumpTestFlags are flags used in tests, which are reset before each run.
+var createTaxdumpTestFlags = []string{"format", "gtdb", "gtdb-metadata", "out-dir", "force", "verify"}
+
+// runCommand runs a subcommand with the arguments, after resetting the flags,
+// which might be changed by previous runs.
+func runCommand(t testing.TB, cmd *cobra.Command, flags []string, args ...string) {
+	for _, name := range flags {
+		f := cmd.Flag(name)
+		if err := f.Value.Set(f.DefValue); err != nil {
+			t.Fatal(err)
+		}
+		f.Changed = false
+	}
+
+	RootCmd.SetArgs(append([]string{cmd.Name()}, args...))
+	if err := RootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runCreateTaxdump runs "taxonkit create-taxdump" with the arguments.
+func runCreateTaxdump(t *testing.T, args ...string) {
+	runCommand(t, createTaxDumpCmd, createTaxdumpTestFlags, args...)
+}
+
+// checkLineageNames checks scientific names in the lineage of the taxon with the given name.
+func checkLineageNames(t *testing.T, taxdb *taxonomy.Taxonomy, want []string) {
+	var taxid uint32
+	for id, name := range taxdb.Names {
+		if name == want[len(want)-1] {
+			taxid = id
+			break
+		}
+	}
+	if taxid == 0 {
+		t.Errorf("taxon not found: %s", want[len(want)-1])
+		return
+	}
+
+	lineage := taxdb.Lineage(taxid)
+	names := make([]string, len(lineage))
+	for i, id := range lineage {
+		names[i] = taxdb.Names[id]
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("lineage of %s: got %q, want %q", want[len(want)-1], names, want)
+	}
+}
+
+// TestCreateTaxdumpMMseqs creates a taxdump from mmseqs taxonomy results,
+// where records with no ranked taxa, i.e., unclassified ones and the ones
+// assigned to root, are skipped.
+func TestCreateTaxdumpMMseqs(t *testing.T) {
+	dir := filepath.Join("testdata", "create-taxdump", "mmseqs")
+	outDir := filepath.Join(t.TempDir(), "taxdump")
+	runCreateTaxdump(t, "--format", "mmseqs", filepath.Join(dir, "input.tsv"), "-O", outDir, "--verify")
+
+	checkSameFiles(t, outDir, filepath.Join(dir, "taxdump"),
+		[]string{"nodes.dmp", "names.dmp", "merged.dmp", "delnodes.dmp", "taxid.map"})
+
+	taxdb, err := taxonomy.Load(outDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLineageNames(t, taxdb, []string{"Bacteria", "Bacillota", "Bacilli", "Bacillales",
+		"Bacillaceae", "Bacillus", "Bacillus subtilis"})
+	checkLineageNames(t, taxdb, []string{"Archaea"})
+}
+
+// TestCreateTaxdumpKraken2 creates a taxdump from a Kraken2 database,
+// where TaxIds are kept and seqid2taxid.map is saved as taxid.map.
+func TestCreateTaxdumpKraken2(t *testing.T) {
+	dir := filepath.Join("testdata", "create-taxdump", "kraken2")
+	outDir := filepath.Join(t.TempDir(), "taxdump")
+	runCreateTaxdump(t, "--format", "kraken2", filepath.Join(dir, "db"), "-O", outDir, "--verify")
+
+	checkSameFiles(t, outDir, filepath.Join(dir, "taxdump"),
+		[]string{"nodes.dmp", "names.dmp", "merged.dmp", "delnodes.dmp", "taxid.map"})
+
+	taxdb, err := taxonomy.Load(outDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := taxdb.Lineage(562), []uint32{131567, 2, 1224, 561, 562}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lineage(562): got %v, want %v", got, want)
+	}
+	if rank := taxdb.Ranks[1224]; rank != "phylum" {
+		t.Errorf("unexpected rank of 1224: %s", rank)
+	}
+}
+
+// TestCreateTaxdumpGTDBMetadata creates a taxdump from a GTDB metadata file,
+// which should be the same as the one from the GTDB taxonomy file.
+func TestCreateTaxdumpGTDBMetadata(t *testing.T) {
+	dir := filepath.Join("testdata", "create-taxdump", "gtdb")
+	files := []string{"nodes.dmp", "names.dmp", "merged.dmp", "delnodes.dmp", "taxid.map"}
+
+	outDir := filepath.Join(t.TempDir(), "taxdump")
+	runCreateTaxdump(t, "--gtdb-metadata", filepath.Join(dir, "metadata.tsv"), "-O", outDir, "--verify")
+	checkSameFiles(t, outDir, filepath.Join(dir, "taxdump"), files)
+
+	taxdb, err := taxonomy.Load(outDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLineageNames(t, taxdb, []string{"Bacteria", "Pseudomonadota", "Gammaproteobacteria", "Enterobacterales",
+		"Enterobacteriaceae", "Escherichia", "Escherichia coli", "000008865"})
+
+	outDir2 := filepath.Join(t.TempDir(), "taxdump")
+	runCreateTaxdump(t, "--gtdb", filepath.Join(dir, "taxonomy.tsv"), "-O", outDir2)
+	checkSameFiles(t, outDir2, filepath.Join(dir, "taxdump"), files)
+}
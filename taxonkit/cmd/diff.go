@@ -0,0 +1,273 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare two versions of taxonomy data",
+	Long: `Compare two versions of taxonomy data
+
+Two data directories (--old and --new), each containing nodes.dmp, names.dmp,
+and optional delnodes.dmp and merged.dmp, are compared, and changes of TaxIds
+from the old version to the new one are reported.
+
+Changes:
+
+  added:      TaxIds in nodes.dmp of the new version but not of the old one
+  deleted:    TaxIds in nodes.dmp of the old version, but neither in nodes.dmp
+              nor merged.dmp of the new one
+  merged:     TaxIds in nodes.dmp of the old version, and merged into other
+              TaxIds in the new one
+  renamed:    scientific names changed
+  reparented: parents changed
+  rerank:     ranks changed
+
+Output (tab-delimited, or CSV with --csv):
+
+  1. TaxId
+  2. Change
+  3. Old value: old name (renamed), old parent (reparented), or old rank (rerank)
+  4. New value: new name (renamed), new parent (reparented), new rank (rerank),
+     or the TaxId merged into (merged)
+  5. Scientific name, in the new version if existing, or the old one
+
+Records are sorted by changes in the order above, and then by TaxIds.
+A TaxId can have multiple changes of renamed, reparented, and rerank.
+Numbers of each change are reported in the end, and --count only outputs them.
+
+Differences from "taxonkit taxid-changelog":
+
+  taxid-changelog tracks the full history of TaxIds from a series of archives,
+  while diff only compares two arbitrary snapshots, e.g., before and after
+  upgrading the data.
+
+Examples:
+
+    $ taxonkit diff --old taxdump-2023-01 --new taxdump-2023-02 -o diff.tsv
+    $ taxonkit diff --old taxdump-2023-01 --new taxdump-2023-02 --changes merged,deleted
+    $ taxonkit diff --old taxdump-2023-01 --new taxdump-2023-02 --count
+    change      count
+    added       1234
+    ...
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		oldDir := getFlagString(cmd, "old")
+		newDir := getFlagString(cmd, "new")
+		if oldDir == "" || newDir == "" {
+			checkError(fmt.Errorf("flag --old and --new are both needed"))
+		}
+		csvFormat := getFlagBool(cmd, "csv")
+		countOnly := getFlagBool(cmd, "count")
+
+		selected := make(map[string]bool, len(diffChanges))
+		for _, c := range getFlagStringSlice(cmd, "changes") {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if c == "" {
+				continue
+			}
+			if !isDiffChange(c) {
+				checkError(fmt.Errorf("invalid value of flag --changes: %s. available: %s", c, strings.Join(diffChanges, ", ")))
+			}
+			selected[c] = true
+		}
+		if len(selected) == 0 {
+			for _, c := range diffChanges {
+				selected[c] = true
+			}
+		}
+
+		// -------------------- load data ----------------------
+
+		var told, tnew *taxonomy.Taxonomy
+		var errOld, errNew error
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			told, errOld = taxonomy.Load(oldDir, true)
+			wg.Done()
+		}()
+		go func() {
+			tnew, errNew = taxonomy.Load(newDir, true)
+			wg.Done()
+		}()
+		wg.Wait()
+		checkError(errOld)
+		checkError(errNew)
+
+		if config.Verbose {
+			log.Infof("%d and %d nodes loaded from old and new versions", len(told.Nodes), len(tnew.Nodes))
+		}
+
+		// -------------------- compare ----------------------
+
+		records := diffTaxonomies(told, tnew, selected)
+
+		// -------------------- output ----------------------
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		counts := make(map[string]int, len(diffChanges))
+		for _, r := range records {
+			counts[r.change]++
+		}
+
+		if countOnly {
+			outfh.WriteString("change\tcount\n")
+			for _, c := range diffChanges {
+				if selected[c] {
+					outfh.WriteString(fmt.Sprintf("%s\t%d\n", c, counts[c]))
+				}
+			}
+			return
+		}
+
+		var writer *csv.Writer
+		if csvFormat {
+			writer = csv.NewWriter(outfh)
+		}
+		write := func(items []string) {
+			if writer != nil {
+				checkError(writer.Write(items))
+			} else {
+				outfh.WriteString(strings.Join(items, "\t") + "\n")
+			}
+		}
+
+		write([]string{"taxid", "change", "old", "new", "name"})
+		for _, r := range records {
+			write([]string{strconv.Itoa(int(r.taxid)), r.change, r.old, r.new, r.name})
+		}
+		if writer != nil {
+			writer.Flush()
+			checkError(writer.Error())
+		}
+
+		if config.Verbose {
+			for _, c := range diffChanges {
+				if selected[c] {
+					log.Infof("%s: %d", c, counts[c])
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringP("old", "", "", `directory of the old version of taxonomy data`)
+	diffCmd.Flags().StringP("new", "", "", `directory of the new version of taxonomy data`)
+	diffCmd.Flags().BoolP("csv", "", false, `output in CSV format instead of tab-delimited format`)
+	diffCmd.Flags().BoolP("count", "", false, `only output the number of each change`)
+	diffCmd.Flags().StringSliceP("changes", "", []string{}, `only output these changes, available: added, deleted, merged, renamed, reparented, rerank. multiple values can be separated with comma or give multiple times`)
+}
+
+// diffChanges are the changes reported by diffTaxonomies, in order.
+var diffChanges = []string{"added", "deleted", "merged", "renamed", "reparented", "rerank"}
+
+func isDiffChange(change string) bool {
+	for _, c := range diffChanges {
+		if c == change {
+			return true
+		}
+	}
+	return false
+}
+
+// diffRecord is a change of a TaxId between two versions of taxonomy data.
+type diffRecord struct {
+	taxid  uint32
+	change string
+	old    string
+	new    string
+	name   string
+}
+
+// diffTaxonomies compares two versions of taxonomy data, and returns the
+// selected changes, sorted by the order of changes in diffChanges and TaxIds.
+func diffTaxonomies(told, tnew *taxonomy.Taxonomy, selected map[string]bool) []diffRecord {
+	records := make([]diffRecord, 0, 1024)
+
+	var ok bool
+	var to, parent uint32
+	for taxid, oldParent := range told.Nodes {
+		if parent, ok = tnew.Nodes[taxid]; !ok {
+			if to, ok = tnew.Merged[taxid]; ok {
+				if selected["merged"] {
+					records = append(records, diffRecord{taxid, "merged", "", strconv.Itoa(int(to)), told.Names[taxid]})
+				}
+			} else if selected["deleted"] {
+				records = append(records, diffRecord{taxid, "deleted", "", "", told.Names[taxid]})
+			}
+			continue
+		}
+
+		if selected["renamed"] && told.Names[taxid] != tnew.Names[taxid] {
+			records = append(records, diffRecord{taxid, "renamed", told.Names[taxid], tnew.Names[taxid], tnew.Names[taxid]})
+		}
+		if selected["reparented"] && oldParent != parent {
+			records = append(records, diffRecord{taxid, "reparented", strconv.Itoa(int(oldParent)), strconv.Itoa(int(parent)), tnew.Names[taxid]})
+		}
+		if selected["rerank"] && told.Ranks[taxid] != tnew.Ranks[taxid] {
+			records = append(records, diffRecord{taxid, "rerank", told.Ranks[taxid], tnew.Ranks[taxid], tnew.Names[taxid]})
+		}
+	}
+
+	if selected["added"] {
+		for taxid := range tnew.Nodes {
+			if _, ok = told.Nodes[taxid]; !ok {
+				records = append(records, diffRecord{taxid, "added", "", "", tnew.Names[taxid]})
+			}
+		}
+	}
+
+	order := make(map[string]int, len(diffChanges))
+	for i, c := range diffChanges {
+		order[c] = i
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].change != records[j].change {
+			return order[records[i].change] < order[records[j].change]
+		}
+		return records[i].taxid < records[j].taxid
+	})
+
+	return records
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+)
+
+// TestDiffTaxonomies compares two versions of taxonomy data in testdata/diff,
+// which have all kinds of changes, and a TaxId (7) with multiple changes.
+func TestDiffTaxonomies(t *testing.T) {
+	told, err := taxonomy.Load(filepath.Join("testdata", "diff", "old"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tnew, err := taxonomy.Load(filepath.Join("testdata", "diff", "new"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := make(map[string]bool, len(diffChanges))
+	for _, c := range diffChanges {
+		all[c] = true
+	}
+	want := []diffRecord{
+		{9, "added", "", "", "Enterobacteriaceae"},
+		{10, "deleted", "", "", "Viruses"},
+		{6, "merged", "", "4", "Shigella"},
+		{3, "renamed", "Proteobacteria", "Pseudomonadota", "Pseudomonadota"},
+		{7, "renamed", "Shigella flexneri", "Escherichia flexneri", "Escherichia flexneri"},
+		{4, "reparented", "3", "9", "Escherichia"},
+		{7, "reparented", "6", "4", "Escherichia flexneri"},
+		{8, "rerank", "superkingdom", "domain", "Archaea"},
+	}
+	if got := diffTaxonomies(told, tnew, all); !reflect.DeepEqual(got, want) {
+		t.Errorf("all changes: got %v, want %v", got, want)
+	}
+
+	selected := map[string]bool{"merged": true, "deleted": true}
+	want = []diffRecord{
+		{10, "deleted", "", "", "Viruses"},
+		{6, "merged", "", "4", "Shigella"},
+	}
+	if got := diffTaxonomies(told, tnew, selected); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged and deleted: got %v, want %v", got, want)
+	}
+
+	if got := diffTaxonomies(told, told, all); len(got) != 0 {
+		t.Errorf("the same version: got %v, want none", got)
+	}
+}
@@ -23,10 +23,11 @@ package cmd
 import (
 	"bufio"
 	"fmt"
-	"strconv"
+	"os"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -41,18 +42,52 @@ var filterCmd = &cobra.Command{
 
 Attention:
 
-  1. Flag -L/--lower-than and -H/--higher-than are exclusive, and can be
-     used along with -E/--equal-to which values can be different.
-  2. A list of pre-ordered ranks is in ~/.taxonkit/ranks.txt, you can use
+  1. Flag -L/--lower-than and -H/--higher-than can be used separately for
+     an open-ended range, or together for a closed range, e.g.,
+     "-L phylum -H genus" for ranks between phylum and genus.
+     Both bounds are exclusive, i.e., "-L genus" does not output genera.
+     Use -E/--equal-to to also output the boundary ranks (or any other ranks),
+     e.g., "-L genus -E genus" for genera and ranks below genus.
+  2. When -E/--equal-to is used alone, only TaxIds of the given ranks are
+     outputted, i.e., TaxIds with no rank (see 7) are discarded, unless
+     ranks without order (e.g., "no rank") are also given to -E/--equal-to.
+     Ranks with the same order in the rank file (e.g., phylum and division)
+     are treated equally.
+
+    $ echo -e "9606\n63221\n9605\n131567" | taxonkit filter -E species
+    9606
+
+  3. A list of pre-ordered ranks is in ~/.taxonkit/ranks.txt, you can use
      your list by -r/--rank-file, the format specification is below.
-  3. All ranks in taxonomy database should be defined in rank file.
-  4. Ranks can be removed with black list via -B/--black-list.
-
-  5. TaxIDs with no rank are kept by default!!!
-     They can be optionally discarded by -N/--discard-noranks.
-  6. [Recommended] When filtering with -L/--lower-than, you can use
+  4. All ranks in taxonomy database should be defined in rank file.
+  5. Ranks can be removed with black list via -B/--black-list.
+  6. TaxIds in subtrees of some TaxIds (e.g., host and common contaminants)
+     can be removed via --exclude-taxids and/or --exclude-file.
+     On the contrary, only TaxIds in subtrees of some TaxIds (including
+     themselves) are kept via --root-taxids and/or --root-file, e.g.,
+     all bacterial species from a mixed input:
+
+    $ taxonkit filter -E species --root-taxids 2 taxids.txt
+
+  7. TaxIDs with no rank are kept by default!!!
+     They can be optionally discarded by -N/--discard-noranks,
+     which is applied before checking the rank range.
+  8. [Recommended] When filtering with -L/--lower-than, you can use
     -n/--save-predictable-norank to save some special ranks without order,
     where rank of the closest higher node is still lower than rank cutoff.
+  9. Use --stats to print numbers of passed and discarded records to stderr,
+     where discarded records are counted by reason:
+       invalid TaxId:     TaxId field is empty or not an integer
+       root:              root TaxId discarded by -R/--discard-root
+       excluded:          in subtrees of --exclude-taxids/--exclude-file
+       unfound:           TaxId not found or deleted
+       black-listed rank: rank in -B/--black-list
+       no rank:           ranks without order discarded by -N/--discard-noranks
+       rank not equal:    rank not given by -E/--equal-to (used alone)
+       rank too high:     rank not lower than -L/--lower-than
+       rank too low:      rank not higher than -H/--higher-than
+       outside roots:     passed rank filters but not in subtrees of
+                          --root-taxids/--root-file
 
 Rank file:
 
@@ -96,8 +131,17 @@ Rank file:
 
 		field := getFlagPositiveInt(cmd, "taxid-field") - 1
 
-		if higher != "" && lower != "" {
-			checkError(fmt.Errorf("-H/--higher-than and -L/--lower-than can't be simultaneous given"))
+		printStats := getFlagBool(cmd, "stats")
+
+		excludeIDs := getFlagTaxonIDs(cmd, "exclude-taxids")
+		excludeFile := getFlagString(cmd, "exclude-file")
+		if excludeFile != "" {
+			excludeIDs = append(excludeIDs, readTaxonIDsFromFile(excludeFile)...)
+		}
+		rootIDs := getFlagTaxonIDs(cmd, "root-taxids")
+		rootFile := getFlagString(cmd, "root-file")
+		if rootFile != "" {
+			rootIDs = append(rootIDs, readTaxonIDsFromFile(rootFile)...)
 		}
 
 		if saveNorank {
@@ -128,7 +172,7 @@ Rank file:
 			for r, o := range rankOrder {
 				orders = append(orders, stringutil.StringCount{Key: r, Count: o})
 			}
-			sorts.Quicksort(stringutil.ReversedStringCountList{orders})
+			sorts.Quicksort(stringutil.ReversedStringCountList{StringCountList: orders})
 			preOrder := -1
 			for _, order := range orders {
 				// fmt.Printf("%d\t%s\n", order.Count, order.Key)
@@ -146,7 +190,7 @@ Rank file:
 			return
 		}
 
-		taxondb := loadTaxonomy(&config, true)
+		taxondb := loadTaxonomy(&config, true, false)
 
 		if config.Verbose {
 			log.Infof("checking defined taxonomic rank order")
@@ -177,7 +221,7 @@ Rank file:
 				}
 				orders = append(orders, stringutil.StringCount{Key: rank, Count: rankOrder[rank]})
 			}
-			sorts.Quicksort(stringutil.ReversedStringCountList{orders})
+			sorts.Quicksort(stringutil.ReversedStringCountList{StringCountList: orders})
 			for _, order := range orders {
 				// fmt.Printf("%d\t%s\n", order.Count, order.Key)
 				fmt.Printf("%s\n", order.Key)
@@ -206,6 +250,23 @@ Rank file:
 			}
 		}
 
+		var excluded map[uint32]interface{}
+		var excludedCache map[uint32]bool // taxid -> whether in excluded subtrees
+		if len(excludeIDs) > 0 {
+			excluded = subtreeRoots(taxondb.Nodes, taxondb.MergeNodes, excludeIDs, "excluded")
+			excludedCache = make(map[uint32]bool, mapInitialSize)
+		}
+
+		var roots map[uint32]interface{}
+		var rootsCache map[uint32]bool // taxid -> whether in subtrees of roots
+		if len(rootIDs) > 0 {
+			roots = subtreeRoots(taxondb.Nodes, taxondb.MergeNodes, rootIDs, "root")
+			if len(roots) == 0 {
+				checkError(fmt.Errorf("none of TaxIds given by --root-taxids/--root-file are found"))
+			}
+			rootsCache = make(map[uint32]bool, mapInitialSize)
+		}
+
 		filter, err := newRankFilter(taxondb, rankOrder, noRanks, lower, higher, equals, blackListRanks, discardNoRank, saveNorank)
 		checkError(err)
 
@@ -213,6 +274,9 @@ Rank file:
 		checkError(err)
 		defer outfh.Close()
 
+		var nTotal, nInvalid, nRoot, nExcluded, nOutside int
+		nResults := make([]int, len(filterResults))
+
 		for _, file := range files {
 			fh, err := xopen.Ropen(file)
 			checkError(err)
@@ -221,14 +285,14 @@ Rank file:
 			var items []string
 
 			scanner := bufio.NewScanner(fh)
-			var _taxid int
 			var taxid uint32
-			var pass bool
+			var result filterResult
 			for scanner.Scan() {
 				line = strings.Trim(scanner.Text(), "\r\n ")
 				if line == "" {
 					continue
 				}
+				nTotal++
 
 				items = strings.Split(line, "\t")
 				if len(items) <= field {
@@ -236,28 +300,40 @@ Rank file:
 				}
 
 				if items[field] == "" {
+					nInvalid++
 					continue
 				}
 
-				_taxid, err = strconv.Atoi(items[field])
+				taxid, err = taxonomy.ParseTaxId(items[field])
 				if err != nil {
+					nInvalid++
 					continue
 				}
 
-				taxid = uint32(_taxid)
-
 				// ----------------------------------
 
 				if discardRoot && taxid == rootTaxid {
+					nRoot++
+					continue
+				}
+
+				if excluded != nil && inSubtrees(taxondb.Nodes, taxondb.MergeNodes, excluded, excludedCache, taxid) {
+					nExcluded++
 					continue
 				}
 
-				pass, err = filter.isPassed(taxid)
+				result, err = filter.check(taxid)
 				if err != nil {
 					checkError(err)
 				}
 
-				if !pass {
+				if result == filterPassed && roots != nil && !inSubtrees(taxondb.Nodes, taxondb.MergeNodes, roots, rootsCache, taxid) {
+					nOutside++
+					continue
+				}
+
+				nResults[result]++
+				if result != filterPassed {
 					continue
 				}
 
@@ -270,6 +346,17 @@ Rank file:
 			checkError(fh.Close())
 		}
 
+		if printStats {
+			fmt.Fprintf(os.Stderr, "total\t%d\n", nTotal)
+			fmt.Fprintf(os.Stderr, "%s\t%d\n", filterPassed, nResults[filterPassed])
+			fmt.Fprintf(os.Stderr, "invalid TaxId\t%d\n", nInvalid)
+			fmt.Fprintf(os.Stderr, "root\t%d\n", nRoot)
+			fmt.Fprintf(os.Stderr, "excluded\t%d\n", nExcluded)
+			for _, r := range filterResults[1:] {
+				fmt.Fprintf(os.Stderr, "%s\t%d\n", r, nResults[r])
+			}
+			fmt.Fprintf(os.Stderr, "outside roots\t%d\n", nOutside)
+		}
 	},
 }
 
@@ -287,9 +374,79 @@ func init() {
 	filterCmd.Flags().BoolP("discard-root", "R", false, `discard root taxid, defined by --root-taxid`)
 	filterCmd.Flags().Uint32P("root-taxid", "", 1, `root taxid`)
 
-	filterCmd.Flags().StringP("lower-than", "L", "", "output TaxIds with rank lower than a rank, exclusive with --higher-than")
-	filterCmd.Flags().StringP("higher-than", "H", "", "output TaxIds with rank higher than a rank, exclusive with --lower-than")
-	filterCmd.Flags().StringSliceP("equal-to", "E", []string{}, `output TaxIds with rank equal to some ranks, multiple values can be separated with comma "," (e.g., -E "genus,species"), or give multiple times (e.g., -E genus -E species)`)
+	filterCmd.Flags().StringP("lower-than", "L", "", "output TaxIds with rank lower than a rank (exclusive), can be used along with --higher-than")
+	filterCmd.Flags().StringP("higher-than", "H", "", "output TaxIds with rank higher than a rank (exclusive), can be used along with --lower-than")
+	filterCmd.Flags().StringSliceP("equal-to", "E", []string{}, `output TaxIds with rank equal to some ranks, multiple values can be separated with comma "," (e.g., -E "genus,species"), or give multiple times (e.g., -E genus -E species). when used without -L/--lower-than or -H/--higher-than, TaxIds with no rank are discarded unless their ranks are given`)
+
+	filterCmd.Flags().StringP("exclude-taxids", "", "", `discard TaxIds belonging to subtrees of these TaxIds, multiple values should be separated by comma`)
+	filterCmd.Flags().StringP("exclude-file", "", "", `file containing TaxIds of subtrees to discard, one TaxId per line`)
+	filterCmd.Flags().StringP("root-taxids", "", "", `only keep TaxIds belonging to subtrees of these TaxIds, multiple values should be separated by comma. not to be confused with --root-taxid`)
+	filterCmd.Flags().StringP("root-file", "", "", `file containing TaxIds of subtrees to keep, one TaxId per line`)
 
 	filterCmd.Flags().IntP("taxid-field", "i", 1, "field index of taxid. input data should be tab-separated")
+
+	filterCmd.Flags().BoolP("stats", "", false, `print numbers of passed and discarded records (by reason) to stderr, type "taxonkit filter --help" for details`)
+
+	for _, flag := range []string{"root-taxid", "exclude-taxids", "root-taxids"} {
+		checkError(filterCmd.RegisterFlagCompletionFunc(flag, completeTaxIds))
+	}
+}
+
+// subtreeRoots returns the set of TaxIds of subtrees, e.g., for --exclude-taxids,
+// where merged TaxIds are replaced by new ones, and unfound ones are skipped
+// with warnings. desc describes the TaxIds in warnings.
+func subtreeRoots(nodes map[uint32]uint32, merged map[uint32]uint32, ids []int, desc string) map[uint32]interface{} {
+	roots := make(map[uint32]interface{}, len(ids))
+	var taxid, taxid2 uint32
+	var ok bool
+	for _, id := range ids {
+		taxid = uint32(id)
+		if _, ok = nodes[taxid]; !ok {
+			if taxid2, ok = merged[taxid]; ok {
+				log.Warningf("%s taxid %d was merged into %d", desc, taxid, taxid2)
+				taxid = taxid2
+			} else {
+				log.Warningf("%s taxid %d not found", desc, taxid)
+				continue
+			}
+		}
+		roots[taxid] = struct{}{}
+	}
+	return roots
+}
+
+// inSubtrees checks whether a TaxId or any of its ancestors is in the given set,
+// e.g., TaxIds of excluded subtrees. Results of all nodes in the path are cached.
+func inSubtrees(nodes map[uint32]uint32, merged map[uint32]uint32,
+	set map[uint32]interface{}, cache map[uint32]bool, taxid uint32) bool {
+	if t, ok := merged[taxid]; ok {
+		if _, ok = nodes[taxid]; !ok {
+			taxid = t
+		}
+	}
+
+	path := make([]uint32, 0, 32)
+	var result, found, ok bool
+	var parent uint32
+	for {
+		if result, found = cache[taxid]; found {
+			break
+		}
+		path = append(path, taxid)
+		if _, ok = set[taxid]; ok {
+			result = true
+			break
+		}
+		parent, ok = nodes[taxid]
+		if !ok || parent == taxid {
+			result = false
+			break
+		}
+		taxid = parent
+	}
+
+	for _, t := range path {
+		cache[t] = result
+	}
+	return result
 }
@@ -61,6 +61,12 @@ fish:
 
     taxonkit genautocomplete --shell fish --file ~/.config/fish/completions/taxonkit.fish
 
+Values of flags of TaxIds (e.g., "taxonkit list --ids") are completed dynamically
+with TaxIds from the taxonomy data, matching either the prefix of TaxIds or
+the prefix of scientific names (case-insensitive), e.g., "--ids Escherichia<TAB>".
+The binary index (taxonkit.idx, see "taxonkit index") is used if present,
+which is much faster than parsing names.dmp.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		outfile := getFlagString(cmd, "file")
@@ -0,0 +1,63 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// indexCmd represents the index command
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Create binary index of taxonomy data for faster loading",
+	Long: `Create binary index of taxonomy data for faster loading
+
+Attention:
+
+  1. The index file "taxonkit.idx" is saved in the data directory.
+  2. The index contains TaxIds, parents, ranks, scientific names,
+     deleted TaxIds, and merged TaxIds, and it's automatically used by
+     commands "lineage", "reformat", and "list" when existing.
+  3. A checksum of the sizes and modification times of the dump files is
+     saved in the index. If the dump files are updated, the outdated index
+     is detected and rebuilt automatically, or the dump files are parsed
+     instead if the data directory is not writable.
+
+Examples:
+
+    taxonkit index --data-dir ~/.taxonkit
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		checkDataDirNotArchive(config.DataDir)
+
+		idx := buildIndex(config)
+		checkError(idx.write(indexFile(config)))
+
+		log.Infof("%d nodes, %d names, %d deleted nodes, and %d merged nodes are indexed: %s",
+			len(idx.Tree), len(idx.Names), len(idx.DelNodes), len(idx.Merged), indexFile(config))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(indexCmd)
+}
@@ -23,10 +23,12 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"math"
 	"regexp"
-	"strconv"
 	"strings"
 
+	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/bytesize"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -45,7 +47,47 @@ Attention:
   2. TaxIDs should have the same separator ("-s/--separator"),
      single charactor separator is prefered.
   3. Empty lines or lines without valid TaxIds in the field are omitted.
+     Lines with empty TaxId fields (or without the field) can be kept
+     with a blank LCA via -e/--keep-empty.
   4. If some TaxIds are not found in database, it returns 0.
+  5. Deleted or unfound TaxIds can be skipped with -D/--skip-deleted and
+     -U/--skip-unfound, and the numbers of skipped TaxIds are reported in the end.
+     If only one valid TaxId is left after skipping, it's outputted as the LCA,
+     or the LCA is left blank with --blank-single.
+  6. With -t/--threshold t < 1, it returns the lowest TaxId that is an ancestor
+     of (or equals to) at least ceil(t * n) of the n TaxIds (duplicates counted).
+     If multiple TaxIds at the same depth qualify (possible when t <= 0.5),
+     the one covering more TaxIds is chosen, and then the smaller TaxId.
+  7. Scientific names (-n/--show-name) and ranks (-r/--show-rank) of LCAs can be
+     appended as extra columns, which are left blank for unavailable LCAs.
+  8. With -N/--names, the items can be scientific names (case-insensitive),
+     which are converted to TaxIds before computing LCA. Items consisting of
+     only digits are still treated as TaxIds, so names and TaxIds can be mixed.
+     Names not found are handled as unfound TaxIds (-U/--skip-unfound).
+     It stops with an error for names matching multiple TaxIds, unless a
+     policy is given via --prefer (see "taxonkit name2taxid --help").
+  9. With --running, the running LCA of all valid TaxIds seen so far, including
+     the ones in the current line, is outputted for each line, which only moves
+     toward the root. It is reset at the beginning of each input file, and by
+     lines equal to the value of --reset-line (if given), which are not outputted.
+     The running LCA is not updated by lines with deleted or unfound TaxIds
+     that are not skipped, for which 0 is outputted as usual.
+     --blank-single is ignored, and -t/--threshold is not supported.
+ 10. With --max-rank, LCAs are never more specific than the given rank,
+     i.e., an LCA lower than the rank is replaced by its nearest ancestor at
+     or above the rank, according to the rank order (see "taxonkit filter
+     --help"), while an LCA at or above the rank is returned unchanged.
+     Ancestors with ranks without order (e.g., "no rank" and "clade") are
+     skipped. An LCA without a rank order is treated as lower than the rank
+     only if its nearest ranked ancestor is at or below the rank. If no
+     ancestors at or above the rank exist on the path, e.g., "--max-rank family"
+     for a lineage without ranks higher than genus, the root (1) is returned,
+     while an LCA without any ranked ancestors (e.g., "cellular organisms")
+     is returned unchanged.
+     It applies to -t/--threshold and --running too.
+ 11. With --nodes-only, delnodes.dmp and merged.dmp are not loaded for faster
+     loading, so merged TaxIds are not replaced by the new ones, and merged
+     or deleted TaxIds are treated as not found. Use it for clean inputs only.
   
 Examples:
 
@@ -55,6 +97,45 @@ Examples:
     $ time echo 239934  239935  349741 9606  | taxonkit lca
     239934 239935 349741 9606       131567
 
+    $ echo 239934  239935  349741 9606  | taxonkit lca -t 0.75
+    239934 239935 349741 9606       239934
+
+    # LCA is computed for each line, e.g., for pairs of TaxIds in the 2nd
+    # column of a tab-delimited file. The input order is kept.
+    $ cat pairs.tsv
+    read1   239934,9606
+    read2   239934,239935
+    $ taxonkit lca -i 2 -s , pairs.tsv
+    read1   239934,9606     131567
+    read2   239934,239935   239934
+
+    # e.g., outputs of read classification, with empty sets kept
+    $ echo -e "read1\t239934,239935\nread2\t\nread3" | taxonkit lca -i 2 -s , -e
+    read1   239934,239935   239934
+    read2
+    read3
+
+    $ taxonkit lca -i 2 -s , -n -r pairs.tsv
+    read1   239934,9606     131567  cellular organisms      no rank
+    read2   239934,239935   239934  Akkermansia muciniphila species
+
+    # scientific names as input, which can be mixed with TaxIds
+    $ echo "Homo sapiens,Pan troglodytes,9601" | taxonkit lca -s , -N -n
+    Homo sapiens,Pan troglodytes,9601       9604    Hominidae
+
+    # the running LCA, with a sentinel line to reset it
+    $ echo -e "9606\n9598\n9601\n//\n239934\n239935" \
+        | taxonkit lca --running --reset-line // -n
+    9606    9606    Homo sapiens
+    9598    207598  Homininae
+    9601    9604    Hominidae
+    239934  239934  Akkermansia muciniphila
+    239935  239934  Akkermansia muciniphila
+
+    # LCAs not more specific than family
+    $ echo 239934 239935 | taxonkit lca --max-rank family -n -r
+    239934 239935   1647988 Akkermansiaceae family
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -82,6 +163,32 @@ Examples:
 		skipDeleted := getFlagBool(cmd, "skip-deleted")
 		skipUnfound := getFlagBool(cmd, "skip-unfound")
 		keepInvalid := getFlagBool(cmd, "keep-invalid")
+		keepEmpty := getFlagBool(cmd, "keep-empty")
+		blankSingle := getFlagBool(cmd, "blank-single")
+		printName := getFlagBool(cmd, "show-name")
+		printRank := getFlagBool(cmd, "show-rank")
+		byName := getFlagBool(cmd, "names")
+		prefer := getFlagPreferPolicy(cmd, "prefer")
+		if prefer != "" && !byName {
+			checkError(fmt.Errorf("flag --prefer should be used along with -N/--names"))
+		}
+		threshold := getFlagFloat64(cmd, "threshold")
+		if threshold <= 0 || threshold > 1 {
+			checkError(fmt.Errorf("value of flag -t/--threshold should be in range of (0, 1]"))
+		}
+		running := getFlagBool(cmd, "running")
+		resetLine := getFlagString(cmd, "reset-line")
+		if running && threshold < 1 {
+			checkError(fmt.Errorf("flag -t/--threshold is not supported with --running"))
+		}
+		if resetLine != "" && !running {
+			checkError(fmt.Errorf("flag --reset-line should be used along with --running"))
+		}
+		maxRank := strings.ToLower(getFlagString(cmd, "max-rank"))
+		rankFile := getFlagString(cmd, "rank-file")
+		if rankFile != "" && maxRank == "" {
+			checkError(fmt.Errorf("flag --rank-file should be used along with --max-rank"))
+		}
 
 		bufferSizeS := getFlagString(cmd, "buffer-size")
 		if bufferSizeS == "" {
@@ -92,10 +199,46 @@ Examples:
 			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
 		}
 
-		taxondb := loadTaxonomy(&config, false)
+		taxondb := loadTaxonomy(&config, printRank || prefer == "lowest-rank" || maxRank != "", getFlagBool(cmd, "nodes-only"))
+		if printName {
+			err = withLocalDumpFile(config.NamesFile, taxondb.LoadNamesFromNCBI)
+			if err != nil {
+				checkError(fmt.Errorf("err on loading Taxonomy names: %s", err))
+			}
+			if config.Verbose {
+				log.Infof("%d names loaded", len(taxondb.Names))
+			}
+		}
 		nodes := taxondb.Nodes
 		merged := taxondb.MergeNodes
 		delnodes := taxondb.DelNodes
+		taxdb := &taxonomy.Taxonomy{Nodes: nodes, DelNodes: delnodes, Merged: merged}
+
+		var capper *lcaCapper
+		if maxRank != "" {
+			rankOrder, _, err := readRankOrder(config, rankFile)
+			checkError(err)
+			maxRankOrder, err := getRankOrder(taxondb.Ranks, rankOrder, maxRank)
+			checkError(err)
+			capper = &lcaCapper{
+				taxondb:   taxondb,
+				rankOrder: rankOrder,
+				maxOrder:  maxRankOrder,
+				cache:     make(map[uint32]uint32, 1024),
+			}
+		}
+
+		var name2taxids map[string][]uint32
+		var selector *taxidSelector
+		if byName {
+			name2taxids, _ = getTaxonName2Taxids(config.NamesFile, true, nil)
+			if config.Verbose {
+				log.Infof("%d scientific names loaded", len(name2taxids))
+			}
+			if prefer != "" {
+				selector = newTaxidSelector(config, prefer, nodes, taxondb.Rank)
+			}
+		}
 
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
@@ -103,6 +246,30 @@ Examples:
 
 		buf := make([]byte, bufferSize)
 
+		// extra columns of name and rank, or blank ones
+		var blankExtra string
+		if printName {
+			blankExtra += "\t"
+		}
+		if printRank {
+			blankExtra += "\t"
+		}
+		extra := func(lca uint32) string {
+			if lca == 0 {
+				return blankExtra
+			}
+			var s string
+			if printName {
+				s += "\t" + taxondb.Name(lca)
+			}
+			if printRank {
+				s += "\t" + taxondb.Rank(lca)
+			}
+			return s
+		}
+
+		var nSkippedDeleted, nSkippedUnfound int
+
 		taxids := make([]uint32, 0, 128)
 		for _, file := range files {
 			fh, err := xopen.Ropen(file)
@@ -111,25 +278,30 @@ Examples:
 			scanner := bufio.NewScanner(fh)
 			scanner.Buffer(buf, int(bufferSize))
 
-			var _taxid int
 			var line, item string
 			var items []string
 			var lca, taxid, taxid2 uint32
+			var runningLCA uint32 // 0 for no TaxIds seen
+			var matched []uint32
 			var ok, flag bool
+			var nSkipped int
 			for scanner.Scan() {
 				line = strings.Trim(scanner.Text(), "\r\n ")
 				if line == "" {
 					continue
 				}
+				if running && resetLine != "" && line == resetLine {
+					runningLCA = 0
+					continue
+				}
 
 				lca = 0
 
 				items = strings.Split(line, "\t")
-				if len(items) <= field {
-					field = len(items) - 1
-				}
-
-				if items[field] == "" {
+				if len(items) <= field || items[field] == "" { // empty set
+					if keepEmpty {
+						outfh.WriteString(line + "\t" + blankExtra + "\n")
+					}
 					continue
 				}
 
@@ -138,14 +310,46 @@ Examples:
 				taxids = taxids[:0]
 
 				flag = false
+				nSkipped = 0
 				for _, item = range items {
+					if byName && !reTaxid.MatchString(strings.TrimSpace(item)) {
+						item = strings.TrimSpace(item)
+						if item == "" {
+							continue
+						}
+						matched = name2taxids[strings.ToLower(item)]
+						switch {
+						case len(matched) == 0:
+							if !skipUnfound {
+								log.Warningf("name not found: %s", item)
+								flag = true
+							} else {
+								nSkippedUnfound++
+								nSkipped++
+							}
+						case len(matched) == 1:
+							taxids = append(taxids, matched[0])
+						case selector != nil:
+							taxids = append(taxids, matched[selector.best(matched, nil)])
+						default:
+							checkError(fmt.Errorf("name %q matches multiple TaxIds (%s), please choose one with --prefer", item, joinUint32s(matched, ",")))
+						}
+						if flag {
+							break
+						}
+						continue
+					}
+
 					item = reNonTaxid.ReplaceAllString(item, "")
 					if item == "" {
 						continue
 					}
 
-					_taxid, _ = strconv.Atoi(item)
-					taxid = uint32(_taxid)
+					taxid, err = taxonomy.ParseTaxId(item)
+					if err != nil {
+						checkTaxIdRange(err)
+						continue
+					}
 
 					_, ok = nodes[taxid]
 					if ok {
@@ -154,11 +358,13 @@ Examples:
 					}
 
 					if _, ok = delnodes[taxid]; ok {
-						log.Warningf("taxid %d was deleted", taxid)
 						if !skipDeleted {
+							log.Warningf("taxid %d was deleted", taxid)
 							flag = true
 							break
 						}
+						nSkippedDeleted++
+						nSkipped++
 						continue
 					}
 					if taxid2, ok = merged[taxid]; ok {
@@ -166,15 +372,36 @@ Examples:
 						taxid = taxid2
 						taxids = append(taxids, taxid)
 					} else {
-						log.Warningf("taxid %d not found", taxid)
 						if !skipUnfound {
+							log.Warningf("taxid %d not found", taxid)
 							flag = true
 							break
 						}
+						nSkippedUnfound++
+						nSkipped++
 					}
 				}
 				if flag {
-					outfh.WriteString(fmt.Sprintf("%s\t%d\n", line, 0))
+					outfh.WriteString(fmt.Sprintf("%s\t%d%s\n", line, 0, blankExtra))
+					continue
+				}
+
+				if running {
+					if len(taxids) == 0 && !keepInvalid {
+						continue
+					}
+					for _, taxid = range taxids {
+						if runningLCA == 0 {
+							runningLCA = taxid
+						} else if runningLCA != 1 && runningLCA != taxid { // no need to go up from the root
+							runningLCA = taxdb.LCA(runningLCA, taxid)
+						}
+					}
+					lca = runningLCA
+					if capper != nil {
+						lca = capper.cap(lca)
+					}
+					outfh.WriteString(fmt.Sprintf("%s\t%d%s\n", line, lca, extra(lca)))
 					continue
 				}
 
@@ -184,15 +411,23 @@ Examples:
 						continue
 					}
 				case 1:
+					if nSkipped > 0 && blankSingle {
+						outfh.WriteString(line + "\t" + blankExtra + "\n")
+						continue
+					}
 					lca = taxids[0]
 				default:
-					lca = taxids[0]
-					for _, taxid = range taxids {
-						lca = taxondb.LCA(lca, taxid)
+					if threshold < 1 {
+						lca = lcaWithThreshold(taxdb, taxids, threshold)
+						break
 					}
+					lca = taxdb.LCA(taxids...)
 				}
 
-				outfh.WriteString(fmt.Sprintf("%s\t%d\n", line, lca))
+				if capper != nil {
+					lca = capper.cap(lca)
+				}
+				outfh.WriteString(fmt.Sprintf("%s\t%d%s\n", line, lca, extra(lca)))
 			}
 			if err := scanner.Err(); err != nil {
 				checkError(err)
@@ -201,6 +436,12 @@ Examples:
 			checkError(fh.Close())
 		}
 
+		if nSkippedDeleted > 0 {
+			log.Warningf("%d deleted TaxIds were skipped", nSkippedDeleted)
+		}
+		if nSkippedUnfound > 0 {
+			log.Warningf("%d unfound TaxIds were skipped", nSkippedUnfound)
+		}
 	},
 }
 
@@ -214,9 +455,111 @@ func init() {
 	lcaCmd.Flags().BoolP("skip-deleted", "D", false, "skip deleted TaxIds and compute with left ones")
 	lcaCmd.Flags().BoolP("skip-unfound", "U", false, "skip unfound TaxIds and compute with left ones")
 	lcaCmd.Flags().BoolP("keep-invalid", "K", false, "print the query even if no single valid taxid left")
+	lcaCmd.Flags().BoolP("keep-empty", "e", false, "output lines with empty TaxId fields (or without the field), with a blank LCA")
+	lcaCmd.Flags().BoolP("blank-single", "", false, "leave the LCA blank if only one TaxId is left after skipping deleted or unfound TaxIds (-D/--skip-deleted and -U/--skip-unfound), instead of outputting the TaxId")
+	lcaCmd.Flags().BoolP("show-name", "n", false, `output scientific name of the LCA`)
+	lcaCmd.Flags().BoolP("show-rank", "r", false, `output rank of the LCA`)
+	lcaCmd.Flags().BoolP("names", "N", false, `input items are scientific names, items consisting of only digits are still treated as TaxIds`)
+	lcaCmd.Flags().StringP("prefer", "", "", `for -N/--names, choose one TaxId for names matching multiple TaxIds, with a policy: smallest-taxid, largest-subtree, or lowest-rank`)
+	lcaCmd.Flags().Float64P("threshold", "t", 1, "return the lowest TaxId shared by at least this proportion of TaxIds, range: (0, 1]")
+	lcaCmd.Flags().BoolP("running", "", false, `output the running LCA of TaxIds in all lines so far, instead of the LCA of each line`)
+	lcaCmd.Flags().StringP("reset-line", "", "", `for --running, a sentinel line (e.g., "//") to reset the running LCA, which is not outputted`)
+	lcaCmd.Flags().StringP("max-rank", "", "", `LCAs lower than this rank are replaced by their nearest ancestors at or above the rank, e.g., "family". type "taxonkit lca --help" for details`)
+	lcaCmd.Flags().StringP("rank-file", "", "", `user-defined ordinal taxonomic rank file for --max-rank, see "taxonkit filter --help"`)
+	lcaCmd.Flags().BoolP("nodes-only", "", false, `do not load delnodes.dmp and merged.dmp for faster loading, merged TaxIds are not replaced. type "taxonkit lca --help" for details`)
 	lcaCmd.Flags().StringP("buffer-size", "b", "1M", `size of line buffer, supported unit: K, M, G. You need to increase the value when "bufio.Scanner: token too long" error occured`)
 
 }
 
+// lcaCapper replaces LCAs lower than a rank with their ancestors, for --max-rank.
+type lcaCapper struct {
+	taxondb   *taxdump.Taxonomy
+	rankOrder map[string]int // higher ranks have bigger values
+	maxOrder  int
+
+	cache map[uint32]uint32
+}
+
+// cap returns the LCA if it's at or above the rank, or its nearest ancestor at
+// or above the rank, or the root if there's none. Ancestors with ranks without
+// order are skipped, and an LCA without a rank order is kept if its nearest
+// ranked ancestor is above the rank, or if it has no ranked ancestors at all.
+func (c *lcaCapper) cap(lca uint32) uint32 {
+	if lca == 0 {
+		return 0
+	}
+	if taxid, ok := c.cache[lca]; ok {
+		return taxid
+	}
+
+	result := uint32(1)
+	var order int
+	var ok bool
+	taxid := lca
+	first := true // the first node with a rank order
+	for {
+		if order, ok = c.rankOrder[strings.ToLower(c.taxondb.Rank(taxid))]; ok {
+			if order >= c.maxOrder {
+				if first && taxid != lca && order > c.maxOrder { // an unranked LCA above the rank
+					result = lca
+				} else {
+					result = taxid
+				}
+				break
+			}
+			first = false
+		}
+
+		parent := c.taxondb.Nodes[taxid]
+		if parent == 0 || parent == taxid {
+			if first { // no ranked nodes on the path
+				result = lca
+			}
+			break
+		}
+		taxid = parent
+	}
+
+	c.cache[lca] = result
+	return result
+}
+
+// lcaWithThreshold returns the lowest node which is an ancestor of (or equals to)
+// at least ceil(threshold * len(taxids)) taxids. Nodes at the same depth are
+// compared by the number of covered taxids and then the TaxId.
+// Duplicated taxids are counted repeatedly. The root is returned if no other nodes are shared by enough taxids.
+func lcaWithThreshold(taxdb *taxonomy.Taxonomy, taxids []uint32, threshold float64) uint32 {
+	minCount := int(math.Ceil(threshold*float64(len(taxids)) - 1e-9))
+	if minCount < 1 {
+		minCount = 1
+	}
+
+	counts := make(map[uint32]int, 64)
+	depths := make(map[uint32]int, 64)
+	var taxid uint32
+	var i, d int
+	for _, taxid = range taxids {
+		for i, taxid = range taxdb.Lineage(taxid) { // the root 1 is not included
+			counts[taxid]++
+			depths[taxid] = i + 1
+		}
+	}
+
+	lca := uint32(1) // the root, shared by all taxids
+	var lcaDepth, lcaCount int = 0, len(taxids)
+	var c int
+	for taxid, c = range counts {
+		if c < minCount || taxid == 1 {
+			continue
+		}
+		d = depths[taxid]
+		if d > lcaDepth ||
+			(d == lcaDepth && (c > lcaCount || (c == lcaCount && taxid < lca))) {
+			lca, lcaDepth, lcaCount = taxid, d, c
+		}
+	}
+	return lca
+}
+
 var reTaxid = regexp.MustCompile(`^\d+$`)
 var reNonTaxid = regexp.MustCompile(`\D+`)
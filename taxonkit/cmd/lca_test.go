@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+)
+
+func TestLcaWithThreshold(t *testing.T) {
+	// 1 root
+	// ├── 2
+	// │   └── 3
+	// │       ├── 4
+	// │       │   ├── 5
+	// │       │   └── 6
+	// │       └── 7
+	// │           ├── 8
+	// │           └── 11
+	// └── 10
+	taxdb := &taxonomy.Taxonomy{Nodes: map[uint32]uint32{
+		1: 1, 2: 1, 3: 2, 4: 3, 5: 4, 6: 4, 7: 3, 8: 7, 11: 7, 10: 1,
+	}}
+
+	repeat := func(taxid uint32, n int) []uint32 {
+		taxids := make([]uint32, n)
+		for i := range taxids {
+			taxids[i] = taxid
+		}
+		return taxids
+	}
+
+	tests := []struct {
+		name      string
+		taxids    []uint32
+		threshold float64
+		want      uint32
+	}{
+		{"majority", []uint32{5, 6, 8, 10}, 0.5, 4},
+		{"majority of a deeper node", []uint32{5, 6, 5, 10}, 0.5, 5},
+		{"all", []uint32{5, 6, 8, 10}, 1, 1},
+		{"not shared by enough taxids", []uint32{5, 10}, 0.6, 1},
+		{"at least one taxid", []uint32{5, 10}, 0.01, 5},
+		{"tie in depth and count", []uint32{5, 6, 8, 11}, 0.5, 4},
+		{"tie in depth", []uint32{5, 6, 8, 11, 7}, 0.4, 7},
+		{"duplicated taxids", []uint32{5, 5, 8}, 0.6, 5},
+		{"duplicated taxids only", []uint32{5, 5}, 0.5, 5},
+		{"the root as a query", []uint32{1, 5}, 0.5, 5},
+		// 0.28 * 25 = 7.000000000000001, 7 taxids are enough
+		{"floating-point error", append(repeat(5, 7), repeat(10, 18)...), 0.28, 5},
+	}
+	for _, test := range tests {
+		if got := lcaWithThreshold(taxdb, test.taxids, test.threshold); got != test.want {
+			t.Errorf("%s: lcaWithThreshold(%v, %v): got %d, want %d", test.name, test.taxids, test.threshold, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// lineageTableCmd represents the lineage-table command
+var lineageTableCmd = &cobra.Command{
+	Use:   "lineage-table",
+	Short: "Output lineages of all TaxIds as a flat table",
+	Long: `Output lineages of all TaxIds as a flat table
+
+It's a convenient way to dump the whole taxonomy data, e.g., for loading into
+a database, without feeding TaxIds to "taxonkit lineage | taxonkit reformat".
+
+Output (tab-delimited, with a header row):
+
+  1. TaxId
+  2. Scientific name
+  3. Rank
+  4. Names and TaxIds of ancestors (including the TaxId itself) at the ranks
+     of --ranks, two columns for each rank: "<rank>" and "<rank>_taxid".
+     They are empty if there's no ancestor at the rank.
+
+Attention:
+
+  1. All TaxIds in nodes.dmp are outputted in ascending order.
+  2. Any ranks in nodes.dmp can be given via --ranks, not limited to the
+     placeholders of "taxonkit reformat".
+  3. If multiple ancestors have the same rank, the lowest one is outputted.
+  4. Lineages are resolved by -j/--threads goroutines in chunks of --chunk-size
+     TaxIds, and outputted in order.
+
+Examples:
+
+    $ taxonkit lineage-table --ranks genus,species -j 4 \
+        | csvtk grep -t -f taxid -p 562 | csvtk pretty -t
+    taxid   name               rank      genus         genus_taxid   species            species_taxid
+    -----   ----------------   -------   -----------   -----------   ----------------   -------------
+    562     Escherichia coli   species   Escherichia   561           Escherichia coli   562
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		ranksList := getFlagStringSlice(cmd, "ranks")
+		if len(ranksList) == 0 {
+			checkError(fmt.Errorf("flag --ranks needed"))
+		}
+		rank2idx := make(map[string]int, len(ranksList))
+		for i, rank := range ranksList {
+			rank = strings.ToLower(strings.TrimSpace(rank))
+			if _, ok := rank2idx[rank]; ok {
+				checkError(fmt.Errorf("duplicated rank in --ranks: %s", rank))
+			}
+			ranksList[i] = rank
+			rank2idx[rank] = i
+		}
+		chunkSize := getFlagPositiveInt(cmd, "chunk-size")
+		noHeader := getFlagBool(cmd, "no-header")
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		// -------------------- load data ----------------------
+
+		tree, ranks, names, _, _ := loadData(config, true, true, false)
+
+		taxids := make([]uint32, 0, len(tree))
+		for taxid := range tree {
+			taxids = append(taxids, taxid)
+		}
+		sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+
+		if config.Verbose {
+			log.Infof("resolving lineages of %d TaxIds", len(taxids))
+		}
+
+		// -------------------- output ----------------------
+
+		if !noHeader {
+			outfh.WriteString("taxid\tname\trank")
+			for _, rank := range ranksList {
+				outfh.WriteString("\t" + rank + "\t" + rank + "_taxid")
+			}
+			outfh.WriteString("\n")
+		}
+
+		// chunks are resolved in parallel, and their results are sent to
+		// ch in the original order
+		ch := make(chan chan []byte, config.Threads)
+		go func() {
+			tokens := make(chan int, config.Threads)
+			var end int
+			for i := 0; i < len(taxids); i += chunkSize {
+				end = i + chunkSize
+				if end > len(taxids) {
+					end = len(taxids)
+				}
+
+				out := make(chan []byte, 1)
+				ch <- out
+				tokens <- 1
+				go func(taxids []uint32, out chan []byte) {
+					out <- lineageTableRows(taxids, tree, ranks, names, rank2idx)
+					<-tokens
+				}(taxids[i:end], out)
+			}
+			close(ch)
+		}()
+
+		for out := range ch {
+			outfh.Write(<-out)
+			if config.LineBuffered {
+				outfh.Flush()
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(lineageTableCmd)
+
+	lineageTableCmd.Flags().StringSliceP("ranks", "", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species"}, `ranks to output, from high to low. multiple values can be separated with comma (e.g., --ranks "genus,species") or give multiple times`)
+	lineageTableCmd.Flags().IntP("chunk-size", "", 1000, `number of TaxIds resolved by each thread at a time`)
+	lineageTableCmd.Flags().BoolP("no-header", "", false, `do not output the header row`)
+}
+
+// lineageTableRows returns the rows of lineage-table for the given TaxIds.
+// For each rank in rank2idx, the lowest ancestor at the rank is used.
+func lineageTableRows(
+	taxids []uint32,
+	tree map[uint32]uint32,
+	ranks map[uint32]string,
+	names map[uint32]string,
+	rank2idx map[string]int,
+) []byte {
+	var buf bytes.Buffer
+
+	n := len(rank2idx)
+	found := make([]uint32, n) // TaxIds of each rank, 0 for missing
+	var i int
+	var ok bool
+	var node, parent uint32
+	for _, taxid := range taxids {
+		for i = range found {
+			found[i] = 0
+		}
+
+		node = taxid
+		for {
+			if i, ok = rank2idx[ranks[node]]; ok && found[i] == 0 {
+				found[i] = node
+			}
+			parent, ok = tree[node]
+			if !ok || parent == node {
+				break
+			}
+			node = parent
+		}
+
+		buf.WriteString(strconv.Itoa(int(taxid)) + "\t" + names[taxid] + "\t" + ranks[taxid])
+		for _, node = range found {
+			if node == 0 {
+				buf.WriteString("\t\t")
+				continue
+			}
+			buf.WriteString("\t" + names[node] + "\t" + strconv.Itoa(int(node)))
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.Bytes()
+}
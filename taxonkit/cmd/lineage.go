@@ -28,6 +28,7 @@ import (
 	"sync"
 
 	"github.com/shenwei356/breader"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -54,10 +55,95 @@ Output:
      - "0" for deleted TaxIds, provided by "delnodes.dmp".
      - New TaxIds for merged TaxIds, provided by "merged.dmp".
      - Taxids for these found in "nodes.dmp".
-  3. Lineage, delimiter can be changed with flag -d/--delimiter.
+  3. Lineage, delimiter can be changed with flag -d/--delimiter,
+     which is also used in -t/--show-lineage-taxids and -R/--show-lineage-ranks.
+     A warning is given if some taxon names contain the delimiter.
   4. (Optional) TaxIds taxons in the lineage (-t/--show-lineage-taxids)
   5. (Optional) Name (-n/--show-name)
   6. (Optional) Rank (-r/--show-rank)
+  7. (Optional) Ranks of the lineage (-R/--show-lineage-ranks)
+  8. (Optional) TaxIds of ancestors at given ranks (--at-rank),
+     one column for each rank in the order given, followed by
+     a column of the name if -n/--show-name is given.
+     The TaxId itself is returned if its rank equals to the given one.
+
+  With --names-only, only the lineage (column 3) is outputted, one line for
+  each input line, without the input data. It's empty for invalid, deleted,
+  or not found TaxIds.
+
+    $ echo 9606 | taxonkit lineage --names-only -d " > "
+    cellular organisms > Eukaryota > Metazoa > ... > Homo > Homo sapiens
+
+Compressing unranked nodes (--compress-no-rank):
+
+  Each run of consecutive unranked nodes (rank "no rank" or "clade") in the
+  lineage is replaced by a single --no-rank-placeholder, or dropped if the
+  placeholder is empty. Ranked nodes and the order are kept, and the TaxId
+  itself is always kept. -t/--show-lineage-taxids and -R/--show-lineage-ranks
+  are compressed in the same way, so the fields are still aligned.
+  It does not affect JSON output.
+
+    $ echo 9606 | taxonkit lineage --compress-no-rank -R
+    9606    ...;Eukaryota;...;Metazoa;...;Chordata;...;Mammalia;...    ...;superkingdom;...
+
+Fixed number of lineage fields (--pad-to):
+
+  Lineages (and -t/--show-lineage-taxids, -R/--show-lineage-ranks) are padded
+  at the end with --pad-value to exactly N fields delimited by -d/--delimiter,
+  in the original order of the lineage, i.e., it's not aligned by ranks like
+  "taxonkit reformat" does. Longer lineages are truncated to the first N fields
+  with a warning. Empty lineages of invalid or not-found TaxIds are also padded.
+  The delimiter should not appear in taxon names or --pad-value, or the
+  number of fields would be wrong.
+  With --reverse-order, lineages are reversed before padding, i.e., from the
+  TaxId to the top, and fields of higher ranks are truncated.
+
+Reversed lineages (--reverse-order):
+
+  Lineages are outputted from the TaxId to the top, e.g., species to
+  superkingdom, and so are -t/--show-lineage-taxids and -R/--show-lineage-ranks,
+  which are still aligned with the lineage. JSON output is not affected.
+
+    $ echo 562 | taxonkit lineage --reverse-order -t
+    562     Escherichia coli;Escherichia;...;cellular organisms    562;561;...;131567
+
+Relative lineages (--relative-to):
+
+  Only the part of the lineage below the given ancestor is outputted,
+  e.g., "--relative-to 2" for lineages relative to Bacteria.
+  -t/--show-lineage-taxids, -R/--show-lineage-ranks, and JSON output are
+  truncated in the same way, and the lineage of the ancestor itself is empty.
+  For TaxIds not under the ancestor, the behavior is set by --relative-outside:
+    blank: output an empty lineage (default)
+    warn:  output an empty lineage with a warning
+    full:  output the complete lineage
+
+    $ echo 562 | taxonkit lineage --relative-to 1224
+    562     Gammaproteobacteria;Enterobacterales;Enterobacteriaceae;Escherichia;Escherichia coli
+
+JSON output (-J/--json):
+
+  One JSON object per line for each input line:
+    {"query":"9606","taxid":9606,"ranks":{"superkingdom":{"taxid":2759,"name":"Eukaryota"},...},
+     "clades":[{"taxid":131567,"name":"cellular organisms","rank":"no rank"},...]}
+  - "taxid" is 0 for invalid, deleted, or not found TaxIds,
+    and the new TaxId for merged ones.
+  - Nodes with no rank, or whose ranks already appear in the lineage,
+    are stored in "clades" in the order of the lineage.
+  - Use --json-array to output a single JSON array.
+
+Performance:
+
+  Paths from the root to resolved TaxIds are cached (--cache-size),
+  so TaxIds sharing ancestors, e.g., clustered inputs, are resolved faster.
+  Input lines are processed by -j/--threads goroutines in chunks of
+  --chunk-size lines, and outputted in the input order. The default chunk
+  size is about 20% faster than 10 lines for millions of TaxIds, and larger
+  ones barely help. With --line-buffered, lines are processed one by one,
+  so outputs of streaming input are not held until a chunk is full.
+  With --nodes-only, delnodes.dmp and merged.dmp are not loaded, so merged
+  TaxIds are not replaced by the new ones, and merged or deleted TaxIds are
+  treated as not found. Use it for clean inputs only.
 
 Filter out invalid and deleted taxids, and replace merged 
 taxids with new ones:
@@ -86,6 +172,25 @@ taxids with new ones:
 		field := getFlagPositiveInt(cmd, "taxid-field") - 1
 		showCode := getFlagBool(cmd, "show-status-code")
 		noLineage := getFlagBool(cmd, "no-lineage")
+		jsonFormat := getFlagBool(cmd, "json")
+		jsonArray := getFlagBool(cmd, "json-array")
+		if jsonArray {
+			jsonFormat = true
+		}
+		if jsonFormat && noLineage {
+			checkError(fmt.Errorf("flag -J/--json and -L/--no-lineage are exclusive"))
+		}
+		namesOnly := getFlagBool(cmd, "names-only")
+		if namesOnly && (jsonFormat || noLineage || showCode || printLineageInTaxid || printLineageInRank || printName || printRank || len(getFlagStringSlice(cmd, "at-rank")) > 0) {
+			checkError(fmt.Errorf("flag --names-only can not be used along with -J/--json, -L/--no-lineage, -c/--show-status-code, -t/--show-lineage-taxids, -R/--show-lineage-ranks, -n/--show-name, -r/--show-rank, or --at-rank"))
+		}
+		atRanks := make([]string, 0, 4)
+		for _, rank := range getFlagStringSlice(cmd, "at-rank") {
+			if rank == "" {
+				continue
+			}
+			atRanks = append(atRanks, strings.ToLower(rank))
+		}
 
 		files := getFileList(args)
 
@@ -93,8 +198,34 @@ taxids with new ones:
 			checkError(fmt.Errorf("stdin not detected"))
 		}
 
-		if noLineage && !printRank && !printName {
-			checkError(fmt.Errorf("when given -L/--no-lineage, -n/--show-name or/and -r/--show-rank needed"))
+		compressNoRank := getFlagBool(cmd, "compress-no-rank") && !jsonFormat
+		noRankPlaceholder := getFlagString(cmd, "no-rank-placeholder")
+		if compressNoRank && noLineage {
+			checkError(fmt.Errorf("flag --compress-no-rank and -L/--no-lineage are exclusive"))
+		}
+		padTo := getFlagNonNegativeInt(cmd, "pad-to")
+		padValue := getFlagString(cmd, "pad-value")
+		reverseOrder := getFlagBool(cmd, "reverse-order")
+		if padTo > 0 && noLineage {
+			checkError(fmt.Errorf("flag --pad-to and -L/--no-lineage are exclusive"))
+		}
+		if padTo > 0 && delimiter != "" && strings.Contains(padValue, delimiter) {
+			checkError(fmt.Errorf("value of --pad-value should not contain the delimiter: %s", delimiter))
+		}
+
+		relativeTo := getFlagUint32(cmd, "relative-to")
+		relativeOutside := getFlagString(cmd, "relative-outside")
+		switch relativeOutside {
+		case "blank", "warn", "full":
+		default:
+			checkError(fmt.Errorf("invalid value of flag --relative-outside: %s. available: blank, warn, full", relativeOutside))
+		}
+		if relativeTo > 0 && noLineage {
+			checkError(fmt.Errorf("flag --relative-to and -L/--no-lineage are exclusive"))
+		}
+
+		if noLineage && !printRank && !printName && len(atRanks) == 0 {
+			checkError(fmt.Errorf("when given -L/--no-lineage, -n/--show-name or/and -r/--show-rank or/and --at-rank needed"))
 		}
 
 		// -------------------- load data ----------------------
@@ -104,10 +235,25 @@ taxids with new ones:
 		var names map[uint32]string
 		var delnodes map[uint32]struct{}
 		var merged map[uint32]uint32
-		tree, ranks, names, delnodes, merged = loadData(config, true, printRank || printLineageInRank)
+		tree, ranks, names, delnodes, merged = loadData(config, true, printRank || printLineageInRank || jsonFormat || len(atRanks) > 0 || compressNoRank, getFlagBool(cmd, "nodes-only"))
+		taxdb := &taxonomy.Taxonomy{Nodes: tree, Ranks: ranks, Names: names, DelNodes: delnodes, Merged: merged}
 
 		// -------------------- load data ----------------------
 
+		if relativeTo > 0 {
+			if _, ok := tree[relativeTo]; !ok {
+				if newtaxid, ok := merged[relativeTo]; ok {
+					log.Warningf("taxid %d given by --relative-to was merged into %d", relativeTo, newtaxid)
+					relativeTo = newtaxid
+				} else {
+					checkError(fmt.Errorf("taxid %d given by --relative-to not found", relativeTo))
+				}
+			}
+			if relativeTo == 1 { // paths do not contain the root
+				relativeTo = 0
+			}
+		}
+
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
 		defer outfh.Close()
@@ -119,12 +265,49 @@ taxids with new ones:
 			lineageInTaxid string
 			lineageInRank  string
 			notFound       bool
+			json           string
+			atRankTaxids   []uint32
 		}
 
 		var poolStrings = &sync.Pool{New: func() interface{} {
 			return make([]string, 0, 16)
 		}}
 
+		// warn only once if some names contain the delimiter
+		var onceDelimiterInName sync.Once
+
+		// lineage of invalid TaxIds, padded to fixed number of fields
+		var blankLineage string
+		if padTo > 0 {
+			blankLineage = strings.Join(padFields(nil, padTo, padValue), delimiter)
+		}
+		// warn only once if some lineages are truncated by --pad-to
+		var onceTruncated sync.Once
+		// fields are collected from the TaxId to the top
+		order := func(fields []string) []string {
+			if reverseOrder {
+				return fields
+			}
+			return stringutil.ReverseStringSlice(fields)
+		}
+		pad := func(fields []string) []string {
+			if padTo > 0 && len(fields) > padTo {
+				n := len(fields)
+				onceTruncated.Do(func() {
+					log.Warningf("some lineages (e.g., %d fields) are longer than --pad-to (%d), and are truncated", n, padTo)
+				})
+			}
+			return padFields(fields, padTo, padValue)
+		}
+
+		cacheSize := getFlagNonNegativeInt(cmd, "cache-size")
+		cache := newLineageCache(taxdb, cacheSize)
+
+		chunkSize := getFlagPositiveInt(cmd, "chunk-size")
+		if config.LineBuffered {
+			chunkSize = 1
+		}
+
 		fn := func(line string) (interface{}, bool, error) {
 			line = strings.Trim(line, "\r\n ")
 			if line == "" {
@@ -136,17 +319,20 @@ taxids with new ones:
 				field = len(data) - 1
 			}
 
-			if data[field] == "" {
-				return taxid2lineage{line, 0, "", "", "", false}, true, nil
-			}
-			id, e := strconv.Atoi(data[field])
-			if e != nil {
-				return taxid2lineage{line, 0, "", "", "", false}, true, nil
+			_id, e := taxonomy.ParseTaxId(data[field])
+			id := int(_id)
+			if e != nil { // empty or invalid TaxIds
+				var lineageJSONS string
+				if jsonFormat {
+					lineageJSONS = lineageJSON(data[field], 0, nil, names, ranks)
+				}
+				return taxid2lineage{line, 0, blankLineage, blankLineage, blankLineage, false, lineageJSONS, nil}, true, nil
 			}
 
 			// lineage := make([]string, 0, 16)
 			lineage := poolStrings.Get().([]string)
 			var lineageInTaxid, lineageInRank []string
+			var lineageTaxids []uint32
 
 			if printLineageInTaxid {
 				lineageInTaxid = make([]string, 0, 16)
@@ -155,85 +341,136 @@ taxids with new ones:
 				lineageInRank = make([]string, 0, 16)
 			}
 
-			var child, parent, newtaxid uint32
-			var ok bool
+			var child uint32
 			child = uint32(id)
 			var notFound bool
-			for {
-				parent, ok = tree[child]
-				if !ok { // taxid not found
-					// check if it was deleted
-					if _, ok = delnodes[child]; ok {
-						// log
-						log.Warningf("taxid %d was deleted", child)
-						id = 0
-						break
-					}
-					// check if it was merged
-					if newtaxid, ok = merged[child]; ok {
-						// log
-						log.Warningf("taxid %d was merged into %d", child, newtaxid)
-						child = newtaxid
-						parent = tree[child]
-						id = int(child)
-					} else {
-						id = 0
-						log.Warningf("taxid %d not found", child)
-						notFound = true
-						break
-					}
-				}
+			switch newtaxid, status := taxdb.Resolve(child); status {
+			case taxonomy.Deleted:
+				log.Warningf("taxid %d was deleted", child)
+				id = 0
+			case taxonomy.Merged:
+				log.Warningf("taxid %d was merged into %d", child, newtaxid)
+				child = newtaxid
+				id = int(child)
+			case taxonomy.NotFound:
+				id = 0
+				log.Warningf("taxid %d not found", child)
+				notFound = true
+			}
 
-				lineage = append(lineage, names[child])
+			if id > 0 {
+				var path []uint32
 				if noLineage {
-					break
+					path = []uint32{child}
+				} else {
+					path = cache.path(child)
 				}
 
-				if printLineageInTaxid {
-					lineageInTaxid = append(lineageInTaxid, strconv.Itoa(int(child)))
-				}
-				if printLineageInRank {
-					lineageInRank = append(lineageInRank, ranks[child])
+				if relativeTo > 0 {
+					path = pathBelow(path, relativeTo, relativeOutside == "full")
+					if path == nil && relativeOutside == "warn" {
+						log.Warningf("taxid %d is not under the taxid %d given by --relative-to", child, relativeTo)
+					}
 				}
 
-				if parent == 1 {
-					break
+				// from the TaxId to the top
+				var inNoRankRun bool // for --compress-no-rank
+				for i := len(path) - 1; i >= 0; i-- {
+					child = path[i]
+
+					if compressNoRank && i < len(path)-1 {
+						if !isUnranked(ranks[child]) {
+							inNoRankRun = false
+						} else if inNoRankRun {
+							continue
+						} else {
+							inNoRankRun = true
+							if noRankPlaceholder != "" {
+								lineage = append(lineage, noRankPlaceholder)
+								if printLineageInTaxid {
+									lineageInTaxid = append(lineageInTaxid, noRankPlaceholder)
+								}
+								if printLineageInRank {
+									lineageInRank = append(lineageInRank, noRankPlaceholder)
+								}
+							}
+							continue
+						}
+					}
+
+					lineage = append(lineage, names[child])
+					if noLineage {
+						break
+					}
+
+					if delimiter != "" && strings.Contains(names[child], delimiter) {
+						name := names[child]
+						onceDelimiterInName.Do(func() {
+							log.Warningf(`some taxon names contain the delimiter "%s", e.g., "%s", please use another one via -d/--delimiter`, delimiter, name)
+						})
+					}
+
+					if printLineageInTaxid {
+						lineageInTaxid = append(lineageInTaxid, strconv.Itoa(int(child)))
+					}
+					if jsonFormat {
+						lineageTaxids = append(lineageTaxids, child)
+					}
+					if printLineageInRank {
+						lineageInRank = append(lineageInRank, ranks[child])
+					}
 				}
-				child = parent
 			}
 			child = uint32(id)
 
 			var lineageS, lineageInTaxidS, lineageInRankS string
-			lineageS = strings.Join(stringutil.ReverseStringSlice(lineage), delimiter)
+			lineageS = strings.Join(pad(order(lineage)), delimiter)
 
 			lineage = lineage[:0]
 			poolStrings.Put(lineage)
 
 			if printLineageInTaxid {
-				lineageInTaxidS = strings.Join(stringutil.ReverseStringSlice(lineageInTaxid), delimiter)
+				lineageInTaxidS = strings.Join(pad(order(lineageInTaxid)), delimiter)
 
 				lineageInTaxid = lineageInTaxid[:0]
 				poolStrings.Put(lineageInTaxid)
 			}
 
 			if printLineageInRank {
-				lineageInRankS = strings.Join(stringutil.ReverseStringSlice(lineageInRank), delimiter)
+				lineageInRankS = strings.Join(pad(order(lineageInRank)), delimiter)
 
 				lineageInRank = lineageInRank[:0]
 				poolStrings.Put(lineageInRank)
 			}
 
+			var lineageJSONS string
+			if jsonFormat {
+				reverseUint32s(lineageTaxids)
+				lineageJSONS = lineageJSON(data[field], child, lineageTaxids, names, ranks)
+			}
+
+			var atRankTaxids []uint32
+			if len(atRanks) > 0 && child > 0 {
+				atRankTaxids = ancestorsAtRanks(tree, ranks, child, atRanks)
+			}
+
 			return taxid2lineage{line, child,
 				lineageS,
 				lineageInTaxidS,
 				lineageInRankS,
 				notFound,
+				lineageJSONS,
+				atRankTaxids,
 			}, true, nil
 		}
 
 		var buf bytes.Buffer
+		var nRecords int
+		if jsonArray {
+			outfh.WriteString("[")
+		}
 		for _, file := range files {
-			reader, err := breader.NewBufferedReader(file, config.Threads, 10, fn)
+			reader, err := breader.NewBufferedReader(file, config.Threads, chunkSize, fn)
 			checkError(err)
 
 			var t2l taxid2lineage
@@ -243,6 +480,32 @@ taxids with new ones:
 				for _, data := range chunk.Data {
 					t2l = data.(taxid2lineage)
 
+					if jsonFormat {
+						if jsonArray && nRecords > 0 {
+							outfh.WriteString(",")
+						}
+						if jsonArray {
+							outfh.WriteString("\n")
+						}
+						outfh.WriteString(t2l.json)
+						if !jsonArray {
+							outfh.WriteString("\n")
+						}
+						nRecords++
+						if config.LineBuffered {
+							outfh.Flush()
+						}
+						continue
+					}
+
+					if namesOnly {
+						outfh.WriteString(t2l.lineage + "\n")
+						if config.LineBuffered {
+							outfh.Flush()
+						}
+						continue
+					}
+
 					buf.Reset()
 					buf.WriteString(t2l.line)
 
@@ -272,6 +535,20 @@ taxids with new ones:
 						buf.WriteString("\t" + t2l.lineageInRank)
 					}
 
+					for i := range atRanks {
+						if t2l.atRankTaxids == nil || t2l.atRankTaxids[i] == 0 {
+							buf.WriteString("\t")
+							if printName {
+								buf.WriteString("\t")
+							}
+							continue
+						}
+						buf.WriteString("\t" + strconv.Itoa(int(t2l.atRankTaxids[i])))
+						if printName {
+							buf.WriteString("\t" + names[t2l.atRankTaxids[i]])
+						}
+					}
+
 					buf.WriteString("\n")
 
 					outfh.WriteString(buf.String())
@@ -281,6 +558,9 @@ taxids with new ones:
 				}
 			}
 		}
+		if jsonArray {
+			outfh.WriteString("\n]\n")
+		}
 
 	},
 }
@@ -295,4 +575,177 @@ func init() {
 	lineageCmd.Flags().IntP("taxid-field", "i", 1, "field index of taxid. input data should be tab-separated")
 	lineageCmd.Flags().StringP("delimiter", "d", ";", "field delimiter in lineage")
 	lineageCmd.Flags().BoolP("no-lineage", "L", false, "do not show lineage, when user just want names or/and ranks")
+	lineageCmd.Flags().BoolP("names-only", "", false, `only output lineages of scientific names delimited by -d/--delimiter, without the input data`)
+	lineageCmd.Flags().BoolP("compress-no-rank", "", false, `replace each run of consecutive unranked nodes ("no rank" or "clade") in lineages with a single --no-rank-placeholder`)
+	lineageCmd.Flags().StringP("no-rank-placeholder", "", "...", `placeholder of compressed unranked nodes for --compress-no-rank, empty for dropping them`)
+	lineageCmd.Flags().Uint32P("relative-to", "", 0, `only output the part of lineages below this ancestor TaxId. type "taxonkit lineage --help" for details`)
+	lineageCmd.Flags().StringP("relative-outside", "", "blank", `how to output lineages of TaxIds not under the ancestor of --relative-to: "blank", "warn" (blank with a warning), or "full"`)
+	lineageCmd.Flags().BoolP("reverse-order", "", false, `output lineages from the TaxId to the top (e.g., species to superkingdom), also for -t/--show-lineage-taxids and -R/--show-lineage-ranks`)
+	lineageCmd.Flags().IntP("pad-to", "", 0, `pad lineages to exactly this number of fields with --pad-value, longer ones are truncated. 0 for no padding`)
+	lineageCmd.Flags().StringP("pad-value", "", "", `placeholder for padded fields of --pad-to`)
+	lineageCmd.Flags().StringSliceP("at-rank", "", []string{}, `appending TaxIds (and names if -n/--show-name given) of ancestors at these ranks, empty for none. multiple values can be separated with comma (e.g., --at-rank "genus,family") or give multiple times`)
+	lineageCmd.Flags().BoolP("json", "J", false, `output in JSON Lines format, i.e., one JSON object per line, other output flags are ignored`)
+	lineageCmd.Flags().BoolP("json-array", "", false, `output a JSON array of all records instead of JSON Lines, it switchs on -J/--json`)
+	lineageCmd.Flags().IntP("cache-size", "", 1<<18, `maximum number of TaxIds of which the lineages (paths to the root) are cached for reusing by descendants. 0 for no cache`)
+	lineageCmd.Flags().BoolP("nodes-only", "", false, `do not load delnodes.dmp and merged.dmp for faster loading, merged TaxIds are not replaced. type "taxonkit lineage --help" for details`)
+	lineageCmd.Flags().IntP("chunk-size", "", 64, `number of lines processed by each thread at a time, it's 1 with --line-buffered`)
+}
+
+// pathBelow returns the part of a path (from the top to a TaxId) below the
+// ancestor. If the ancestor is not in the path, nil is returned, or the
+// path itself if keep is true. The returned slice shares the path.
+func pathBelow(path []uint32, ancestor uint32, keep bool) []uint32 {
+	for i, taxid := range path {
+		if taxid == ancestor {
+			return path[i+1:]
+		}
+	}
+	if keep {
+		return path
+	}
+	return nil
+}
+
+// lineageCache caches paths from the top of the taxonomy tree (the root
+// excluded) to TaxIds. Once the path of a node is resolved, the paths of its
+// descendants are computed by appending to it instead of walking to the root.
+type lineageCache struct {
+	taxdb   *taxonomy.Taxonomy
+	maxSize int
+
+	mu    sync.RWMutex
+	paths map[uint32][]uint32
+}
+
+func newLineageCache(taxdb *taxonomy.Taxonomy, maxSize int) *lineageCache {
+	size := maxSize
+	if size > mapInitialSize {
+		size = mapInitialSize
+	}
+	return &lineageCache{taxdb: taxdb, maxSize: maxSize, paths: make(map[uint32][]uint32, size)}
+}
+
+// path returns the TaxIds from the top to the given TaxId (included), as
+// Taxonomy.Lineage. The returned slice is shared and should not be modified.
+func (c *lineageCache) path(taxid uint32) []uint32 {
+	if c.maxSize == 0 {
+		return c.taxdb.Lineage(taxid)
+	}
+
+	var base []uint32
+	var ok bool
+	nodes := make([]uint32, 0, 16) // from the TaxId to the first cached ancestor
+
+	var parent uint32
+	child := taxid
+	for {
+		c.mu.RLock()
+		base, ok = c.paths[child]
+		c.mu.RUnlock()
+		if ok {
+			break
+		}
+
+		nodes = append(nodes, child)
+		parent, ok = c.taxdb.Nodes[child]
+		if !ok || parent == 1 || parent == child {
+			break
+		}
+		child = parent
+	}
+
+	if len(nodes) == 0 {
+		return base
+	}
+
+	// cache the paths of all the walked nodes, which are prefixes of the path
+	// of the TaxId sharing the same array. Their capacities are limited so
+	// appending to them does not overwrite the others.
+	path := make([]uint32, len(base), len(base)+len(nodes))
+	copy(path, base)
+	var n int
+	c.mu.Lock()
+	for i := len(nodes) - 1; i >= 0; i-- {
+		path = append(path, nodes[i])
+		if len(c.paths) < c.maxSize {
+			n = len(path)
+			c.paths[nodes[i]] = path[:n:n]
+		}
+	}
+	c.mu.Unlock()
+
+	return path
+}
+
+// ancestorsAtRanks returns the TaxIds of the nearest ancestors (including the
+// TaxId itself) at the given ranks, 0 for ranks not found in the lineage.
+func ancestorsAtRanks(tree map[uint32]uint32, ranks map[uint32]string, taxid uint32, atRanks []string) []uint32 {
+	taxids := make([]uint32, len(atRanks))
+	var nFound int
+	var parent uint32
+	var ok bool
+	var rank string
+	for {
+		rank = ranks[taxid]
+		for i, r := range atRanks {
+			if taxids[i] == 0 && r == rank {
+				taxids[i] = taxid
+				nFound++
+			}
+		}
+		if nFound == len(atRanks) {
+			break
+		}
+
+		parent, ok = tree[taxid]
+		if !ok || parent == taxid {
+			break
+		}
+		taxid = parent
+	}
+	return taxids
+}
+
+// lineageJSON returns a JSON object of a lineage. Nodes with ranks are
+// stored in "ranks" as rank -> {taxid, name}, while nodes with no rank
+// or duplicated ranks are stored in "clades" in the order of the lineage.
+func lineageJSON(query string, taxid uint32, lineageTaxids []uint32,
+	names map[uint32]string, ranks map[uint32]string) string {
+	var buf bytes.Buffer
+	buf.WriteString(`{"query":` + jsonString(query))
+	buf.WriteString(`,"taxid":` + strconv.Itoa(int(taxid)))
+
+	clades := make([]uint32, 0, 8)
+	seen := make(map[string]interface{}, len(lineageTaxids))
+	var rank string
+	var ok bool
+	buf.WriteString(`,"ranks":{`)
+	first := true
+	for _, t := range lineageTaxids {
+		rank = ranks[t]
+		if rank == norank || rank == "clade" {
+			clades = append(clades, t)
+			continue
+		}
+		if _, ok = seen[rank]; ok {
+			clades = append(clades, t)
+			continue
+		}
+		seen[rank] = struct{}{}
+
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+		buf.WriteString(fmt.Sprintf(`%s:{"taxid":%d,"name":%s}`, jsonString(rank), t, jsonString(names[t])))
+	}
+	buf.WriteString(`},"clades":[`)
+	for i, t := range clades {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(fmt.Sprintf(`{"taxid":%d,"name":%s,"rank":%s}`, t, jsonString(names[t]), jsonString(ranks[t])))
+	}
+	buf.WriteString("]}")
+	return buf.String()
 }
@@ -0,0 +1,243 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/shenwei356/breader"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// lineage2taxidCmd represents the lineage2taxid command
+var lineage2taxidCmd = &cobra.Command{
+	Use:   "lineage2taxid",
+	Short: "Convert lineage strings back to TaxIds",
+	Long: `Convert lineage strings back to TaxIds
+
+It's the inverse operation of "taxonkit reformat", i.e., recovering the TaxId of
+the last taxon of a lineage string like "superkingdom;phylum;...;species".
+
+How it works:
+
+  1. Names in lineages are split by the delimiter (-d/--delimiter), and empty
+     names (e.g., missing ranks in the output of "reformat") are omitted.
+  2. TaxIds with the scientific name equal to the last name are searched
+     (case-insensitively), and the ones with ancestors matching all other
+     names in the same order are returned. Ranks not in the lineage string
+     are allowed to be skipped.
+  3. Prefixes like "d__", "k__", "p__" and "s__" can be removed before
+     matching with --strip-prefix.
+
+Attention:
+
+  1. If multiple TaxIds match a lineage, the input line is duplicated with
+     each TaxId, and a warning is reported.
+  2. If no TaxIds match, the TaxId is left blank. Names filled by
+     "reformat -F/--fill-miss-rank" are not recognized.
+
+Examples:
+
+    $ echo "Eukaryota;Chordata;Mammalia;Primates;Hominidae;Homo;Homo sapiens" \
+        | taxonkit lineage2taxid -r
+    Eukaryota;Chordata;Mammalia;Primates;Hominidae;Homo;Homo sapiens   9606    species
+
+    $ echo "d__Bacteria;p__Pseudomonadota;c__Gammaproteobacteria;o__;f__;g__Escherichia;s__Escherichia coli" \
+        | taxonkit lineage2taxid --strip-prefix
+    d__Bacteria;p__Pseudomonadota;c__Gammaproteobacteria;o__;f__;g__Escherichia;s__Escherichia coli 562
+
+    # the genus Bacillus in Bacteria
+    $ echo "Bacteria;Bacillus" | taxonkit lineage2taxid
+    Bacteria;Bacillus       1386
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		printRank := getFlagBool(cmd, "show-rank")
+		field := getFlagPositiveInt(cmd, "lineage-field") - 1
+		delimiter := getFlagString(cmd, "delimiter")
+		if delimiter == "" {
+			checkError(fmt.Errorf("value of flag -d/--delimiter should not be empty"))
+		}
+		stripPrefix := getFlagBool(cmd, "strip-prefix")
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		// -------------------- load data ----------------------
+
+		tree, ranks, names, _, _ := loadData(config, true, printRank, false)
+
+		name2taxids := make(map[string][]uint32, len(names))
+		var name string
+		for taxid, _name := range names {
+			name = strings.ToLower(_name)
+			name2taxids[name] = append(name2taxids[name], taxid)
+		}
+		for _, taxids := range name2taxids {
+			if len(taxids) > 1 {
+				sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+			}
+		}
+		if config.Verbose {
+			log.Infof("%d names indexed", len(name2taxids))
+		}
+
+		// -------------------- search ----------------------
+
+		type line2taxids struct {
+			line   string
+			taxids []uint32
+		}
+
+		fn := func(line string) (interface{}, bool, error) {
+			line = strings.Trim(line, "\r\n ")
+			if line == "" {
+				return nil, false, nil
+			}
+			data := strings.Split(line, "\t")
+			field := field // do not change the shared value, which affects following lines
+			if len(data) < field+1 {
+				field = len(data) - 1
+			}
+
+			lineage := make([]string, 0, 16)
+			for _, name := range strings.Split(data[field], delimiter) {
+				if stripPrefix {
+					name = reLineagePrefix.ReplaceAllString(strings.TrimSpace(name), "")
+				}
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "" {
+					continue
+				}
+				lineage = append(lineage, name)
+			}
+
+			return line2taxids{line, lineage2taxids(lineage, name2taxids, tree, names)}, true, nil
+		}
+
+		var taxid uint32
+		for _, file := range files {
+			reader, err := breader.NewBufferedReader(file, config.Threads, 10, fn)
+			checkError(err)
+
+			var l2t line2taxids
+			var data interface{}
+			for chunk := range reader.Ch {
+				checkError(chunk.Err)
+
+				for _, data = range chunk.Data {
+					l2t = data.(line2taxids)
+					if len(l2t.taxids) == 0 {
+						if printRank {
+							outfh.WriteString(l2t.line + "\t\t\n")
+						} else {
+							outfh.WriteString(l2t.line + "\t\n")
+						}
+						if config.LineBuffered {
+							outfh.Flush()
+						}
+						continue
+					}
+
+					if len(l2t.taxids) > 1 {
+						log.Warningf("multiple TaxIds found for '%s'", l2t.line)
+					}
+					for _, taxid = range l2t.taxids {
+						if printRank {
+							outfh.WriteString(fmt.Sprintf("%s\t%d\t%s\n", l2t.line, taxid, ranks[taxid]))
+						} else {
+							outfh.WriteString(fmt.Sprintf("%s\t%d\n", l2t.line, taxid))
+						}
+						if config.LineBuffered {
+							outfh.Flush()
+						}
+					}
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(lineage2taxidCmd)
+
+	lineage2taxidCmd.Flags().IntP("lineage-field", "i", 1, "field index of lineage. data should be tab-separated")
+	lineage2taxidCmd.Flags().StringP("delimiter", "d", ";", "field delimiter in lineage")
+	lineage2taxidCmd.Flags().BoolP("strip-prefix", "", false, `remove prefixes of names like "d__", "k__", and "s__" (regular expression: ^[a-zA-Z]__)`)
+	lineage2taxidCmd.Flags().BoolP("show-rank", "r", false, `show rank`)
+}
+
+var reLineagePrefix = regexp.MustCompile(`^[a-zA-Z]__`)
+
+// lineage2taxids returns TaxIds having the scientific name of the last name in
+// the lineage and ancestors matching other names in the same order.
+// Names should be in lower case.
+func lineage2taxids(lineage []string, name2taxids map[string][]uint32,
+	tree map[uint32]uint32, names map[uint32]string) []uint32 {
+	if len(lineage) == 0 {
+		return nil
+	}
+
+	candidates := name2taxids[lineage[len(lineage)-1]]
+	if len(lineage) == 1 {
+		return candidates
+	}
+
+	taxids := make([]uint32, 0, 1)
+	var j int
+	var taxid, parent uint32
+	var ok bool
+	for _, candidate := range candidates {
+		j = len(lineage) - 2
+		taxid = candidate
+		for {
+			parent, ok = tree[taxid]
+			if !ok || parent == taxid {
+				break
+			}
+			taxid = parent
+
+			if strings.ToLower(names[taxid]) == lineage[j] {
+				j--
+				if j < 0 {
+					break
+				}
+			}
+		}
+		if j < 0 {
+			taxids = append(taxids, candidate)
+		}
+	}
+	return taxids
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+)
+
+func TestLineageCache(t *testing.T) {
+	tree, _, _ := listTestTree()
+	parents := map[uint32]uint32{1: 1}
+	for parent, children := range tree {
+		for child := range children {
+			parents[child] = parent
+		}
+	}
+	taxdb := &taxonomy.Taxonomy{Nodes: parents}
+
+	for _, size := range []int{0, 1, 3, 1 << 10} {
+		cache := newLineageCache(taxdb, size)
+		for round := 0; round < 2; round++ { // the second round reads cached paths
+			for taxid := range parents {
+				path := cache.path(taxid)
+				if want := taxdb.Lineage(taxid); !reflect.DeepEqual(path, want) {
+					t.Errorf("cache size %d, path(%d): got %v, want %v", size, taxid, path, want)
+				}
+				_ = append(path, 0) // must not change cached paths
+			}
+		}
+	}
+
+	// concurrent queries, for the race detector
+	cache := newLineageCache(taxdb, 1<<10)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for taxid := range parents {
+				cache.path(taxid)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// lineageTestFlags are flags used in tests, which are reset before each run.
+var lineageTestFlags = []string{"data-dir", "out-file", "threads", "quiet", "line-buffered",
+	"show-rank", "chunk-size"}
+
+// runLineage runs "taxonkit lineage" with the arguments, and returns the output.
+func runLineage(t testing.TB, args ...string) string {
+	outFile := filepath.Join(t.TempDir(), "out.tsv")
+	args = append([]string{"--data-dir", filepath.Join("testdata", "name2taxid"), "-o", outFile, "--quiet"}, args...)
+	runCommand(t, lineageCmd, lineageTestFlags, args...)
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// BenchmarkLineageChunkSize compares chunk sizes, where small chunks cost more
+// in passing data between goroutines.
+func BenchmarkLineageChunkSize(b *testing.B) {
+	file := writeReformatTestTaxIds(b, 100000)
+
+	for _, threads := range []int{1, 4} {
+		for _, chunkSize := range []int{1, 10, 64, 256} {
+			b.Run("threads="+strconv.Itoa(threads)+"/chunk-size="+strconv.Itoa(chunkSize), func(b *testing.B) {
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0)) // changed by -j/--threads
+				for i := 0; i < b.N; i++ {
+					runLineage(b, "-r", "-j", strconv.Itoa(threads), "--chunk-size", strconv.Itoa(chunkSize), file)
+				}
+			})
+		}
+	}
+}
@@ -23,11 +23,16 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"os"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/util/bytesize"
+	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -41,6 +46,82 @@ var listCmd = &cobra.Command{
 Attention:
   1. When multiple taxids are given, the output may contain duplicated records
      if some taxids are descendants of others.
+  2. TaxIds from --ids, --ids-file, and files/stdin are merged, and duplicated
+     ones are removed, keeping the order of first appearance.
+  3. TaxIds matched by --name-glob are appended, and then TaxIds in subtrees of
+     other given TaxIds are removed to avoid overlapping subtrees.
+  4. Subtrees are listed in depth-first order by default. With "--order bfs",
+     nodes are listed level by level (breadth-first), i.e., all children are
+     listed before grandchildren. Nodes of each level are sorted by --sort-by,
+     and the indentation still reflects the depth. It only works for plain
+     text format.
+  5. With --path, each node is printed as a path of TaxIds (or names with
+     -n/--show-name) from the given TaxId, joined with "/", e.g., "2/1224/1236".
+     Names containing "/" are kept as they are.
+  6. With --out-pattern, the subtree of each TaxId is written to a separate
+     file (a complete JSON or DOT document for -J/--json or --dot), and paths
+     of created files are reported to stderr.
+  7. --exclude-ranks is the opposite of --rank: nodes of these ranks are
+     hidden but still traversed, and their children are placed under the
+     nearest shown ancestor, for all output formats. The given TaxIds are
+     always outputted.
+  8. With --ancestors, the ancestors of each given TaxId are outputted first,
+     from the root to the parent, with the indentation increased by one level
+     for each ancestor, and the subtree continues from the level below the
+     last ancestor. In JSON format, the subtree is nested in the objects of
+     ancestors. Ancestors are filtered by --rank and --exclude-ranks too.
+     As the chain is outputted for each TaxId, shared ancestors of multiple
+     TaxIds are duplicated.
+  9. With --json-objects, the output is a JSON array of node objects, with
+     fields "taxid", "name" (-n/--show-name), "rank" (-r/--show-rank),
+     "lineage" (--show-lineage), and "children", an array of child objects.
+ 10. With --only-leaves, only leaves (nodes without children in the taxonomy)
+     in the subtree of each TaxId are outputted, one per line, without
+     indentation. -J/--json outputs a flat array of nodes ("taxid name [rank]"),
+     and --json-objects outputs a flat array of node objects with empty
+     "children". Leaves are filtered by --rank, --exclude-ranks, --prune-to,
+     and -d/--max-depth too. A given TaxId without children is a leaf itself.
+ 11. With --progress, the number of processed TaxIds out of the total is shown
+     on stderr. It is disabled if stderr is not a terminal, or the output is
+     written to stdout which is a terminal, to avoid mixing with the output.
+ 12. With --prune, the traversal stops at these TaxIds, i.e., they are
+     outputted (as leaves, with empty objects in JSON format) but their
+     descendants are not. Use --prune-hidden to also hide the pruned TaxIds.
+     It applies to all output formats. For given TaxIds in --prune, only
+     themselves are outputted.
+ 13. With --sorted, all outputted nodes of all given TaxIds, including the
+     given TaxIds (unless --no-root), are outputted as a flat list without
+     indentation, sorted by --sort-by, in the end. Duplicated nodes in
+     overlapping subtrees are outputted once. It's useful for comparing
+     outputs of different versions of taxonomy data. Filters like --rank,
+     --exclude-ranks, --prune-to, --prune, and -d/--max-depth still apply.
+ 14. With --show-child-count, the number of direct children of each node in
+     the taxonomy data is appended to the node in parentheses, e.g.,
+     "1224 [phylum] Pseudomonadota (12)", which is not affected by filters like
+     --rank and -d/--max-depth. With --json-objects, it's outputted as the field
+     "child_count".
+ 15. With --edges, edges of subtrees are outputted as tab-delimited rows:
+     parent, (optional) parent_name, (optional) parent_rank, child,
+     (optional) child_name, (optional) child_rank, in depth-first order, and
+     --header adds a header row. Nodes hidden by --rank, --exclude-ranks, or
+     --prune-hidden are skipped, i.e., the children are linked to the nearest
+     outputted ancestors. Edges in overlapping subtrees are outputted once.
+     A given TaxId without children outputs no edges.
+ 16. With --show-level, the depth of each node relative to the given TaxId
+     (0 for the given TaxId, 1 for its children, ...) is outputted as a leading
+     column, which is useful for reconstructing the hierarchy without relying
+     on the indentation. Nodes hidden by filters like --rank still count, so the
+     depth may jump by more than one. With --json-objects, it's outputted as the
+     field "level".
+ 17. With --color, nodes are colorized by their ranks with ANSI escape codes
+     in plain text format (not for JSON, Newick, DOT, --path, and --edges),
+     only if the output is written to a terminal and the environment variable
+     NO_COLOR is not set. Available color schemes (--color-scheme):
+       default: species in green, genus in blue, family in bold blue, order
+                in cyan, class in yellow, phylum in magenta, and kingdom and
+                higher ranks in red
+       bright:  the same as default but in bright colors
+     Nodes of other ranks are not colorized.
 
 Examples:
 
@@ -54,6 +135,150 @@ Examples:
     63221
     741158
 
+    # only list species and subspecies
+    $ taxonkit list --ids 9604 -n -r --rank species,subspecies
+    9604 [family] Hominidae
+      9601 [species] Pongo abelii
+      ...
+      9606 [species] Homo sapiens
+        63221 [subspecies] Homo sapiens neanderthalensis
+        741158 [subspecies] Homo sapiens subsp. 'Denisova'
+      ...
+
+    # hide nodes of uninformative ranks
+    $ taxonkit list --ids 2 -n -r --exclude-ranks "no rank,clade"
+
+    # edges for graph databases
+    $ taxonkit list --ids 9606 -n --edges --header
+    parent  parent_name     child   child_name
+    9606    Homo sapiens    63221   Homo sapiens neanderthalensis
+    9606    Homo sapiens    741158  Homo sapiens subsp. 'Denisova'
+
+    # Newick format
+    $ taxonkit list --ids 9606 -n --newick
+    ('Homo sapiens neanderthalensis','Homo sapiens subsp. ''Denisova''')'Homo sapiens';
+
+    # only output descendants
+    $ taxonkit list --ids 9606 -n --no-root
+    63221 Homo sapiens neanderthalensis
+    741158 Homo sapiens subsp. 'Denisova'
+
+    $ taxonkit list --ids 9606 -n --no-root -J
+    {
+      "63221 Homo sapiens neanderthalensis": {
+      },
+      "741158 Homo sapiens subsp. 'Denisova'": {
+      }
+    }
+
+    # also output the ancestors
+    $ taxonkit list --ids 9606 -n -r --ancestors --rank species,genus,family,subspecies
+    9604 [family] Hominidae
+      9605 [genus] Homo
+        9606 [species] Homo sapiens
+          63221 [subspecies] Homo sapiens neanderthalensis
+          741158 [subspecies] Homo sapiens subsp. 'Denisova'
+
+    # JSON array of node objects
+    $ taxonkit list --ids 9606 -n -r --json-objects --max-depth 1
+    [
+      {
+        "taxid": 9606,
+        "name": "Homo sapiens",
+        "rank": "species",
+        "children": [
+          {
+            "taxid": 63221,
+            "name": "Homo sapiens neanderthalensis",
+            "rank": "subspecies",
+            "children": []
+          },
+          {
+            "taxid": 741158,
+            "name": "Homo sapiens subsp. 'Denisova'",
+            "rank": "subspecies",
+            "children": []
+          }
+        ]
+      }
+    ]
+
+    # only list leaves
+    $ taxonkit list --ids 9605 -n -r --only-leaves
+    63221 [subspecies] Homo sapiens neanderthalensis
+    741158 [subspecies] Homo sapiens subsp. 'Denisova'
+    1425170 [species] Homo heidelbergensis
+
+    # GraphViz DOT format
+    $ taxonkit list --ids 9606 -n -r --dot | dot -Tsvg > 9606.svg
+
+    # only count the descendants
+    $ taxonkit list --ids 9606 -n --count
+    9606    2       Homo sapiens
+
+    # numbers of descendants of each rank
+    $ taxonkit list --ids 9605 -n --stats
+    9605    species: 2, subspecies: 2       Homo
+
+    # only list the given TaxId and its direct children
+    $ taxonkit list --ids 9605 -n --max-depth 1
+    9605 Homo
+      9606 Homo sapiens
+      1425170 Homo heidelbergensis
+
+    # only list paths leading to some TaxIds
+    $ taxonkit list --ids 9604 -n --prune-to 9606,9601
+    9604 Hominidae
+      9600 Pongo
+        9601 Pongo abelii
+      207598 Homininae
+        9605 Homo
+          9606 Homo sapiens
+
+    # skip some subtrees
+    $ taxonkit list --ids 9605 -n --prune 9606
+    9605 Homo
+      9606 Homo sapiens
+      1425170 Homo heidelbergensis
+
+    $ taxonkit list --ids 9605 -n --prune 9606 --prune-hidden
+    9605 Homo
+      1425170 Homo heidelbergensis
+
+    # breadth-first order
+    $ taxonkit list --ids 9604 -n --prune-to 9606,9601 --order bfs
+    9604 Hominidae
+      9600 Pongo
+      207598 Homininae
+        9601 Pongo abelii
+        9605 Homo
+          9606 Homo sapiens
+
+    # paths from the given TaxId
+    $ taxonkit list --ids 9605 --path
+    9605
+    9605/9606
+    9605/9606/63221
+    9605/9606/741158
+    9605/1425170
+
+    $ taxonkit list --ids 9605 --path -n --rank species
+    Homo
+    Homo/Homo sapiens
+    Homo/Homo heidelbergensis
+
+    # subtrees of taxa with names matching a glob pattern
+    $ taxonkit list --name-glob "Homo sap*" -n
+    9606 Homo sapiens
+      63221 Homo sapiens neanderthalensis
+      741158 Homo sapiens subsp. 'Denisova'
+
+    # one file for each TaxId
+    $ taxonkit list --ids 9605,562 -n --out-pattern "{taxid}_{name}.txt"
+    [INFO] writing subtree of 9605 to 9605_Homo.txt
+    [INFO] writing subtree of 562 to 562_Escherichia_coli.txt
+    [INFO] 2 files created
+
     # from stdin
     echo 9606 | taxonkit list
 
@@ -65,28 +290,205 @@ Examples:
 		config := getConfigs(cmd)
 
 		ids := getFlagTaxonIDs(cmd, "ids")
+		idsFile := getFlagString(cmd, "ids-file")
+		if idsFile != "" {
+			ids = append(ids, readTaxonIDsFromFile(idsFile)...)
+		}
 		indent := getFlagString(cmd, "indent")
 		jsonFormat := getFlagBool(cmd, "json")
+		jsonObjects := getFlagBool(cmd, "json-objects")
+		if jsonObjects {
+			jsonFormat = true
+		}
+		newickFormat := getFlagBool(cmd, "newick")
+		if jsonFormat && newickFormat {
+			checkError(fmt.Errorf("flag -J/--json and --newick are exclusive"))
+		}
+		dotFormat := getFlagBool(cmd, "dot")
+		if dotFormat && (jsonFormat || newickFormat) {
+			checkError(fmt.Errorf("flag --dot can not be used along with -J/--json or --newick"))
+		}
+		dotRankdir := strings.ToUpper(getFlagString(cmd, "dot-rankdir"))
+		switch dotRankdir {
+		case "TB", "LR", "BT", "RL":
+		default:
+			checkError(fmt.Errorf("invalid value of flag --dot-rankdir: %s. available: TB, LR, BT, RL", dotRankdir))
+		}
+		countOnly := getFlagBool(cmd, "count")
+		countSelf := getFlagBool(cmd, "self")
+		if countOnly && (jsonFormat || newickFormat || dotFormat) {
+			checkError(fmt.Errorf("flag --count can not be used along with -J/--json, --newick, or --dot"))
+		}
+		statsMerge := getFlagBool(cmd, "stats-merge")
+		statsOnly := getFlagBool(cmd, "stats") || statsMerge
+		if statsOnly && (countOnly || jsonFormat || newickFormat || dotFormat) {
+			checkError(fmt.Errorf("flag --stats can not be used along with --count, -J/--json, --newick, or --dot"))
+		}
+		if countSelf && !countOnly && !statsOnly {
+			checkError(fmt.Errorf("flag --self should be used along with --count or --stats"))
+		}
+		noRoot := getFlagBool(cmd, "no-root")
+		if noRoot && (countOnly || statsOnly || newickFormat || dotFormat) {
+			checkError(fmt.Errorf("flag --no-root only works for plain text and JSON format"))
+		}
+		var bfsOrder bool
+		switch order := getFlagString(cmd, "order"); order {
+		case "dfs":
+		case "bfs":
+			bfsOrder = true
+		default:
+			checkError(fmt.Errorf("invalid value of flag --order: %s. available: dfs, bfs", order))
+		}
+		if bfsOrder && (jsonFormat || newickFormat || dotFormat) {
+			checkError(fmt.Errorf("flag --order bfs only works for plain text format, it can not be used along with -J/--json, --newick, or --dot"))
+		}
+		pathFormat := getFlagBool(cmd, "path")
+		if pathFormat && (jsonFormat || newickFormat || dotFormat) {
+			checkError(fmt.Errorf("flag --path can not be used along with -J/--json, --newick, or --dot"))
+		}
+		outPattern := getFlagString(cmd, "out-pattern")
+		if outPattern != "" {
+			if !strings.Contains(outPattern, "{taxid}") {
+				checkError(fmt.Errorf(`value of flag --out-pattern should contain "{taxid}": %s`, outPattern))
+			}
+			if noRoot || statsMerge {
+				checkError(fmt.Errorf("flag --out-pattern can not be used along with --no-root or --stats-merge"))
+			}
+		}
+		showAncestors := getFlagBool(cmd, "ancestors")
+		if showAncestors && (pathFormat || newickFormat || dotFormat || countOnly || statsOnly || noRoot) {
+			checkError(fmt.Errorf("flag --ancestors only works for plain text and JSON format, it can not be used along with --path, --newick, --dot, --count, --stats, or --no-root"))
+		}
+		onlyLeaves := getFlagBool(cmd, "only-leaves")
+		if onlyLeaves && (pathFormat || newickFormat || dotFormat || countOnly || statsOnly || noRoot || showAncestors || bfsOrder) {
+			checkError(fmt.Errorf("flag --only-leaves only works for plain text and JSON format, it can not be used along with --path, --newick, --dot, --count, --stats, --no-root, --ancestors, or --order bfs"))
+		}
+		sortedOutput := getFlagBool(cmd, "sorted")
+		if sortedOutput && (jsonFormat || pathFormat || newickFormat || dotFormat || countOnly || statsOnly || showAncestors || onlyLeaves || bfsOrder || outPattern != "") {
+			checkError(fmt.Errorf("flag --sorted only works for plain text format, it can not be used along with -J/--json, --path, --newick, --dot, --count, --stats, --ancestors, --only-leaves, --order bfs, or --out-pattern"))
+		}
+		edgesFormat := getFlagBool(cmd, "edges")
+		if edgesFormat && (jsonFormat || pathFormat || newickFormat || dotFormat || countOnly || statsOnly || noRoot || showAncestors || onlyLeaves || bfsOrder || sortedOutput) {
+			checkError(fmt.Errorf("flag --edges can not be used along with -J/--json, --path, --newick, --dot, --count, --stats, --no-root, --ancestors, --only-leaves, --order bfs, or --sorted"))
+		}
+		edgesHeader := getFlagBool(cmd, "header")
+		if edgesHeader && !edgesFormat {
+			checkError(fmt.Errorf("flag --header should be used along with --edges"))
+		}
+		showChildCount := getFlagBool(cmd, "show-child-count")
+		if showChildCount && (pathFormat || newickFormat || countOnly || statsOnly) {
+			checkError(fmt.Errorf("flag --show-child-count can not be used along with --path, --newick, --count, or --stats"))
+		}
+		showLevel := getFlagBool(cmd, "show-level")
+		if showLevel && ((jsonFormat && !jsonObjects) || pathFormat || newickFormat || dotFormat || edgesFormat || countOnly || statsOnly || showAncestors || onlyLeaves || sortedOutput) {
+			checkError(fmt.Errorf("flag --show-level can not be used along with -J/--json (use --json-objects instead), --path, --newick, --dot, --edges, --count, --stats, --ancestors, --only-leaves, or --sorted"))
+		}
+		showProgress := getFlagBool(cmd, "progress")
+		maxDepth := getFlagInt(cmd, "max-depth")
+		if maxDepth < -1 {
+			checkError(fmt.Errorf("value of flag -d/--max-depth should be >= -1"))
+		}
 
 		files := getFileList(args)
 		// if len(files) > 1 || (len(files) == 1 && files[0] == "stdin") {
 		// 	log.Warningf("no positional arguments needed")
 		// }
 
-		if len(ids) == 0 && len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
-			checkError(fmt.Errorf("the flag --ids is not given and stdin is not detected"))
+		reNameGlobs := make([]*regexp.Regexp, 0, 1)
+		for _, glob := range getFlagStringSlice(cmd, "name-glob") {
+			if glob == "" {
+				continue
+			}
+			re, err := globToRegexp(glob)
+			if err != nil {
+				checkError(fmt.Errorf("invalid value of flag --name-glob: %s", glob))
+			}
+			reNameGlobs = append(reNameGlobs, re)
+		}
+
+		if len(ids) == 0 && len(reNameGlobs) == 0 && len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("the flag --ids, --ids-file, or --name-glob is not given and stdin is not detected"))
 		}
 
 		_ids := getTaxonIDs(files)
 		ids = append(ids, _ids...)
+		ids = uniqueInts(ids)
+
+		bufferSizeS := getFlagString(cmd, "buffer-size")
+		_bufferSize, err := bytesize.ParseByteSize(bufferSizeS)
+		if err != nil || _bufferSize <= 0 {
+			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
+		}
+		bufferSize := int(_bufferSize)
+		openOutFile := func(file string) *xopen.Writer {
+			outfh, err := xopen.Wopen(file)
+			checkError(err)
+			setWriterBufferSize(outfh, bufferSize)
+			return outfh
+		}
 
-		outfh, err := xopen.Wopen(config.OutFile)
-		checkError(err)
-		defer outfh.Close()
+		// with --out-pattern, a file is opened for each TaxId in the main loop
+		var outfh *xopen.Writer
+		if outPattern == "" {
+			outfh = openOutFile(config.OutFile)
+			defer outfh.Close()
+		}
 
 		printName := getFlagBool(cmd, "show-name")
 		printRank := getFlagBool(cmd, "show-rank")
 
+		rankSet := make(map[string]interface{})
+		for _, rank := range getFlagStringSlice(cmd, "rank") {
+			if rank == "" {
+				continue
+			}
+			rankSet[strings.ToLower(rank)] = struct{}{}
+		}
+		excludeRankSet := make(map[string]interface{})
+		for _, rank := range getFlagStringSlice(cmd, "exclude-ranks") {
+			if rank == "" {
+				continue
+			}
+			excludeRankSet[strings.ToLower(rank)] = struct{}{}
+		}
+		var colors map[string]string // rank -> ANSI color code
+		colorScheme := strings.ToLower(getFlagString(cmd, "color-scheme"))
+		if _, ok := listColorSchemes[colorScheme]; !ok {
+			checkError(fmt.Errorf("invalid value of flag --color-scheme: %s, available: default, bright", colorScheme))
+		}
+		if getFlagBool(cmd, "color") && outPattern == "" && isStdin(config.OutFile) &&
+			isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" {
+			colors = listColorSchemes[colorScheme]
+		}
+		loadRank := printRank || len(rankSet) > 0 || len(excludeRankSet) > 0 || statsOnly || colors != nil
+		showLineage := getFlagBool(cmd, "show-lineage")
+		pruneTo := getFlagTaxonIDs(cmd, "prune-to")
+		var pruneIDs []uint32
+		for _, s := range getFlagStringSlice(cmd, "prune") {
+			if s == "" {
+				continue
+			}
+			id, err := taxonomy.ParseTaxId(s)
+			if err != nil || id < 1 {
+				checkError(fmt.Errorf("invalid TaxId given by --prune: %s", s))
+			}
+			pruneIDs = append(pruneIDs, id)
+		}
+		pruneHidden := getFlagBool(cmd, "prune-hidden")
+		if pruneHidden && len(pruneIDs) == 0 {
+			checkError(fmt.Errorf("flag --prune-hidden should be used along with --prune"))
+		}
+		loadParents := showLineage || len(pruneTo) > 0 || len(reNameGlobs) > 0 || showAncestors
+
+		var sortByName bool
+		switch sortBy := getFlagString(cmd, "sort-by"); sortBy {
+		case "taxid":
+		case "name":
+			sortByName = true
+		default:
+			checkError(fmt.Errorf("invalid value of flag --sort-by: %s. available: taxid, name", sortBy))
+		}
+
 		// -------------------- load data ----------------------
 
 		var names map[uint32]string
@@ -95,82 +497,271 @@ Examples:
 		// var tree map[uint32]map[uint32]bool // different from that in lineage.go
 		var tree map[uint32]map[uint32]interface{} // different from that in lineage.go
 		var ranks map[uint32]string
+		var parents map[uint32]uint32
 
 		var wg sync.WaitGroup
 
-		wg.Add(1)
-		go func() {
-			_, _, names, delnodes, merged = loadData(config, false, false)
-			wg.Done()
-		}()
+		if idx := loadIndex(config); idx != nil {
+			names, delnodes, merged = idx.Names, idx.delNodesMap(), idx.Merged
 
-		wg.Add(1)
-		go func() {
-			// tree = make(map[uint32]map[uint32]bool, mapInitialSize)
-			tree = make(map[uint32]map[uint32]interface{}, mapInitialSize)
-			ranks = make(map[uint32]string, mapInitialSize)
-
-			fh, err := xopen.Ropen(config.NodesFile)
-			checkError(err)
-
-			items := make([]string, 6)
-			scanner := bufio.NewScanner(fh)
-			var _child, _parent int
-			var child, parent uint32
-			var rank string
+			tree = make(map[uint32]map[uint32]interface{}, len(idx.Tree))
 			var ok bool
-			for scanner.Scan() {
-				stringSplitN(scanner.Text(), "\t", 6, &items)
-				if len(items) < 6 {
-					continue
-				}
-
-				_child, err = strconv.Atoi(items[0])
-				if err != nil {
-					continue
-				}
-
-				_parent, err = strconv.Atoi(items[2])
-				if err != nil {
-					continue
-				}
-				child, parent, rank = uint32(_child), uint32(_parent), items[4]
-
-				// ----------------------------------
-
+			for child, parent := range idx.Tree {
 				if child > 1 {
 					if _, ok = tree[parent]; !ok {
-						// tree[parent] = make(map[uint32]bool)
 						tree[parent] = make(map[uint32]interface{})
 					}
-					// tree[parent][child] = false
 					tree[parent][child] = struct{}{}
 				}
 
 				if _, ok = tree[child]; !ok {
-					// tree[child] = make(map[uint32]bool)
 					tree[child] = make(map[uint32]interface{})
 				}
-				if printRank {
-					ranks[child] = rank
-				}
 			}
-			if err := scanner.Err(); err != nil {
-				checkError(err)
+			if loadRank {
+				ranks = idx.Ranks
+			} else {
+				ranks = make(map[uint32]string)
+			}
+			if loadParents {
+				parents = idx.Tree
 			}
-			wg.Done()
-		}()
+		} else {
+			wg.Add(1)
+			go func() {
+				_, _, names, delnodes, merged = loadData(config, false, false, false)
+				wg.Done()
+			}()
+
+			wg.Add(1)
+			go func() {
+				// tree = make(map[uint32]map[uint32]bool, mapInitialSize)
+				tree = make(map[uint32]map[uint32]interface{}, mapInitialSize)
+				ranks = make(map[uint32]string, mapInitialSize)
+				if loadParents {
+					parents = make(map[uint32]uint32, mapInitialSize)
+				}
+
+				fh, err := taxonomy.Open(config.NodesFile)
+				checkError(err)
+
+				items := make([]string, 6)
+				scanner := bufio.NewScanner(fh)
+				var child, parent uint32
+				var rank string
+				var ok bool
+				for scanner.Scan() {
+					stringSplitN(scanner.Text(), "\t", 6, &items)
+					if len(items) < 6 {
+						continue
+					}
+
+					child, err = taxonomy.ParseTaxId(items[0])
+					if err != nil {
+						checkTaxIdRange(err)
+						continue
+					}
+
+					parent, err = taxonomy.ParseTaxId(items[2])
+					if err != nil {
+						checkTaxIdRange(err)
+						continue
+					}
+					rank = items[4]
+
+					// ----------------------------------
+
+					if child > 1 {
+						if _, ok = tree[parent]; !ok {
+							// tree[parent] = make(map[uint32]bool)
+							tree[parent] = make(map[uint32]interface{})
+						}
+						// tree[parent][child] = false
+						tree[parent][child] = struct{}{}
+					}
+
+					if _, ok = tree[child]; !ok {
+						// tree[child] = make(map[uint32]bool)
+						tree[child] = make(map[uint32]interface{})
+					}
+					if loadRank {
+						ranks[child] = rank
+					}
+					if loadParents {
+						parents[child] = parent
+					}
+				}
+				if err := scanner.Err(); err != nil {
+					checkError(err)
+				}
+				wg.Done()
+			}()
+		}
 
 		wg.Wait()
 
 		// -------------------- load data ----------------------
 
+		if len(reNameGlobs) > 0 {
+			matched := taxidsMatchingGlobs(names, reNameGlobs)
+			for _, taxid := range matched {
+				ids = append(ids, int(taxid))
+			}
+			ids = uniqueInts(ids)
+			ids = removeNestedTaxids(ids, parents)
+			if config.Verbose {
+				log.Infof("%d TaxIds matched by --name-glob, %d TaxIds to list after removing the ones in subtrees of others",
+					len(matched), len(ids))
+			}
+		}
+
+		var keep map[uint32]interface{}
+		if len(pruneTo) > 0 {
+			keep = make(map[uint32]interface{}, len(pruneTo)<<4)
+			var taxid, parent uint32
+			var ok bool
+			for _, id := range pruneTo {
+				taxid = uint32(id)
+				if _, ok = parents[taxid]; !ok {
+					if parent, ok = merged[taxid]; ok {
+						log.Warningf("taxid %d was merged into %d", taxid, parent)
+						taxid = parent
+					} else {
+						log.Warningf("taxid %d given by --prune-to not found", taxid)
+						continue
+					}
+				}
+				for {
+					if _, ok = keep[taxid]; ok {
+						break
+					}
+					keep[taxid] = struct{}{}
+					parent, ok = parents[taxid]
+					if !ok || parent == taxid {
+						break
+					}
+					taxid = parent
+				}
+			}
+		}
+
+		var prune map[uint32]interface{}
+		if len(pruneIDs) > 0 {
+			prune = make(map[uint32]interface{}, len(pruneIDs))
+			var taxid, newtaxid uint32
+			var ok bool
+			for _, taxid = range pruneIDs {
+				if _, ok = tree[taxid]; !ok {
+					if newtaxid, ok = merged[taxid]; ok {
+						log.Warningf("taxid %d was merged into %d", taxid, newtaxid)
+						taxid = newtaxid
+					} else {
+						log.Warningf("taxid %d given by --prune not found", taxid)
+						continue
+					}
+				}
+				prune[taxid] = struct{}{}
+			}
+		}
+
+		opt := &listOption{
+			indent:     indent,
+			names:      names,
+			printName:  printName,
+			ranks:      ranks,
+			printRank:  printRank,
+			jsonFormat: jsonFormat,
+			pathFormat: pathFormat,
+			maxDepth:   maxDepth,
+			rankSet:    rankSet,
+			config:     config,
+
+			excludeRankSet: excludeRankSet,
+
+			showLineage: showLineage,
+			parents:     parents,
+			sortByName:  sortByName,
+			keep:        keep,
+
+			prune:       prune,
+			pruneHidden: pruneHidden,
+
+			showChildCount: showChildCount,
+			tree:           tree,
+
+			showLevel: showLevel,
+			colors:    colors,
+		}
+
 		var level int
-		if jsonFormat {
-			outfh.WriteString("{\n")
+		var dotVisited map[uint32]interface{}
+		var nLeaves int // number of leaves written in the current document, for --only-leaves
+		// header and footer of a JSON or DOT document
+		writeHeader := func(outfh *xopen.Writer) {
+			nLeaves = 0
+			if jsonObjects || (jsonFormat && onlyLeaves) {
+				outfh.WriteString("[\n")
+			} else if jsonFormat {
+				outfh.WriteString("{\n")
+			}
+			if dotFormat {
+				outfh.WriteString("digraph taxonomy {\n")
+				outfh.WriteString(fmt.Sprintf("  rankdir=%s;\n", dotRankdir))
+				dotVisited = make(map[uint32]interface{}, 1024)
+			}
+			if edgesFormat {
+				if edgesHeader {
+					outfh.WriteString(opt.edgesHeader() + "\n")
+				}
+				dotVisited = make(map[uint32]interface{}, 1024)
+			}
+		}
+		writeFooter := func(outfh *xopen.Writer) {
+			if jsonFormat && onlyLeaves {
+				if nLeaves > 0 {
+					outfh.WriteString("\n")
+				}
+				outfh.WriteString("]\n")
+			} else if jsonObjects {
+				outfh.WriteString("]\n")
+			} else if jsonFormat {
+				outfh.WriteString("}\n")
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+			}
+			if dotFormat {
+				outfh.WriteString("}\n")
+			}
+		}
+		closeOutFile := func(outfh *xopen.Writer) {
+			writeFooter(outfh)
+			checkError(outfh.Close())
+		}
+
+		if outPattern == "" {
+			writeHeader(outfh)
 		}
+		var outFile string
+		outFiles := make(map[string]interface{}, 8) // created files
 		var newtaxid uint32
+		var noRootChildren []listNode // children of all TaxIds, for --no-root
+		var sortedTaxids []uint32     // all nodes to output, for --sorted
+		var rankCounts map[string]int // for --stats
+		var statsVisited map[uint32]interface{}
+		if statsMerge {
+			rankCounts = make(map[string]int, 64)
+			statsVisited = make(map[uint32]interface{}, 1024)
+		}
+		var bar *progressBar
+		if showProgress && isTerminal(os.Stderr) && !(outPattern == "" && isStdin(config.OutFile) && isTerminal(os.Stdout)) {
+			bar = newProgressBar(len(ids), "TaxIds")
+		}
 		for i, id := range ids {
+			if bar != nil {
+				bar.update(i)
+			}
+
 			if _, ok := tree[uint32(id)]; !ok {
 				// check if it was deleted
 				if _, ok = delnodes[uint32(id)]; ok {
@@ -187,55 +778,266 @@ Examples:
 				}
 			}
 
+			if outPattern != "" {
+				outFile = listOutFile(outPattern, uint32(id), names[uint32(id)])
+				if _, ok := outFiles[outFile]; ok {
+					log.Warningf("skip taxid %d as the file was already created: %s", id, outFile)
+					continue
+				}
+				outFiles[outFile] = struct{}{}
+
+				if outfh != nil {
+					closeOutFile(outfh)
+				}
+				outfh = openOutFile(outFile)
+				writeHeader(outfh)
+				if config.Verbose {
+					log.Infof("writing subtree of %d to %s", id, outFile)
+				}
+			}
+
+			if onlyLeaves {
+				var leaves []uint32
+				if len(tree[uint32(id)]) == 0 || opt.isPruned(uint32(id)) {
+					leaves = []uint32{uint32(id)}
+				} else {
+					leaves = opt.leaves(tree, uint32(id), 1, nil)
+				}
+				for _, taxid := range leaves {
+					if jsonFormat {
+						if nLeaves > 0 {
+							outfh.WriteString(",\n")
+						}
+						if jsonObjects {
+							writeJSONObject(tree, taxid, 1, outfh, 1, opt)
+						} else {
+							outfh.WriteString(indent + jsonString(opt.nodeLabel(taxid)))
+						}
+					} else {
+						opt.writeNode(outfh, taxid)
+						outfh.WriteString("\n")
+					}
+					nLeaves++
+				}
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+				continue
+			}
+
+			if countOnly {
+				n := countVisible(tree, uint32(id), 1, opt)
+				if countSelf {
+					n++
+				}
+				outfh.WriteString(fmt.Sprintf("%d\t%d", id, n))
+				if printName {
+					outfh.WriteString("\t" + names[uint32(id)])
+				}
+				if printRank {
+					outfh.WriteString("\t" + ranks[uint32(id)])
+				}
+				outfh.WriteString("\n")
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+				continue
+			}
+
+			if statsOnly {
+				if !statsMerge {
+					rankCounts = make(map[string]int, 64)
+				}
+				if countSelf {
+					countRank(uint32(id), opt, rankCounts, statsVisited)
+				}
+				countRanks(tree, uint32(id), 1, opt, rankCounts, statsVisited)
+				if statsMerge {
+					continue
+				}
+
+				outfh.WriteString(fmt.Sprintf("%d\t%s", id, formatRankCounts(rankCounts)))
+				if printName {
+					outfh.WriteString("\t" + names[uint32(id)])
+				}
+				if printRank {
+					outfh.WriteString("\t" + ranks[uint32(id)])
+				}
+				outfh.WriteString("\n")
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+				continue
+			}
+
+			if dotFormat {
+				writeDot(tree, uint32(id), outfh, opt, dotVisited)
+				continue
+			}
+
+			if edgesFormat {
+				writeEdges(tree, uint32(id), outfh, opt, dotVisited)
+				continue
+			}
+
+			if newickFormat {
+				writeNewick(tree, uint32(id), outfh, 1, opt)
+				outfh.WriteString(";\n")
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+				continue
+			}
+
+			if sortedOutput {
+				if !noRoot {
+					sortedTaxids = append(sortedTaxids, uint32(id))
+				}
+				sortedTaxids = opt.descendants(tree, uint32(id), 1, sortedTaxids)
+				continue
+			}
+
+			if noRoot {
+				noRootChildren = visibleChildren(tree, uint32(id), 1, opt, noRootChildren)
+				continue
+			}
+
 			level = 0
 			if jsonFormat {
 				level = 1
 			}
 
+			if jsonObjects {
+				var ancestors []uint32
+				if showAncestors {
+					ancestors = opt.visibleAncestors(uint32(id))
+					for _, taxid := range ancestors {
+						opt.openJSONObject(outfh, taxid, 0, level)
+						level += 2
+					}
+				}
+
+				writeJSONObject(tree, uint32(id), 1, outfh, level, opt)
+
+				for range ancestors {
+					level -= 2
+					outfh.WriteString("\n")
+					opt.closeJSONObject(outfh, level)
+				}
+				if outPattern == "" && i < len(ids)-1 {
+					outfh.WriteString(",")
+				}
+				outfh.WriteString("\n")
+				if config.LineBuffered {
+					outfh.Flush()
+				}
+				continue
+			}
+
+			var ancestors []uint32
+			if showAncestors {
+				ancestors = opt.visibleAncestors(uint32(id))
+				for _, taxid := range ancestors {
+					opt.writeAncestor(outfh, taxid, level)
+					level++
+				}
+			}
+
+			opt.writeLevel(outfh, 0)
 			outfh.WriteString(strings.Repeat(indent, level))
 
 			if jsonFormat {
 				outfh.WriteString(`"`)
 			}
-			outfh.WriteString(fmt.Sprintf("%d", id))
-
-			if printRank {
-				outfh.WriteString(fmt.Sprintf(" [%s]", ranks[uint32(id)]))
-			}
-			if printName {
-				outfh.WriteString(fmt.Sprintf(" %s", names[uint32(id)]))
+			var path string
+			if pathFormat {
+				path = opt.writePath(outfh, "", uint32(id))
+			} else {
+				opt.writeNode(outfh, uint32(id))
 			}
 
-			level = 0
 			if jsonFormat {
 				outfh.WriteString(`": {`)
-				level = 1
 			}
 			outfh.WriteString("\n")
 			if config.LineBuffered {
 				outfh.Flush()
 			}
 
-			traverseTree(tree, uint32(id), outfh, indent, level+1, names,
-				printName, ranks, printRank, jsonFormat, config)
+			if bfsOrder {
+				traverseTreeBFS(tree, visibleChildren(tree, uint32(id), 1, opt, nil), outfh, level+1, path, opt)
+			} else {
+				traverseTree(tree, uint32(id), outfh, level+1, 1, path, opt)
+			}
 
 			if jsonFormat {
 				outfh.WriteString(fmt.Sprintf("%s}", strings.Repeat(indent, level)))
+				for range ancestors {
+					level--
+					outfh.WriteString(fmt.Sprintf("\n%s}", strings.Repeat(indent, level)))
+				}
 			}
-			if jsonFormat && i < len(ids)-1 {
+			if jsonFormat && outPattern == "" && i < len(ids)-1 {
 				outfh.WriteString(",")
 			}
-			outfh.WriteString("\n")
+			if !pathFormat { // paths need no blank lines to separate subtrees
+				outfh.WriteString("\n")
+			}
 			if config.LineBuffered {
 				outfh.Flush()
 			}
 		}
 
-		if jsonFormat {
-			outfh.WriteString("}\n")
-			if config.LineBuffered {
-				outfh.Flush()
+		if bar != nil {
+			bar.finish()
+		}
+
+		if statsMerge {
+			outfh.WriteString("total\t" + formatRankCounts(rankCounts) + "\n")
+		}
+
+		if sortedOutput {
+			sort.Slice(sortedTaxids, func(i, j int) bool { return opt.less(sortedTaxids[i], sortedTaxids[j]) })
+			for i, taxid := range sortedTaxids {
+				if i > 0 && taxid == sortedTaxids[i-1] { // duplicated nodes are adjacent after sorting
+					continue
+				}
+				opt.writeNode(outfh, taxid)
+				outfh.WriteString("\n")
+			}
+		} else if noRoot {
+			level = 0
+			if jsonFormat {
+				level = 1
+			}
+			if jsonObjects {
+				for i, node := range noRootChildren {
+					writeJSONObject(tree, node.taxid, node.depth+1, outfh, level, opt)
+					if i < len(noRootChildren)-1 {
+						outfh.WriteString(",")
+					}
+					outfh.WriteString("\n")
+				}
+			} else if bfsOrder {
+				traverseTreeBFS(tree, noRootChildren, outfh, level, "", opt)
+			} else {
+				traverseFrame(tree, &listFrame{children: noRootChildren, level: level}, outfh, opt)
+			}
+		}
+
+		if outPattern == "" {
+			writeFooter(outfh)
+		} else {
+			if outfh != nil {
+				closeOutFile(outfh)
 			}
+			if config.Verbose {
+				log.Infof("%d files created", len(outFiles))
+			}
+		}
+
+		if opt.pruned > 0 {
+			log.Infof("%d nodes deeper than %d levels were not printed (-d/--max-depth)", opt.pruned, maxDepth)
 		}
 	},
 }
@@ -244,90 +1046,1049 @@ func init() {
 	RootCmd.AddCommand(listCmd)
 
 	listCmd.Flags().StringP("ids", "i", "", "TaxId(s), multiple values should be separated by comma")
+	listCmd.Flags().StringSliceP("name-glob", "", []string{}, `list subtrees of TaxIds whose scientific names match these glob patterns (case-insensitive), supporting "*", "?", and "[...]", e.g., "Escherichia*". TaxIds in subtrees of other given TaxIds are removed. give multiple times for multiple patterns`)
+	listCmd.Flags().StringP("ids-file", "", "", `file containing TaxIds, one TaxId per line. blank lines and lines starting with "#" are ignored`)
 	listCmd.Flags().StringP("indent", "I", "  ", "indent")
 	listCmd.Flags().BoolP("show-rank", "r", false, `output rank`)
 	listCmd.Flags().BoolP("show-name", "n", false, `output scientific name`)
+	listCmd.Flags().BoolP("show-lineage", "", false, `output complete lineage delimited by semicolons, appended to each line after a tab, or as the field "lineage" in JSON format`)
 	listCmd.Flags().BoolP("json", "J", false, `output in JSON format. you can save the result in file with suffix ".json" and open with modern text editor`)
-}
+	listCmd.Flags().BoolP("json-objects", "", false, `output a JSON array of node objects with fields "taxid", "name", "rank", "lineage", and "children", instead of keys of TaxIds. it switches on -J/--json`)
+	listCmd.Flags().BoolP("newick", "", false, `output in Newick format, one tree per line. scientific names (-n/--show-name) or TaxIds are used as labels`)
+	listCmd.Flags().BoolP("dot", "", false, `output in GraphViz DOT format, all subtrees are in one graph. node labels contain TaxIds and optional ranks (-r/--show-rank) and names (-n/--show-name)`)
+	listCmd.Flags().StringP("dot-rankdir", "", "TB", `direction of graph layout for --dot, available: TB, LR, BT, RL`)
+	listCmd.Flags().BoolP("no-root", "", false, `do not output the given TaxIds but only their descendants, with indentation shifted by one level. in JSON format, the children of all given TaxIds are the keys of the top-level object`)
+	listCmd.Flags().BoolP("count", "", false, `only output the number of descendants of each TaxId, in tab-delimited format: taxid, count, (optional) name, (optional) rank`)
+	listCmd.Flags().BoolP("self", "", false, `count the TaxId itself too, used along with --count or --stats`)
+	listCmd.Flags().BoolP("stats", "", false, `only output the numbers of descendants of each rank for each TaxId, in tab-delimited format: taxid, counts (e.g., "species: 1203, genus: 45"), (optional) name, (optional) rank`)
+	listCmd.Flags().BoolP("stats-merge", "", false, `only output the numbers of descendants of each rank for all TaxIds in one line, with "total" in the first column. nodes in overlapping subtrees are counted once. it switches on --stats`)
+	listCmd.Flags().StringP("sort-by", "", "taxid", `sort children by "taxid" or "name" (scientific name, with ties sorted by TaxId)`)
+	listCmd.Flags().BoolP("path", "", false, `output each node as a path of TaxIds (or scientific names with -n/--show-name) from the given TaxId, joined with "/"`)
+	listCmd.Flags().StringP("out-pattern", "", "", `write the subtree of each TaxId to a separate file, instead of -o/--out-file. the pattern should contain "{taxid}", and "{name}" is also supported for scientific names, e.g., "{taxid}.txt" or "{taxid}_{name}.json.gz"`)
+	listCmd.Flags().StringP("order", "", "dfs", `order of listing nodes in plain text format, "dfs" (depth-first) or "bfs" (breadth-first, i.e., level by level)`)
+	listCmd.Flags().StringSliceP("rank", "", []string{}, `only output TaxIds of these ranks, while their ancestors of other ranks are still traversed. the given TaxIds are always outputted. multiple values can be separated with comma "," (e.g., --rank "species,subspecies"), or give multiple times`)
+	listCmd.Flags().StringSliceP("exclude-ranks", "", []string{}, `do not output TaxIds of these ranks, while they are still traversed and their descendants are outputted. the given TaxIds are always outputted. multiple values can be separated with comma "," (e.g., --exclude-ranks "no rank,clade"), or give multiple times`)
+	listCmd.Flags().BoolP("ancestors", "", false, `also output the ancestors of each given TaxId, from the root to the parent, before the subtree. only for plain text and JSON format`)
+	listCmd.Flags().BoolP("only-leaves", "", false, `only output leaves (nodes without children) in the subtree of each TaxId, one per line. -J/--json outputs a flat array`)
+	listCmd.Flags().StringP("prune-to", "", "", `only output paths leading to these TaxIds (an induced subtree), multiple values should be separated by comma`)
+	listCmd.Flags().StringSliceP("prune", "", []string{}, `do not output descendants of these TaxIds, i.e., stop traversing at them. multiple values can be separated with comma or give multiple times`)
+	listCmd.Flags().BoolP("prune-hidden", "", false, `also do not output the TaxIds given by --prune`)
+	listCmd.Flags().BoolP("sorted", "", false, `output all nodes of all given TaxIds as a flat list sorted by --sort-by, without indentation and duplicates, for reproducible and diffable outputs`)
+	listCmd.Flags().BoolP("edges", "", false, `output edges of subtrees as tab-delimited rows of parent and child TaxIds, with optional names (-n/--show-name) and ranks (-r/--show-rank) of both`)
+	listCmd.Flags().BoolP("header", "", false, `output a header row for --edges`)
+	listCmd.Flags().BoolP("show-level", "", false, `output the depth of each node relative to the given TaxId as a leading column, or as the field "level" with --json-objects`)
+	listCmd.Flags().BoolP("show-child-count", "", false, `output the number of direct children of each node in the taxonomy, e.g., "1224 [phylum] (12)", or as the field "child_count" with --json-objects`)
+	listCmd.Flags().StringP("buffer-size", "", "64K", `size of output buffer, supported unit: K, M, G`)
+	listCmd.Flags().BoolP("color", "", false, `colorize nodes by their ranks in plain text format, only if the output is a terminal and NO_COLOR is not set`)
+	listCmd.Flags().StringP("color-scheme", "", "default", `color scheme for --color, available: default, bright`)
+	listCmd.Flags().BoolP("progress", "", false, `show the number of processed TaxIds on stderr, only if stderr is a terminal and the output is not written to a terminal`)
+	listCmd.Flags().IntP("max-depth", "d", -1, `maximum depth of subtrees to list, relative to the given TaxIds. 0 for only the given TaxIds, -1 for no limit. the number of nodes not printed is reported with --verbose`)
 
-func traverseTree(
-	// tree map[uint32]map[uint32]bool,
-	tree map[uint32]map[uint32]interface{},
-	parent uint32,
-	outfh *xopen.Writer,
-	indent string,
-	level int,
-	names map[uint32]string,
-	printName bool,
-	ranks map[uint32]string,
-	printRank bool,
-	jsonFormat bool,
-	config Config,
-) {
-	if _, ok := tree[parent]; !ok {
-		return
+	for _, flag := range []string{"ids", "prune-to", "prune"} {
+		checkError(listCmd.RegisterFlagCompletionFunc(flag, completeTaxIds))
 	}
+}
 
-	// sort children by taxid
-	children := make([]int, len(tree[parent]))
-	i := 0
-	for child := range tree[parent] {
-		children[i] = int(child)
-		i++
-	}
-	sort.Ints(children)
+// listOption contains the options for traversing and printing subtrees.
+type listOption struct {
+	indent     string
+	names      map[uint32]string
+	printName  bool
+	ranks      map[uint32]string
+	printRank  bool
+	jsonFormat bool
+	pathFormat bool // print paths from the given TaxIds instead of indented nodes
+	maxDepth   int  // -1 for no limit
+	config     Config
 
-	var child uint32
-	for i, c := range children {
-		child = uint32(c)
-		// if tree[parent][child] {
-		// 	continue
-		// }
+	rankSet        map[string]interface{} // only print nodes of these ranks
+	excludeRankSet map[string]interface{} // do not print nodes of these ranks
 
-		outfh.WriteString(strings.Repeat(indent, level))
+	sortByName bool // sort children by name instead of TaxId
 
-		if jsonFormat {
-			outfh.WriteString(`"`)
-		}
-		outfh.WriteString(fmt.Sprintf("%d", child))
-		if printRank {
-			outfh.WriteString(fmt.Sprintf(" [%s]", ranks[child]))
+	showLineage bool
+	parents     map[uint32]uint32 // child -> parent, for lineage
+
+	keep map[uint32]interface{} // only print these nodes, i.e., paths to targets of --prune-to
+
+	prune       map[uint32]interface{} // do not print descendants of these nodes, for --prune
+	pruneHidden bool                   // do not print nodes of prune either
+
+	showChildCount bool                              // print the number of direct children
+	tree           map[uint32]map[uint32]interface{} // parent -> children, for showChildCount
+
+	showLevel bool              // print the depth relative to the given TaxId
+	colors    map[string]string // rank -> ANSI color code, for --color
+
+	pruned int // number of nodes not printed due to maxDepth, only counted with --verbose
+}
+
+// writeNode writes the TaxId, and optional rank, name, and lineage of a node.
+func (opt *listOption) writeNode(outfh *xopen.Writer, taxid uint32) {
+	if code, ok := opt.colors[opt.ranks[taxid]]; ok && !opt.jsonFormat {
+		outfh.WriteString("\x1b[" + code + "m" + opt.nodeLabel(taxid) + "\x1b[0m")
+	} else {
+		outfh.WriteString(opt.nodeLabel(taxid))
+	}
+	if opt.showLineage && !opt.jsonFormat {
+		outfh.WriteString("\t" + opt.lineage(taxid))
+	}
+}
+
+// writeLevel writes the depth of a node relative to the given TaxId as a
+// leading column for --show-level in plain text format.
+func (opt *listOption) writeLevel(outfh *xopen.Writer, depth int) {
+	if opt.showLevel && !opt.jsonFormat {
+		outfh.WriteString(strconv.Itoa(depth) + "\t")
+	}
+}
+
+// visibleAncestors returns the ancestors of a TaxId to print, from the root to the parent.
+func (opt *listOption) visibleAncestors(taxid uint32) []uint32 {
+	ancestors := make([]uint32, 0, 32)
+	var parent uint32
+	var ok bool
+	for {
+		parent, ok = opt.parents[taxid]
+		if !ok || parent == taxid {
+			break
+		}
+		if opt.isVisible(parent) {
+			ancestors = append(ancestors, parent)
+		}
+		taxid = parent
+	}
+	reverseUint32s(ancestors)
+	return ancestors
+}
+
+// writeAncestor writes an ancestor of a given TaxId for --ancestors. In JSON
+// format, the object is opened but not closed, and it always has a child.
+func (opt *listOption) writeAncestor(outfh *xopen.Writer, taxid uint32, level int) {
+	outfh.WriteString(strings.Repeat(opt.indent, level))
+	if !opt.jsonFormat {
+		opt.writeNode(outfh, taxid)
+		outfh.WriteString("\n")
+		return
+	}
+
+	outfh.WriteString(`"`)
+	opt.writeNode(outfh, taxid)
+	outfh.WriteString("\": {\n")
+	if opt.showLineage {
+		outfh.WriteString(strings.Repeat(opt.indent, level+1))
+		outfh.WriteString(`"lineage": ` + jsonString(opt.lineage(taxid)) + ",\n")
+	}
+}
+
+// writeJSONFields writes fields of a node object for --json-objects, each line
+// ends with a comma as "children" always follows. depth is the depth of the
+// node relative to the given TaxId.
+func (opt *listOption) writeJSONFields(outfh *xopen.Writer, taxid uint32, depth int, level int) {
+	indent := strings.Repeat(opt.indent, level)
+	outfh.WriteString(fmt.Sprintf("%s\"taxid\": %d,\n", indent, taxid))
+	if opt.printName {
+		outfh.WriteString(indent + `"name": ` + jsonString(opt.names[taxid]) + ",\n")
+	}
+	if opt.printRank {
+		outfh.WriteString(indent + `"rank": ` + jsonString(opt.ranks[taxid]) + ",\n")
+	}
+	if opt.showLineage {
+		outfh.WriteString(indent + `"lineage": ` + jsonString(opt.lineage(taxid)) + ",\n")
+	}
+	if opt.showChildCount {
+		outfh.WriteString(fmt.Sprintf("%s\"child_count\": %d,\n", indent, len(opt.tree[taxid])))
+	}
+	if opt.showLevel {
+		outfh.WriteString(fmt.Sprintf("%s\"level\": %d,\n", indent, depth))
+	}
+}
+
+// openJSONObject writes the beginning of a node object till the opening
+// bracket of "children", for ancestors of --ancestors with --json-objects.
+func (opt *listOption) openJSONObject(outfh *xopen.Writer, taxid uint32, depth int, level int) {
+	outfh.WriteString(strings.Repeat(opt.indent, level) + "{\n")
+	opt.writeJSONFields(outfh, taxid, depth, level+1)
+	outfh.WriteString(strings.Repeat(opt.indent, level+1) + `"children": [` + "\n")
+}
+
+// closeJSONObject closes a node object opened by openJSONObject.
+func (opt *listOption) closeJSONObject(outfh *xopen.Writer, level int) {
+	outfh.WriteString(strings.Repeat(opt.indent, level+1) + "]\n")
+	outfh.WriteString(strings.Repeat(opt.indent, level) + "}")
+}
+
+// jsonObjectFrame records the progress of writing children of a node object
+// in writeJSONObject.
+type jsonObjectFrame struct {
+	children []listNode
+	next     int // index of the next child to write
+	level    int
+}
+
+// writeJSONObject writes the node object of taxid and its descendants for
+// --json-objects, without the trailing comma and newline. depth is the depth
+// of the children relative to the given TaxId.
+// It uses an explicit stack instead of recursion, so very deep trees are OK.
+func writeJSONObject(
+	tree map[uint32]map[uint32]interface{},
+	taxid uint32,
+	depth int,
+	outfh *xopen.Writer,
+	level int,
+	opt *listOption,
+) {
+	stack := make([]jsonObjectFrame, 0, 16)
+
+	// open writes the object of a node, which is left open if it has children
+	open := func(taxid uint32, depth int, level int) {
+		children := visibleChildren(tree, taxid, depth, opt, nil)
+		if len(children) == 0 {
+			indent := strings.Repeat(opt.indent, level)
+			outfh.WriteString(indent + "{\n")
+			opt.writeJSONFields(outfh, taxid, depth-1, level+1)
+			outfh.WriteString(indent + opt.indent + `"children": []` + "\n")
+			outfh.WriteString(indent + "}")
+			return
+		}
+
+		opt.openJSONObject(outfh, taxid, depth-1, level)
+		stack = append(stack, jsonObjectFrame{children: children, level: level})
+	}
+
+	// separate ends the object of the previous child in a frame
+	separate := func(frame *jsonObjectFrame) {
+		if frame.next < len(frame.children) {
+			outfh.WriteString(",")
+		}
+		outfh.WriteString("\n")
+	}
+
+	open(taxid, depth, level)
+
+	var frame *jsonObjectFrame
+	var node listNode
+	var n int
+	for len(stack) > 0 {
+		frame = &stack[len(stack)-1]
+
+		if frame.next >= len(frame.children) {
+			opt.closeJSONObject(outfh, frame.level)
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				separate(&stack[len(stack)-1])
+			}
+			continue
+		}
+
+		node = frame.children[frame.next]
+		frame.next++
+
+		n = len(stack)
+		open(node.taxid, node.depth+1, frame.level+2)
+		if len(stack) == n { // the object of the child is closed
+			separate(&stack[n-1])
+		}
+	}
+}
+
+// listColorSchemes are ANSI color codes of ranks for --color.
+var listColorSchemes = map[string]map[string]string{
+	"default": {
+		"realm":        "31",
+		"domain":       "31",
+		"superkingdom": "31",
+		"kingdom":      "31",
+		"phylum":       "35",
+		"class":        "33",
+		"order":        "36",
+		"family":       "1;34",
+		"genus":        "34",
+		"species":      "32",
+	},
+	"bright": {
+		"realm":        "91",
+		"domain":       "91",
+		"superkingdom": "91",
+		"kingdom":      "91",
+		"phylum":       "95",
+		"class":        "93",
+		"order":        "96",
+		"family":       "1;94",
+		"genus":        "94",
+		"species":      "92",
+	},
+}
+
+// listOutFile returns the path of the output file of a TaxId for --out-pattern.
+// Characters other than letters, digits, ".", "-" and "_" in names are replaced by "_".
+func listOutFile(pattern string, taxid uint32, name string) string {
+	name = reNonFileNameChars.ReplaceAllString(name, "_")
+	return strings.NewReplacer("{taxid}", strconv.Itoa(int(taxid)), "{name}", name).Replace(pattern)
+}
+
+var reNonFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._\-]+`)
+
+// writePath writes the path of a node, i.e., the path of its parent and the
+// TaxId (or name), and optional lineage. The path of the node is returned.
+func (opt *listOption) writePath(outfh *xopen.Writer, parentPath string, taxid uint32) string {
+	var label string
+	if opt.printName {
+		label = opt.names[taxid]
+	} else {
+		label = strconv.Itoa(int(taxid))
+	}
+	path := label
+	if parentPath != "" {
+		path = parentPath + "/" + label
+	}
+
+	outfh.WriteString(path)
+	if opt.showLineage {
+		outfh.WriteString("\t" + opt.lineage(taxid))
+	}
+	return path
+}
+
+// lineage returns the complete lineage of a TaxId, delimited by semicolons.
+func (opt *listOption) lineage(taxid uint32) string {
+	lineage := make([]string, 0, 16)
+	var parent uint32
+	var ok bool
+	for {
+		lineage = append(lineage, opt.names[taxid])
+		parent, ok = opt.parents[taxid]
+		if !ok || parent == 1 || parent == taxid {
+			break
+		}
+		taxid = parent
+	}
+	stringutil.ReverseStringSliceInplace(lineage)
+	return strings.Join(lineage, ";")
+}
+
+// sortedChildren returns the children of a node, sorted by TaxId or name.
+func (opt *listOption) sortedChildren(tree map[uint32]map[uint32]interface{}, parent uint32) []uint32 {
+	children := make([]uint32, 0, len(tree[parent]))
+	for child := range tree[parent] {
+		children = append(children, child)
+	}
+
+	sort.Slice(children, func(i, j int) bool { return opt.less(children[i], children[j]) })
+	return children
+}
+
+// less compares two TaxIds by TaxId or name (with ties sorted by TaxId).
+func (opt *listOption) less(a, b uint32) bool {
+	if opt.sortByName {
+		na, nb := opt.names[a], opt.names[b]
+		if na != nb {
+			return na < nb
 		}
-		if printName {
-			outfh.WriteString(fmt.Sprintf(" %s", names[child]))
+	}
+	return a < b
+}
+
+// isVisible tells whether a node should be printed.
+func (opt *listOption) isVisible(taxid uint32) bool {
+	if len(opt.rankSet) > 0 {
+		if _, ok := opt.rankSet[opt.ranks[taxid]]; !ok {
+			return false
 		}
+	}
+	if len(opt.excludeRankSet) > 0 {
+		if _, ok := opt.excludeRankSet[opt.ranks[taxid]]; ok {
+			return false
+		}
+	}
+	return true
+}
 
-		var ok bool
+// isPruned tells whether the descendants of a node should not be printed, for --prune.
+func (opt *listOption) isPruned(taxid uint32) bool {
+	if opt.prune == nil {
+		return false
+	}
+	_, ok := opt.prune[taxid]
+	return ok
+}
+
+// listFrame records the progress of printing children of a node in traverseTree.
+type listFrame struct {
+	children []listNode
+	level    int
+	path     string // path of the parent, for --path
+	next     int    // index of the next child to print
+	open     bool   // whether the JSON object of the previous child needs to be closed
+}
+
+// newListFrame returns the frame for printing children of parent, or nil if
+// parent is not in the tree. The lineage of parent is written for JSON format.
+func newListFrame(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	outfh *xopen.Writer,
+	level int,
+	depth int,
+	opt *listOption,
+) *listFrame {
+	if _, ok := tree[parent]; !ok {
+		return nil
+	}
+
+	children := visibleChildren(tree, parent, depth, opt, nil)
+
+	if opt.jsonFormat && opt.showLineage {
+		outfh.WriteString(strings.Repeat(opt.indent, level))
+		outfh.WriteString(`"lineage": ` + jsonString(opt.lineage(parent)))
+		if len(children) > 0 {
+			outfh.WriteString(",")
+		}
+		outfh.WriteString("\n")
+	}
+
+	return &listFrame{children: children, level: level}
+}
+
+// traverseTree prints the descendants of parent in depth-first order.
+// level is the indentation level, and depth is the depth of the children
+// relative to the given TaxId. path is the path of parent, for --path.
+// It uses an explicit stack instead of recursion, so very deep trees are OK.
+func traverseTree(
+	// tree map[uint32]map[uint32]bool,
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	outfh *xopen.Writer,
+	level int,
+	depth int,
+	path string,
+	opt *listOption,
+) {
+	frame := newListFrame(tree, parent, outfh, level, depth, opt)
+	if frame == nil {
+		return
+	}
+	frame.path = path
+	traverseFrame(tree, frame, outfh, opt)
+}
+
+// traverseFrame prints the children in a frame and their descendants.
+func traverseFrame(
+	tree map[uint32]map[uint32]interface{},
+	frame *listFrame,
+	outfh *xopen.Writer,
+	opt *listOption,
+) {
+	indent := opt.indent
+	jsonFormat := opt.jsonFormat
+
+	stack := []*listFrame{frame}
+	var i, level int
+	var node listNode
+	var child uint32
+	var ok bool
+	var path string
+	for len(stack) > 0 {
+		frame = stack[len(stack)-1]
+		level = frame.level
+
+		if frame.open { // close the JSON object of the previous child
+			frame.open = false
+			i = frame.next - 1
+			outfh.WriteString(fmt.Sprintf("%s}", strings.Repeat(indent, level)))
+			if i < len(frame.children)-1 {
+				outfh.WriteString(",")
+			}
+			outfh.WriteString("\n")
+			if opt.config.LineBuffered {
+				outfh.Flush()
+			}
+		}
+
+		if frame.next >= len(frame.children) {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		i = frame.next
+		node = frame.children[i]
+		frame.next++
+		child = node.taxid
+
+		if opt.pathFormat {
+			path = opt.writePath(outfh, frame.path, child)
+		} else {
+			opt.writeLevel(outfh, node.depth)
+			outfh.WriteString(strings.Repeat(indent, level))
+
+			if jsonFormat {
+				outfh.WriteString(`"`)
+			}
+			opt.writeNode(outfh, child)
+		}
+
+		ok = false
 		if jsonFormat {
 			_, ok = tree[child]
 			if ok {
 				outfh.WriteString(`": {`)
 			} else {
 				outfh.WriteString(`": {}`)
-				if i < len(children)-1 {
+				if i < len(frame.children)-1 {
 					outfh.WriteString(",")
 				}
 			}
 		}
 		outfh.WriteString("\n")
-		if config.LineBuffered {
+		if opt.config.LineBuffered {
 			outfh.Flush()
 		}
 
-		// tree[parent][child] = true
+		frame.open = jsonFormat && ok
 
-		traverseTree(tree, child, outfh, indent, level+1, names, printName,
-			ranks, printRank, jsonFormat, config)
+		if frame = newListFrame(tree, child, outfh, level+1, node.depth+1, opt); frame != nil {
+			frame.path = path
+			stack = append(stack, frame)
+		}
+	}
+}
 
-		if jsonFormat && ok {
-			outfh.WriteString(fmt.Sprintf("%s}", strings.Repeat(indent, level)))
-			if level > 1 && i < len(children)-1 {
-				outfh.WriteString(",")
+// traverseTreeBFS prints nodes and their descendants in breadth-first order,
+// i.e., level by level. Nodes of each level are sorted with opt.less.
+// level is the indentation level of the given nodes, and path is the path of
+// their parent, for --path.
+func traverseTreeBFS(
+	tree map[uint32]map[uint32]interface{},
+	nodes []listNode,
+	outfh *xopen.Writer,
+	level int,
+	path string,
+	opt *listOption,
+) {
+	for i := range nodes {
+		nodes[i].path = path
+	}
+
+	var next []listNode
+	var i int
+	for len(nodes) > 0 {
+		sort.Slice(nodes, func(i, j int) bool { return opt.less(nodes[i].taxid, nodes[j].taxid) })
+
+		next = nil
+		for _, node := range nodes {
+			if opt.pathFormat {
+				path = opt.writePath(outfh, node.path, node.taxid)
+			} else {
+				opt.writeLevel(outfh, node.depth)
+				outfh.WriteString(strings.Repeat(opt.indent, level))
+				opt.writeNode(outfh, node.taxid)
 			}
 			outfh.WriteString("\n")
-			if config.LineBuffered {
+			if opt.config.LineBuffered {
 				outfh.Flush()
 			}
+
+			i = len(next)
+			next = visibleChildren(tree, node.taxid, node.depth+1, opt, next)
+			if opt.pathFormat {
+				for ; i < len(next); i++ {
+					next[i].path = path
+				}
+			}
+		}
+
+		nodes = next
+		level++
+	}
+}
+
+// listNode is a node to print, depth is relative to the given TaxId.
+type listNode struct {
+	taxid uint32
+	depth int
+	path  string // path of the parent, only used in traverseTreeBFS
+}
+
+// childrenFrame records sorted children of a node left to check, in
+// visibleChildren and leaves. depth is the depth of the children.
+type childrenFrame struct {
+	children []uint32
+	depth    int
+}
+
+// pushChildren pushes the frame of children of parent to the stack, unless
+// they are out of the maximum depth or pruned.
+func (opt *listOption) pushChildren(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	depth int,
+	stack []childrenFrame,
+) []childrenFrame {
+	if opt.maxDepth >= 0 && depth > opt.maxDepth {
+		if opt.config.Verbose { // counting is not free for big subtrees
+			opt.pruned += countDescendants(tree, parent)
+		}
+		return stack
+	}
+	if opt.isPruned(parent) {
+		return stack
+	}
+	return append(stack, childrenFrame{children: opt.sortedChildren(tree, parent), depth: depth})
+}
+
+// visibleChildren returns the nearest descendants of parent to print.
+// Hidden nodes (e.g., filtered by rank) are not returned but their
+// descendants are, so the output keeps the structure of the tree.
+func visibleChildren(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	depth int,
+	opt *listOption,
+	nodes []listNode,
+) []listNode {
+	stack := opt.pushChildren(tree, parent, depth, nil)
+
+	var frame *childrenFrame
+	var child uint32
+	var ok bool
+	for len(stack) > 0 {
+		frame = &stack[len(stack)-1]
+		if len(frame.children) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		child = frame.children[0]
+		frame.children = frame.children[1:]
+
+		if opt.keep != nil {
+			if _, ok = opt.keep[child]; !ok {
+				continue
+			}
+		}
+		if opt.pruneHidden && opt.isPruned(child) {
+			continue
+		}
+		if opt.isVisible(child) {
+			nodes = append(nodes, listNode{taxid: child, depth: frame.depth})
+			continue
+		}
+		stack = opt.pushChildren(tree, child, frame.depth+1, stack)
+	}
+	return nodes
+}
+
+// newickFrame records the progress of writing children of a node in writeNewick.
+type newickFrame struct {
+	taxid    uint32
+	children []listNode
+	next     int // index of the next child to write
+}
+
+// writeNewick writes the subtree of parent in Newick format, without the
+// terminating semicolon. depth is the depth of the children relative to
+// the given TaxId. Internal labels are placed after the closing parenthesis.
+// It uses an explicit stack instead of recursion, so very deep trees are OK.
+func writeNewick(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	outfh *xopen.Writer,
+	depth int,
+	opt *listOption,
+) {
+	stack := make([]newickFrame, 0, 16)
+	push := func(taxid uint32, depth int) {
+		children := visibleChildren(tree, taxid, depth, opt, nil)
+		if len(children) > 0 {
+			outfh.WriteString("(")
+		}
+		stack = append(stack, newickFrame{taxid: taxid, children: children})
+	}
+
+	push(parent, depth)
+
+	var frame *newickFrame
+	var node listNode
+	for len(stack) > 0 {
+		frame = &stack[len(stack)-1]
+
+		if frame.next < len(frame.children) {
+			if frame.next > 0 {
+				outfh.WriteString(",")
+			}
+			node = frame.children[frame.next]
+			frame.next++
+			push(node.taxid, node.depth+1)
+			continue
+		}
+
+		if len(frame.children) > 0 {
+			outfh.WriteString(")")
+		}
+		if opt.printName {
+			outfh.WriteString(newickLabel(opt.names[frame.taxid]))
+		} else {
+			outfh.WriteString(strconv.Itoa(int(frame.taxid)))
+		}
+		stack = stack[:len(stack)-1]
+	}
+}
+
+// newickLabel quotes a label with single quotes if it contains blanks
+// or characters with special meanings in Newick format. Single quotes
+// in the label are doubled.
+func newickLabel(label string) string {
+	if !strings.ContainsAny(label, " \t,():;'[]") {
+		return label
+	}
+	return "'" + strings.ReplaceAll(label, "'", "''") + "'"
+}
+
+// writeDot writes nodes and edges of the subtree of root in GraphViz DOT format.
+// Visited nodes are skipped, which avoids duplicated records of overlapping
+// subtrees and infinite loops caused by cycles in malformed dump files.
+func writeDot(
+	tree map[uint32]map[uint32]interface{},
+	root uint32,
+	outfh *xopen.Writer,
+	opt *listOption,
+	visited map[uint32]interface{},
+) {
+	if _, ok := visited[root]; ok {
+		return
+	}
+	visited[root] = struct{}{}
+	outfh.WriteString(fmt.Sprintf("  %d [label=%s];\n", root, dotLabel(opt.nodeLabel(root))))
+
+	stack := []listNode{{taxid: root, depth: 0}}
+	var node listNode
+	var ok bool
+	for len(stack) > 0 {
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, child := range visibleChildren(tree, node.taxid, node.depth+1, opt, nil) {
+			outfh.WriteString(fmt.Sprintf("  %d -> %d;\n", node.taxid, child.taxid))
+			if _, ok = visited[child.taxid]; ok {
+				continue
+			}
+			visited[child.taxid] = struct{}{}
+			outfh.WriteString(fmt.Sprintf("  %d [label=%s];\n", child.taxid, dotLabel(opt.nodeLabel(child.taxid))))
+			stack = append(stack, child)
+		}
+		if opt.config.LineBuffered {
+			outfh.Flush()
+		}
+	}
+}
+
+// writeEdges writes edges of the subtree of root as tab-delimited rows of
+// parent and child, in depth-first order. Edges of nodes in visited, i.e.,
+// nodes in overlapping subtrees, are not written again.
+func writeEdges(
+	tree map[uint32]map[uint32]interface{},
+	root uint32,
+	outfh *xopen.Writer,
+	opt *listOption,
+	visited map[uint32]interface{},
+) {
+	if _, ok := visited[root]; ok {
+		return
+	}
+	visited[root] = struct{}{}
+
+	type edge struct {
+		parent uint32
+		child  listNode
+	}
+	stack := make([]edge, 0, 64)
+	push := func(parent listNode) {
+		children := visibleChildren(tree, parent.taxid, parent.depth+1, opt, nil)
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, edge{parent: parent.taxid, child: children[i]})
+		}
+	}
+
+	push(listNode{taxid: root, depth: 0})
+	var e edge
+	var ok bool
+	for len(stack) > 0 {
+		e = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		outfh.WriteString(opt.edgeNode(e.parent) + "\t" + opt.edgeNode(e.child.taxid) + "\n")
+		if opt.config.LineBuffered {
+			outfh.Flush()
+		}
+
+		if _, ok = visited[e.child.taxid]; ok {
+			continue
+		}
+		visited[e.child.taxid] = struct{}{}
+		push(e.child)
+	}
+}
+
+// edgeNode returns the TaxId, and optional name and rank of a node, for --edges.
+func (opt *listOption) edgeNode(taxid uint32) string {
+	s := strconv.Itoa(int(taxid))
+	if opt.printName {
+		s += "\t" + opt.names[taxid]
+	}
+	if opt.printRank {
+		s += "\t" + opt.ranks[taxid]
+	}
+	return s
+}
+
+// edgesHeader returns the header row of --edges.
+func (opt *listOption) edgesHeader() string {
+	columns := make([]string, 0, 6)
+	for _, node := range []string{"parent", "child"} {
+		columns = append(columns, node)
+		if opt.printName {
+			columns = append(columns, node+"_name")
+		}
+		if opt.printRank {
+			columns = append(columns, node+"_rank")
+		}
+	}
+	return strings.Join(columns, "\t")
+}
+
+// nodeLabel returns the TaxId, and optional rank, name, and number of children of a node.
+func (opt *listOption) nodeLabel(taxid uint32) string {
+	label := strconv.Itoa(int(taxid))
+	if opt.printRank {
+		label += fmt.Sprintf(" [%s]", opt.ranks[taxid])
+	}
+	if opt.printName {
+		label += " " + opt.names[taxid]
+	}
+	if opt.showChildCount {
+		label += fmt.Sprintf(" (%d)", len(opt.tree[taxid]))
+	}
+	return label
+}
+
+// dotLabel quotes a label with double quotes, escaping backslashes and double quotes.
+func dotLabel(label string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(label, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// countVisible returns the number of descendants of parent that would be printed.
+func countVisible(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	depth int,
+	opt *listOption,
+) int {
+	var n int
+	stack := visibleChildren(tree, parent, depth, opt, nil)
+	var node listNode
+	for len(stack) > 0 {
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n++
+		stack = visibleChildren(tree, node.taxid, node.depth+1, opt, stack)
+	}
+	return n
+}
+
+// globToRegexp converts a glob pattern to a case-insensitive regular expression
+// matching the whole string. "*", "?", and "[...]" are supported.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.NewReplacer(`\*`, `.*`, `\?`, `.`, `\[`, `[`, `\]`, `]`).Replace(expr)
+	return regexp.Compile(`(?i)^` + expr + `$`)
+}
+
+// taxidsMatchingGlobs returns sorted TaxIds of which the names match any of the patterns.
+func taxidsMatchingGlobs(names map[uint32]string, res []*regexp.Regexp) []uint32 {
+	taxids := make([]uint32, 0, 64)
+	for taxid, name := range names {
+		for _, re := range res {
+			if re.MatchString(name) {
+				taxids = append(taxids, taxid)
+				break
+			}
+		}
+	}
+	sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+	return taxids
+}
+
+// removeNestedTaxids removes TaxIds which are descendants of other ones,
+// keeping the order.
+func removeNestedTaxids(ids []int, parents map[uint32]uint32) []int {
+	idSet := make(map[uint32]interface{}, len(ids))
+	for _, id := range ids {
+		idSet[uint32(id)] = struct{}{}
+	}
+
+	ids2 := make([]int, 0, len(ids))
+	var taxid, parent uint32
+	var ok, nested bool
+	for _, id := range ids {
+		taxid = uint32(id)
+		nested = false
+		for {
+			parent, ok = parents[taxid]
+			if !ok || parent == taxid {
+				break
+			}
+			if _, ok = idSet[parent]; ok {
+				nested = true
+				break
+			}
+			taxid = parent
+		}
+		if !nested {
+			ids2 = append(ids2, id)
+		}
+	}
+	return ids2
+}
+
+// countRanks counts descendants of parent that would be printed by their ranks.
+// Nodes in visited are skipped and recorded if visited is not nil.
+func countRanks(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	depth int,
+	opt *listOption,
+	counts map[string]int,
+	visited map[uint32]interface{},
+) {
+	stack := visibleChildren(tree, parent, depth, opt, nil)
+	var node listNode
+	for len(stack) > 0 {
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		countRank(node.taxid, opt, counts, visited)
+		stack = visibleChildren(tree, node.taxid, node.depth+1, opt, stack)
+	}
+}
+
+// countRank increases the count of the rank of a TaxId, if it's not visited.
+func countRank(taxid uint32, opt *listOption, counts map[string]int, visited map[uint32]interface{}) {
+	if visited != nil {
+		if _, ok := visited[taxid]; ok {
+			return
+		}
+		visited[taxid] = struct{}{}
+	}
+	counts[opt.ranks[taxid]]++
+}
+
+// formatRankCounts formats rank counts like "species: 1203, genus: 45",
+// sorted by counts in descending order, with ties sorted by ranks.
+func formatRankCounts(counts map[string]int) string {
+	ranks := make([]string, 0, len(counts))
+	for rank := range counts {
+		ranks = append(ranks, rank)
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if counts[ranks[i]] == counts[ranks[j]] {
+			return ranks[i] < ranks[j]
+		}
+		return counts[ranks[i]] > counts[ranks[j]]
+	})
+
+	items := make([]string, len(ranks))
+	for i, rank := range ranks {
+		items[i] = fmt.Sprintf("%s: %d", rank, counts[rank])
+	}
+	return strings.Join(items, ", ")
+}
+
+// leaves returns the leaves (nodes without children) in the subtree of parent
+// to print, in depth-first order. depth is the depth of the children relative
+// to the given TaxId.
+func (opt *listOption) leaves(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	depth int,
+	leaves []uint32,
+) []uint32 {
+	stack := opt.pushChildren(tree, parent, depth, nil)
+
+	var frame *childrenFrame
+	var child uint32
+	var ok bool
+	for len(stack) > 0 {
+		frame = &stack[len(stack)-1]
+		if len(frame.children) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		child = frame.children[0]
+		frame.children = frame.children[1:]
+
+		if opt.keep != nil {
+			if _, ok = opt.keep[child]; !ok {
+				continue
+			}
+		}
+		if opt.isPruned(child) { // pruned nodes are leaves
+			if !opt.pruneHidden && opt.isVisible(child) {
+				leaves = append(leaves, child)
+			}
+			continue
+		}
+		if len(tree[child]) == 0 {
+			if opt.isVisible(child) {
+				leaves = append(leaves, child)
+			}
+			continue
+		}
+		stack = opt.pushChildren(tree, child, frame.depth+1, stack)
+	}
+	return leaves
+}
+
+// descendants appends the descendants of parent to print to taxids, in
+// depth-first order. depth is the depth of the children relative to the given TaxId.
+func (opt *listOption) descendants(
+	tree map[uint32]map[uint32]interface{},
+	parent uint32,
+	depth int,
+	taxids []uint32,
+) []uint32 {
+	stack := reverseListNodes(visibleChildren(tree, parent, depth, opt, nil))
+	var node listNode
+	var i int
+	for len(stack) > 0 {
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		taxids = append(taxids, node.taxid)
+
+		i = len(stack)
+		stack = visibleChildren(tree, node.taxid, node.depth+1, opt, stack)
+		reverseListNodes(stack[i:])
+	}
+	return taxids
+}
+
+// reverseListNodes reverses nodes in place, for pushing them to a stack.
+func reverseListNodes(nodes []listNode) []listNode {
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	return nodes
+}
+
+// countDescendants returns the number of descendants of a TaxId, excluding itself.
+func countDescendants(tree map[uint32]map[uint32]interface{}, parent uint32) int {
+	var n int
+	stack := []uint32{parent}
+	var taxid uint32
+	for len(stack) > 0 {
+		taxid = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for child := range tree[taxid] {
+			n++
+			stack = append(stack, child)
 		}
 	}
+	return n
 }
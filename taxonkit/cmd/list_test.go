@@ -0,0 +1,590 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shenwei356/xopen"
+)
+
+// listTestTree returns a small tree:
+//
+//	1 (no rank)
+//	├── 2 (phylum)
+//	│   ├── 4 (genus)
+//	│   │   ├── 6 (species)
+//	│   │   └── 8 (no rank)
+//	│   │       └── 9 (species)
+//	│   └── 5 (species)
+//	└── 3 (phylum)
+//	    └── 7 (genus)
+func listTestTree() (map[uint32]map[uint32]interface{}, map[uint32]string, map[uint32]string) {
+	parents := map[uint32]uint32{2: 1, 3: 1, 4: 2, 5: 2, 6: 4, 7: 3, 8: 4, 9: 8}
+	tree := make(map[uint32]map[uint32]interface{})
+	for child, parent := range parents {
+		if _, ok := tree[parent]; !ok {
+			tree[parent] = make(map[uint32]interface{})
+		}
+		tree[parent][child] = struct{}{}
+	}
+	ranks := map[uint32]string{1: "no rank", 2: "phylum", 3: "phylum", 4: "genus",
+		5: "species", 6: "species", 7: "genus", 8: "no rank", 9: "species"}
+	names := map[uint32]string{1: "root", 2: "Beta", 3: "Alpha", 4: "Gamma",
+		5: "Gamma x", 6: "Gamma a", 7: "Delta", 8: "unclassified Gamma", 9: "Gamma 'b'"}
+	return tree, names, ranks
+}
+
+// listTestOutputs writes outputs of all tree functions of list for the subtree of 1.
+func listTestOutputs(opt *listOption, tree map[uint32]map[uint32]interface{}) string {
+	var buf bytes.Buffer
+	outfh := &xopen.Writer{Writer: bufio.NewWriter(&buf)}
+
+	writeJSONObject(tree, 1, 1, outfh, 1, opt)
+	outfh.WriteString("\n")
+	writeNewick(tree, 1, outfh, 1, opt)
+	outfh.WriteString(";\n")
+	outfh.WriteString(fmt.Sprintf("visible: %d\n", countVisible(tree, 1, 1, opt)))
+	outfh.WriteString(fmt.Sprintf("leaves: %v\n", opt.leaves(tree, 1, 1, nil)))
+	outfh.WriteString(fmt.Sprintf("descendants: %v\n", opt.descendants(tree, 1, 1, nil)))
+	counts := make(map[string]int)
+	countRanks(tree, 1, 1, opt, counts, nil)
+	outfh.WriteString("ranks: " + formatRankCounts(counts) + "\n")
+	outfh.WriteString(fmt.Sprintf("all descendants: %d\n", countDescendants(tree, 1)))
+	outfh.WriteString(fmt.Sprintf("pruned: %d\n", opt.pruned))
+	outfh.Flush()
+	return buf.String()
+}
+
+func TestListTreeFunctions(t *testing.T) {
+	tree, names, ranks := listTestTree()
+
+	tests := []struct {
+		name   string
+		modify func(opt *listOption)
+		want   string
+	}{
+		{"default", func(opt *listOption) {}, `  {
+    "taxid": 1,
+    "rank": "no rank",
+    "child_count": 2,
+    "level": 0,
+    "children": [
+      {
+        "taxid": 2,
+        "rank": "phylum",
+        "child_count": 2,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 4,
+            "rank": "genus",
+            "child_count": 2,
+            "level": 2,
+            "children": [
+              {
+                "taxid": 6,
+                "rank": "species",
+                "child_count": 0,
+                "level": 3,
+                "children": []
+              },
+              {
+                "taxid": 8,
+                "rank": "no rank",
+                "child_count": 1,
+                "level": 3,
+                "children": [
+                  {
+                    "taxid": 9,
+                    "rank": "species",
+                    "child_count": 0,
+                    "level": 4,
+                    "children": []
+                  }
+                ]
+              }
+            ]
+          },
+          {
+            "taxid": 5,
+            "rank": "species",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      },
+      {
+        "taxid": 3,
+        "rank": "phylum",
+        "child_count": 1,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 7,
+            "rank": "genus",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      }
+    ]
+  }
+(((6,(9)8)4,5)2,(7)3)1;
+visible: 8
+leaves: [6 9 5 7]
+descendants: [2 4 6 8 9 5 3 7]
+ranks: species: 3, genus: 2, phylum: 2, no rank: 1
+all descendants: 8
+pruned: 0
+`},
+		{"names", func(opt *listOption) { opt.printName, opt.sortByName = true, true }, `  {
+    "taxid": 1,
+    "name": "root",
+    "rank": "no rank",
+    "child_count": 2,
+    "level": 0,
+    "children": [
+      {
+        "taxid": 3,
+        "name": "Alpha",
+        "rank": "phylum",
+        "child_count": 1,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 7,
+            "name": "Delta",
+            "rank": "genus",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      },
+      {
+        "taxid": 2,
+        "name": "Beta",
+        "rank": "phylum",
+        "child_count": 2,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 4,
+            "name": "Gamma",
+            "rank": "genus",
+            "child_count": 2,
+            "level": 2,
+            "children": [
+              {
+                "taxid": 6,
+                "name": "Gamma a",
+                "rank": "species",
+                "child_count": 0,
+                "level": 3,
+                "children": []
+              },
+              {
+                "taxid": 8,
+                "name": "unclassified Gamma",
+                "rank": "no rank",
+                "child_count": 1,
+                "level": 3,
+                "children": [
+                  {
+                    "taxid": 9,
+                    "name": "Gamma 'b'",
+                    "rank": "species",
+                    "child_count": 0,
+                    "level": 4,
+                    "children": []
+                  }
+                ]
+              }
+            ]
+          },
+          {
+            "taxid": 5,
+            "name": "Gamma x",
+            "rank": "species",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      }
+    ]
+  }
+((Delta)Alpha,(('Gamma a',('Gamma ''b''')'unclassified Gamma')Gamma,'Gamma x')Beta)root;
+visible: 8
+leaves: [7 6 9 5]
+descendants: [3 7 2 4 6 8 9 5]
+ranks: species: 3, genus: 2, phylum: 2, no rank: 1
+all descendants: 8
+pruned: 0
+`},
+		{"rank filter", func(opt *listOption) { opt.rankSet = map[string]interface{}{"species": struct{}{}} }, `  {
+    "taxid": 1,
+    "rank": "no rank",
+    "child_count": 2,
+    "level": 0,
+    "children": [
+      {
+        "taxid": 6,
+        "rank": "species",
+        "child_count": 0,
+        "level": 3,
+        "children": []
+      },
+      {
+        "taxid": 9,
+        "rank": "species",
+        "child_count": 0,
+        "level": 4,
+        "children": []
+      },
+      {
+        "taxid": 5,
+        "rank": "species",
+        "child_count": 0,
+        "level": 2,
+        "children": []
+      }
+    ]
+  }
+(6,9,5)1;
+visible: 3
+leaves: [6 9 5]
+descendants: [6 9 5]
+ranks: species: 3
+all descendants: 8
+pruned: 0
+`},
+		{"max depth", func(opt *listOption) { opt.maxDepth = 2; opt.config.Verbose = true }, `  {
+    "taxid": 1,
+    "rank": "no rank",
+    "child_count": 2,
+    "level": 0,
+    "children": [
+      {
+        "taxid": 2,
+        "rank": "phylum",
+        "child_count": 2,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 4,
+            "rank": "genus",
+            "child_count": 2,
+            "level": 2,
+            "children": []
+          },
+          {
+            "taxid": 5,
+            "rank": "species",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      },
+      {
+        "taxid": 3,
+        "rank": "phylum",
+        "child_count": 1,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 7,
+            "rank": "genus",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      }
+    ]
+  }
+((4,5)2,(7)3)1;
+visible: 5
+leaves: [5 7]
+descendants: [2 4 5 3 7]
+ranks: genus: 2, phylum: 2, species: 1
+all descendants: 8
+pruned: 18
+`},
+		{"prune", func(opt *listOption) { opt.prune = map[uint32]interface{}{4: struct{}{}} }, `  {
+    "taxid": 1,
+    "rank": "no rank",
+    "child_count": 2,
+    "level": 0,
+    "children": [
+      {
+        "taxid": 2,
+        "rank": "phylum",
+        "child_count": 2,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 4,
+            "rank": "genus",
+            "child_count": 2,
+            "level": 2,
+            "children": []
+          },
+          {
+            "taxid": 5,
+            "rank": "species",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      },
+      {
+        "taxid": 3,
+        "rank": "phylum",
+        "child_count": 1,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 7,
+            "rank": "genus",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      }
+    ]
+  }
+((4,5)2,(7)3)1;
+visible: 5
+leaves: [4 5 7]
+descendants: [2 4 5 3 7]
+ranks: genus: 2, phylum: 2, species: 1
+all descendants: 8
+pruned: 0
+`},
+		{"prune hidden", func(opt *listOption) {
+			opt.prune, opt.pruneHidden = map[uint32]interface{}{4: struct{}{}}, true
+		}, `  {
+    "taxid": 1,
+    "rank": "no rank",
+    "child_count": 2,
+    "level": 0,
+    "children": [
+      {
+        "taxid": 2,
+        "rank": "phylum",
+        "child_count": 2,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 5,
+            "rank": "species",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      },
+      {
+        "taxid": 3,
+        "rank": "phylum",
+        "child_count": 1,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 7,
+            "rank": "genus",
+            "child_count": 0,
+            "level": 2,
+            "children": []
+          }
+        ]
+      }
+    ]
+  }
+((5)2,(7)3)1;
+visible: 4
+leaves: [5 7]
+descendants: [2 5 3 7]
+ranks: phylum: 2, genus: 1, species: 1
+all descendants: 8
+pruned: 0
+`},
+		{"keep", func(opt *listOption) {
+			opt.keep = map[uint32]interface{}{2: struct{}{}, 4: struct{}{}, 8: struct{}{}, 9: struct{}{}}
+		}, `  {
+    "taxid": 1,
+    "rank": "no rank",
+    "child_count": 2,
+    "level": 0,
+    "children": [
+      {
+        "taxid": 2,
+        "rank": "phylum",
+        "child_count": 2,
+        "level": 1,
+        "children": [
+          {
+            "taxid": 4,
+            "rank": "genus",
+            "child_count": 2,
+            "level": 2,
+            "children": [
+              {
+                "taxid": 8,
+                "rank": "no rank",
+                "child_count": 1,
+                "level": 3,
+                "children": [
+                  {
+                    "taxid": 9,
+                    "rank": "species",
+                    "child_count": 0,
+                    "level": 4,
+                    "children": []
+                  }
+                ]
+              }
+            ]
+          }
+        ]
+      }
+    ]
+  }
+((((9)8)4)2)1;
+visible: 4
+leaves: [9]
+descendants: [2 4 8 9]
+ranks: genus: 1, no rank: 1, phylum: 1, species: 1
+all descendants: 8
+pruned: 0
+`},
+	}
+
+	for _, test := range tests {
+		opt := &listOption{indent: "  ", names: names, ranks: ranks, printRank: true,
+			maxDepth: -1, tree: tree, showChildCount: true, showLevel: true}
+		test.modify(opt)
+		got := listTestOutputs(opt, tree)
+		if got != test.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+// TestListDeepTree checks that a very deep tree, e.g., from a malformed dump
+// file, is handled without recursion.
+func TestListDeepTree(t *testing.T) {
+	const n = 200000
+	tree := make(map[uint32]map[uint32]interface{}, n)
+	for i := uint32(1); i < n; i++ {
+		tree[i] = map[uint32]interface{}{i + 1: struct{}{}}
+	}
+	opt := &listOption{indent: "", maxDepth: -1, tree: tree}
+
+	if c := countVisible(tree, 1, 1, opt); c != n-1 {
+		t.Errorf("countVisible: got %d, want %d", c, n-1)
+	}
+	if c := countDescendants(tree, 1); c != n-1 {
+		t.Errorf("countDescendants: got %d, want %d", c, n-1)
+	}
+	if leaves := opt.leaves(tree, 1, 1, nil); len(leaves) != 1 || leaves[0] != n {
+		t.Errorf("leaves: got %v, want [%d]", leaves, n)
+	}
+	if taxids := opt.descendants(tree, 1, 1, nil); len(taxids) != n-1 || taxids[n-2] != n {
+		t.Errorf("descendants: got %d TaxIds", len(taxids))
+	}
+
+	var buf bytes.Buffer
+	outfh := &xopen.Writer{Writer: bufio.NewWriter(&buf)}
+	writeNewick(tree, 1, outfh, 1, opt)
+	outfh.Flush()
+	if s := buf.String(); !strings.HasPrefix(s, "((((") || !strings.HasSuffix(s, ")2)1") {
+		t.Errorf("writeNewick: unexpected output")
+	}
+
+	buf.Reset()
+	writeJSONObject(tree, 1, 1, outfh, 0, opt)
+	outfh.Flush()
+	if c := strings.Count(buf.String(), `"taxid": `); c != n {
+		t.Errorf("writeJSONObject: got %d objects, want %d", c, n)
+	}
+}
+
+func TestListNewick(t *testing.T) {
+	// 1 root
+	// ├── 2 Homo sapiens
+	// │   ├── 3 Homo sapiens subsp. 'Denisova'
+	// │   └── 4 neanderthalensis
+	// └── 5 Pan (chimpanzees), etc.
+	//     └── 6 a:b;c
+	tree := map[uint32]map[uint32]interface{}{
+		1: {2: struct{}{}, 5: struct{}{}},
+		2: {3: struct{}{}, 4: struct{}{}},
+		3: {},
+		4: {},
+		5: {6: struct{}{}},
+		6: {},
+	}
+	names := map[uint32]string{1: "root", 2: "Homo sapiens", 3: "Homo sapiens subsp. 'Denisova'",
+		4: "neanderthalensis", 5: "Pan (chimpanzees), etc.", 6: "a:b;c"}
+	ranks := map[uint32]string{1: "no rank", 2: "species", 3: "subspecies",
+		4: "subspecies", 5: "genus", 6: "species"}
+
+	tests := []struct {
+		name string
+		opt  *listOption
+		want string
+	}{
+		{"TaxIds", &listOption{maxDepth: -1}, "((3,4)2,(6)5)1;\n"},
+		{"names", &listOption{names: names, printName: true, maxDepth: -1},
+			"(('Homo sapiens subsp. ''Denisova''',neanderthalensis)'Homo sapiens',('a:b;c')'Pan (chimpanzees), etc.')root;\n"},
+		{"max depth", &listOption{maxDepth: 1}, "(2,5)1;\n"},
+		{"only the given TaxId", &listOption{maxDepth: 0}, "1;\n"},
+		{"ranks", &listOption{ranks: ranks, maxDepth: -1,
+			rankSet: map[string]interface{}{"subspecies": struct{}{}, "genus": struct{}{}}}, "(3,4,5)1;\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		outfh := &xopen.Writer{Writer: bufio.NewWriter(&buf)}
+		writeNewick(tree, 1, outfh, 1, test.opt)
+		outfh.WriteString(";\n")
+		outfh.Flush()
+		if buf.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, buf.String(), test.want)
+		}
+	}
+}
+
+func TestNewickLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  string
+	}{
+		{"Homo", "Homo"},
+		{"Homo_sapiens", "Homo_sapiens"},
+		{"Homo sapiens", "'Homo sapiens'"},
+		{"Homo\tsapiens", "'Homo\tsapiens'"},
+		{"Homo sapiens subsp. 'Denisova'", "'Homo sapiens subsp. ''Denisova'''"},
+		{"it's", "'it''s'"},
+		{"a,b", "'a,b'"},
+		{"(a)", "'(a)'"},
+		{"a:b", "'a:b'"},
+		{"a;b", "'a;b'"},
+		{"[a]", "'[a]'"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := newickLabel(test.label); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.label, got, test.want)
+		}
+	}
+}
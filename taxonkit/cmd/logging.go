@@ -21,9 +21,14 @@
 package cmd
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"runtime"
+	"sync"
+	"time"
 
 	colorable "github.com/mattn/go-colorable"
 	"github.com/shenwei356/go-logging"
@@ -46,3 +51,93 @@ func init() {
 	logging.SetBackend(backendFormatter)
 	log = logging.MustGetLogger("taxonkit")
 }
+
+// logFormatPlain is used for log files, with dates and without colors.
+var logFormatPlain = logging.MustStringFormatter(
+	`%{time:2006-01-02 15:04:05.000} [%{level:.4s}] %{message}`,
+)
+
+// logToFile tells whether logs are written to a file via --log-file.
+var logToFile bool
+
+// useJSONLog switches the logging backend to output JSON lines to w.
+func useJSONLog(w io.Writer) {
+	logging.SetBackend(&jsonLogBackend{w: w})
+}
+
+// useLogFile switches the logging backend to append logs to a file.
+// Errors of opening the file are reported to stderr.
+func useLogFile(file string, jsonFormat bool) {
+	fh, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		checkError(fmt.Errorf("failed to open log file: %s", err))
+	}
+
+	if jsonFormat {
+		useJSONLog(fh)
+	} else {
+		backend := logging.NewLogBackend(fh, "", 0)
+		logging.SetBackend(logging.NewBackendFormatter(backend, logFormatPlain))
+	}
+	logToFile = true
+}
+
+// quietLog only keeps logs of errors. It must be called after switching
+// the logging backend, which resets the log level.
+func quietLog() {
+	logging.SetLevel(logging.ERROR, "taxonkit")
+}
+
+// jsonLogBackend writes log records as JSON lines, with a code and TaxIds
+// extracted from common messages about merged, deleted, and unfound TaxIds.
+type jsonLogBackend struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// message patterns -> codes, TaxIds are captured by groups
+var logCodes = []struct {
+	code string
+	re   *regexp.Regexp
+}{
+	{"merged", regexp.MustCompile(`taxid (\d+) was merged into (\d+)`)},
+	{"deleted", regexp.MustCompile(`taxid (\d+) was deleted`)},
+	{"not_found", regexp.MustCompile(`taxid (\d+)(?: .*)? not found`)},
+}
+
+func (b *jsonLogBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	msg := rec.Message()
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":` + jsonString(rec.Time.Format(time.RFC3339Nano)))
+	buf.WriteString(`,"level":` + jsonString(level.String()))
+
+	for _, c := range logCodes {
+		found := c.re.FindStringSubmatch(msg)
+		if found == nil {
+			continue
+		}
+		buf.WriteString(`,"code":` + jsonString(c.code))
+		buf.WriteString(`,"taxids":[`)
+		var n int
+		for _, s := range found[1:] {
+			if s == "" {
+				continue
+			}
+			if n > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString(s)
+			n++
+		}
+		buf.WriteString("]")
+		break
+	}
+
+	buf.WriteString(`,"message":` + jsonString(msg) + "}\n")
+
+	b.mu.Lock()
+	_, err := b.w.Write(buf.Bytes())
+	b.mu.Unlock()
+	return err
+}
@@ -21,11 +21,16 @@
 package cmd
 
 import (
+	"container/list"
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/shenwei356/breader"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 	"github.com/suggest-go/suggest/pkg/dictionary"
@@ -49,15 +54,141 @@ Attention:
     Drosophila      32281   subgenus
     Drosophila      2081351 genus
 
+     Use --rank to restrict the ranks of matched TaxIds, e.g.,
+
+    $ echo Drosophila | taxonkit name2taxid -r --rank subgenus
+    Drosophila      32281   subgenus
+
+     Use --show-parent to append the TaxId and name of the parent, e.g.,
+
+    $ echo Drosophila | taxonkit name2taxid -r --rank subgenus --show-parent
+    Drosophila      32281   subgenus        7215    Drosophila
+
+     Or use --show-lineage to append the complete lineage (delimited by ";"
+     as in "taxonkit lineage"), which is more informative for homonyms
+     in different kingdoms, e.g.,
+
+    $ echo Drosophila | taxonkit name2taxid --show-lineage | cut -c 1-80
+    Drosophila      7215    cellular organisms;Eukaryota;Opisthokonta;Metazoa;E
+    Drosophila      32281   cellular organisms;Eukaryota;Opisthokonta;Metazoa;E
+    Drosophila      2081351 cellular organisms;Eukaryota;Viridiplantae;Chloropl
+
+  2. Names of all name classes in names.dmp are searched by default.
+     Use -s/--sci-name to only search scientific names, or --name-class to
+     search names of given classes, where the matched name class is appended
+     after the TaxId (and rank).
+
+    $ echo human | taxonkit name2taxid --name-class "scientific name,genbank common name"
+    human   9606    genbank common name
+
+  3. Names are matched case-insensitively, e.g., "HOMO SAPIENS" is OK.
+     Use --trim-space to also remove leading/trailing spaces and collapse
+     consecutive spaces in both query names and names in names.dmp,
+     e.g., "Homo  sapiens". The original query is kept in the output.
+
+  4. Names can be in any column of tab-delimited input (-i/--name-field),
+     and TaxIds (and other optional columns) are appended to the end of lines,
+     with other columns preserved. Lines are duplicated for names with
+     multiple TaxIds.
+
+    $ echo -e "sample1\tDrosophila\t0.5" | taxonkit name2taxid -i 2 --rank genus
+    sample1 Drosophila      0.5     7215
+    sample1 Drosophila      0.5     2081351
+
+  5. Fuzzy match:
+     -f/--fuzzy uses n-gram similarity, while --edit-distance searches names
+     within a Levenshtein distance only when no exact match is found.
+     For --edit-distance, only names sharing the same first letter with the query
+     are compared, which is still slow (O(n) for each query) for the whole
+     NCBI taxonomy, so please use it only for a small number of queries.
+
+    $ echo Escherchia coli | taxonkit name2taxid --edit-distance 2
+    Escherchia coli 562     1
+
+  6. Use --prefer to output only the best TaxId for each query, with a policy:
+       smallest-taxid:   the smallest TaxId
+       largest-subtree:  the TaxId with the most descendants
+       lowest-rank:      the TaxId of the lowest rank, according to the rank
+                         order file used by "taxonkit filter", TaxIds of
+                         ranks without order (e.g., "no rank") come last
+     Ties are broken by choosing the smallest TaxId. With --edit-distance,
+     TaxIds of the smallest distance are considered first. --rank is applied
+     before choosing.
+
+    $ echo Drosophila | taxonkit name2taxid -r --prefer largest-subtree
+    Drosophila      7215    genus
+
+  7. Results of queries are cached (--cache-size), so repeated names in the
+     input are only searched once, which saves much time for fuzzy search
+     and --edit-distance. The least recently used ones are evicted when the
+     cache is full. The output is the same with or without the cache.
+
+  8. Names not found (unmatched) are outputted with an empty TaxId column,
+     which can be filled with a placeholder via --na-string. Blank lines are
+     skipped by default, use --keep-unmatched to output them as unmatched
+     ones too, so the output lines match the input ones for names with a
+     single TaxId, e.g., for joining results back by line numbers.
+
+    $ echo -e "Homo sapiens\n\nfoo" | taxonkit name2taxid --keep-unmatched --na-string NA
+    Homo sapiens    9606
+            NA
+    foo     NA
+
+  9. With --regexp, query names are treated as Go regular expressions
+     (https://pkg.go.dev/regexp/syntax), matched case-insensitively against
+     all names (limited by -s/--sci-name and --name-class), and all matched
+     TaxIds are outputted, with the matched names appended after the TaxId
+     (and rank). Note that all names (about 4 millions for NCBI Taxonomy) are
+     scanned for each query, i.e., O(N), so please use it only for a small
+     number of queries. Use "^" and "$" to anchor the pattern.
+
+    $ echo "^Escherichia .*coli$" | taxonkit name2taxid --regexp -s -r
+    ^Escherichia .*coli$    562     species Escherichia coli
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
 
 		printRank := getFlagBool(cmd, "show-rank")
+		rankSet := make(map[string]interface{})
+		for _, rank := range getFlagStringSlice(cmd, "rank") {
+			if rank == "" {
+				continue
+			}
+			rankSet[strings.ToLower(rank)] = struct{}{}
+		}
 		field := getFlagPositiveInt(cmd, "name-field") - 1
 		limite2SciName := getFlagBool(cmd, "sci-name")
 		fuzzy := getFlagBool(cmd, "fuzzy")
 		fuzzyTopN := getFlagPositiveInt(cmd, "fuzzy-top-n")
+		maxDist := getFlagNonNegativeInt(cmd, "edit-distance")
+		maxCandidates := getFlagPositiveInt(cmd, "max-candidates")
+		trimSpace := getFlagBool(cmd, "trim-space")
+		keepUnmatched := getFlagBool(cmd, "keep-unmatched")
+		naString := getFlagString(cmd, "na-string")
+		if maxDist > 0 && fuzzy {
+			checkError(fmt.Errorf("flag -f/--fuzzy and --edit-distance are exclusive"))
+		}
+		byRegexp := getFlagBool(cmd, "regexp")
+		if byRegexp && (fuzzy || maxDist > 0 || trimSpace) {
+			checkError(fmt.Errorf("flag --regexp can not be used along with -f/--fuzzy, --edit-distance, or --trim-space"))
+		}
+		nameClasses := make(map[string]interface{})
+		for _, class := range getFlagStringSlice(cmd, "name-class") {
+			if class == "" {
+				continue
+			}
+			nameClasses[strings.ToLower(class)] = struct{}{}
+		}
+		showClass := len(nameClasses) > 0
+		showParent := getFlagBool(cmd, "show-parent")
+		showLineage := getFlagBool(cmd, "show-lineage")
+		if showClass && limite2SciName {
+			checkError(fmt.Errorf(`flag -s/--sci-name and --name-class are exclusive, please use --name-class "scientific name" instead`))
+		}
+		prefer := getFlagPreferPolicy(cmd, "prefer")
+		needParents := showParent || showLineage || prefer == "largest-subtree"
+		needRanks := printRank || len(rankSet) > 0 || prefer == "lowest-rank"
 
 		files := getFileList(args)
 
@@ -70,22 +201,57 @@ Attention:
 		defer outfh.Close()
 
 		var m map[string][]uint32
+		var name2classes map[string]map[uint32]string // for --name-class
+
+		var initial2names map[rune][]string // first letter -> names, for --edit-distance
 
 		var dict dictionary.Dictionary
 		var service *suggest.Service
 
+		var nameRecords []taxonName // for --regexp
+
 		var wg sync.WaitGroup
 		wg.Add(1)
 
 		go func() {
+			if byRegexp {
+				if config.Verbose {
+					log.Infof("parsing names file: %s", config.NamesFile)
+				}
+				nameRecords = getTaxonNameRecords(config.NamesFile, limite2SciName, nameClasses)
+				if config.Verbose {
+					log.Infof("%d names parsed", len(nameRecords))
+				}
+				wg.Done()
+				return
+			}
+
 			if config.Verbose {
 				log.Infof("parsing names file: %s", config.NamesFile)
 			}
-			m = getTaxonName2Taxids(config.NamesFile, limite2SciName)
+			m, name2classes = getTaxonName2Taxids(config.NamesFile, limite2SciName, nameClasses)
 			if config.Verbose {
 				log.Infof("%d names parsed", len(m))
 			}
 
+			if trimSpace {
+				m = normalizeNameKeys(m)
+				if showClass {
+					name2classes = normalizeNameClassKeys(name2classes)
+				}
+			}
+
+			if maxDist > 0 {
+				initial2names = make(map[rune][]string, 64)
+				var r rune
+				for n := range m {
+					for _, r = range n {
+						break
+					}
+					initial2names[r] = append(initial2names[r], n)
+				}
+			}
+
 			if fuzzy {
 				if config.Verbose {
 					log.Infof("creating indexing for name searching ...")
@@ -124,8 +290,29 @@ Attention:
 		}()
 
 		var ranks map[uint32]string
+		var parents map[uint32]uint32 // for --show-parent and --show-lineage
+		var sciNames map[uint32]string
 
-		if printRank {
+		if needParents {
+			wg.Add(1)
+			go func() {
+				if config.Verbose {
+					log.Infof("parsing nodes file: %s", config.NodesFile)
+				}
+				parents, ranks = getNodes(config.NodesFile, needRanks)
+				if config.Verbose {
+					log.Infof("%d nodes parsed", len(parents))
+				}
+				wg.Done()
+			}()
+			if showParent || showLineage {
+				wg.Add(1)
+				go func() {
+					sciNames = getTaxonNames(config.NamesFile)
+					wg.Done()
+				}()
+			}
+		} else if needRanks {
 			wg.Add(1)
 			go func() {
 				if config.Verbose {
@@ -141,40 +328,173 @@ Attention:
 
 		wg.Wait()
 
+		var lineages *lineageCache // for --show-lineage
+		if showLineage {
+			lineages = newLineageCache(&taxonomy.Taxonomy{Nodes: parents}, 1<<20)
+		}
+		lineage := func(taxid uint32) string {
+			path := lineages.path(taxid)
+			names := make([]string, len(path))
+			for i, t := range path {
+				names[i] = sciNames[t]
+			}
+			return strings.Join(names, ";")
+		}
+
+		var selector *taxidSelector
+		if prefer != "" {
+			selector = newTaxidSelector(config, prefer, parents, func(taxid uint32) string { return ranks[taxid] })
+		}
+
 		// ----------------------------------------------------------
 
 		type line2taxids struct {
-			line   string
-			taxids []uint32
+			line string
+			name2taxidResult
 		}
 
-		fn := func(line string) (interface{}, bool, error) {
-			line = strings.Trim(line, "\r\n ")
-			if line == "" {
-				return nil, false, nil
-			}
-			data := strings.Split(line, "\t")
-			if len(data) < field+1 {
-				field = len(data) - 1
-			}
+		// resolve searches TaxIds of a query name
+		resolve := func(query string) name2taxidResult {
 			var taxids []uint32
-			if !fuzzy {
-				taxids = m[strings.ToLower(data[field])]
+			var dists []int
+			var names []string
+			var classes []string
+			if byRegexp {
+				re, err := regexp.Compile("(?i)" + query)
+				if err != nil {
+					checkError(fmt.Errorf("invalid regular expression: %s: %s", query, err))
+				}
+				for _, r := range nameRecords {
+					if !re.MatchString(r.Name) {
+						continue
+					}
+					taxids = append(taxids, r.TaxId)
+					names = append(names, r.Name)
+					if showClass {
+						classes = append(classes, r.Class)
+					}
+				}
+			} else if !fuzzy {
+				query := strings.ToLower(query)
+				if trimSpace {
+					query = normalizeSpace(query)
+				}
+				taxids = m[query]
+				if len(taxids) > 0 {
+					if maxDist > 0 {
+						dists = make([]int, len(taxids))
+					}
+					if showClass {
+						names = make([]string, len(taxids))
+						for i := range names {
+							names[i] = query
+						}
+					}
+				} else if maxDist > 0 {
+					taxids, dists, names = searchByEditDistance(query, initial2names, m, maxDist, maxCandidates)
+				}
 			} else {
-				searchConf, err := suggest.NewSearchConfig(data[field], fuzzyTopN, metric.CosineMetric(), 0.7)
+				if trimSpace {
+					query = normalizeSpace(query)
+				}
+				searchConf, err := suggest.NewSearchConfig(query, fuzzyTopN, metric.CosineMetric(), 0.7)
 				checkError(err)
 				result, err := service.Suggest("taxonkit", searchConf)
 				checkError(err)
 				taxids = make([]uint32, 0, 8)
+				var name string
 				for _, item := range result {
-					taxids = append(taxids, m[strings.ToLower(item.Value)]...)
+					name = strings.ToLower(item.Value)
+					taxids = append(taxids, m[name]...)
+					if showClass {
+						for range m[name] {
+							names = append(names, name)
+						}
+					}
+				}
+			}
+
+			if len(rankSet) > 0 && len(taxids) > 0 {
+				// use a new slice, as taxids is shared with the name map
+				_taxids := make([]uint32, 0, len(taxids))
+				var _dists []int
+				if dists != nil {
+					_dists = make([]int, 0, len(taxids))
+				}
+				var _names []string
+				if names != nil {
+					_names = make([]string, 0, len(taxids))
 				}
+				var _classes []string
+				if classes != nil {
+					_classes = make([]string, 0, len(taxids))
+				}
+				var ok bool
+				for i, taxid := range taxids {
+					if _, ok = rankSet[ranks[taxid]]; !ok {
+						continue
+					}
+					_taxids = append(_taxids, taxid)
+					if dists != nil {
+						_dists = append(_dists, dists[i])
+					}
+					if names != nil {
+						_names = append(_names, names[i])
+					}
+					if classes != nil {
+						_classes = append(_classes, classes[i])
+					}
+				}
+				taxids, dists, names, classes = _taxids, _dists, _names, _classes
 			}
 
-			return line2taxids{line, taxids}, true, nil
+			if selector != nil && len(taxids) > 1 {
+				i := selector.best(taxids, dists)
+				taxids = taxids[i : i+1]
+				if dists != nil {
+					dists = dists[i : i+1]
+				}
+				if names != nil {
+					names = names[i : i+1]
+				}
+				if classes != nil {
+					classes = classes[i : i+1]
+				}
+			}
+
+			return name2taxidResult{taxids, dists, names, classes}
+		}
+
+		// results of identical queries are reused
+		cache := newName2taxidCache(getFlagNonNegativeInt(cmd, "cache-size"))
+
+		fn := func(line string) (interface{}, bool, error) {
+			if line == "" { // the end of file, lines are ended with "\n" except the last one
+				return nil, false, nil
+			}
+			line = strings.Trim(line, "\r\n ")
+			if line == "" {
+				if keepUnmatched {
+					return line2taxids{line: line}, true, nil
+				}
+				return nil, false, nil
+			}
+			data := strings.Split(line, "\t")
+			field := field // do not change the shared value, which affects following lines
+			if len(data) < field+1 {
+				field = len(data) - 1
+			}
+
+			result, ok := cache.get(data[field])
+			if !ok {
+				result = resolve(data[field])
+				cache.add(data[field], result)
+			}
+			return line2taxids{line, result}, true, nil
 		}
 
 		var taxid uint32
+		var i int
 		for _, file := range files {
 			reader, err := breader.NewBufferedReader(file, config.Threads, 10, fn)
 			checkError(err)
@@ -188,10 +508,26 @@ Attention:
 					l2t = data.(line2taxids)
 					if len(l2t.taxids) == 0 {
 						if printRank {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\n", l2t.line, "", ""))
+							outfh.WriteString(fmt.Sprintf("%s\t%s\t%s", l2t.line, naString, ""))
 						} else {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\n", l2t.line, ""))
+							outfh.WriteString(fmt.Sprintf("%s\t%s", l2t.line, naString))
+						}
+						if byRegexp {
+							outfh.WriteString("\t")
+						}
+						if showClass {
+							outfh.WriteString("\t")
 						}
+						if showParent {
+							outfh.WriteString("\t\t")
+						}
+						if showLineage {
+							outfh.WriteString("\t")
+						}
+						if maxDist > 0 {
+							outfh.WriteString("\t")
+						}
+						outfh.WriteString("\n")
 						if config.LineBuffered {
 							outfh.Flush()
 						}
@@ -199,15 +535,37 @@ Attention:
 						continue
 					}
 
-					if len(l2t.taxids) > 1 {
+					if len(l2t.taxids) > 1 && !byRegexp {
 						log.Warningf("multiple TaxIds found for '%s'", l2t.line)
 					}
-					for _, taxid = range l2t.taxids {
+					for i, taxid = range l2t.taxids {
 						if printRank {
-							outfh.WriteString(fmt.Sprintf("%s\t%d\t%s\n", l2t.line, taxid, ranks[taxid]))
+							outfh.WriteString(fmt.Sprintf("%s\t%d\t%s", l2t.line, taxid, ranks[taxid]))
 						} else {
-							outfh.WriteString(fmt.Sprintf("%s\t%d\n", l2t.line, taxid))
+							outfh.WriteString(fmt.Sprintf("%s\t%d", l2t.line, taxid))
+						}
+						if byRegexp {
+							outfh.WriteString("\t" + l2t.names[i])
+							if showClass {
+								outfh.WriteString("\t" + l2t.classes[i])
+							}
+						} else if showClass {
+							outfh.WriteString("\t" + name2classes[l2t.names[i]][taxid])
+						}
+						if showParent {
+							if parent, ok := parents[taxid]; ok {
+								outfh.WriteString(fmt.Sprintf("\t%d\t%s", parent, sciNames[parent]))
+							} else {
+								outfh.WriteString("\t\t")
+							}
 						}
+						if showLineage {
+							outfh.WriteString("\t" + lineage(taxid))
+						}
+						if maxDist > 0 {
+							outfh.WriteString(fmt.Sprintf("\t%d", l2t.dists[i]))
+						}
+						outfh.WriteString("\n")
 						if config.LineBuffered {
 							outfh.Flush()
 						}
@@ -225,4 +583,281 @@ func init() {
 	name2taxidCmd.Flags().BoolP("sci-name", "s", false, "only searching scientific names")
 	name2taxidCmd.Flags().BoolP("fuzzy", "f", false, "allow fuzzy match")
 	name2taxidCmd.Flags().IntP("fuzzy-top-n", "n", 1, "choose top n matches in fuzzy search")
+	name2taxidCmd.Flags().StringSliceP("name-class", "", []string{}, `only search names of these name classes, e.g., "scientific name", "synonym", "common name", "genbank common name", "equivalent name", and append the matched name class as an extra column. multiple values can be separated with comma or give multiple times`)
+	name2taxidCmd.Flags().BoolP("show-parent", "", false, `append the TaxId and scientific name of the parent of each matched TaxId as two extra columns, for distinguishing TaxIds sharing the same name`)
+	name2taxidCmd.Flags().BoolP("show-lineage", "", false, `append the complete lineage (scientific names delimited by ";") of each matched TaxId as an extra column, after the columns of --show-parent`)
+	name2taxidCmd.Flags().StringSliceP("rank", "", []string{}, `only output TaxIds of these ranks, multiple values can be separated with comma (e.g., --rank "genus,species") or give multiple times`)
+	name2taxidCmd.Flags().BoolP("trim-space", "", false, `trim leading and trailing spaces, and collapse consecutive spaces of names before matching`)
+	name2taxidCmd.Flags().IntP("edit-distance", "", 0, `if no exact match, search names within this Levenshtein distance, and append the distance as an extra column. 0 for disabled`)
+	name2taxidCmd.Flags().IntP("max-candidates", "", 5, `maximum number of names returned for a query with --edit-distance`)
+	name2taxidCmd.Flags().StringP("prefer", "", "", `only output the best TaxId for each query, with a policy: smallest-taxid, largest-subtree, or lowest-rank. type "taxonkit name2taxid --help" for details`)
+	name2taxidCmd.Flags().BoolP("keep-unmatched", "", false, `also output blank lines as unmatched ones, so that input lines are all kept. names not found are always outputted`)
+	name2taxidCmd.Flags().StringP("na-string", "", "", `placeholder of the TaxId column for unmatched names, e.g., "NA"`)
+	name2taxidCmd.Flags().BoolP("regexp", "", false, `query names are case-insensitive Go regular expressions, all names are scanned for each query, and matched names are appended after TaxIds. type "taxonkit name2taxid --help" for details`)
+	name2taxidCmd.Flags().IntP("cache-size", "", 1<<18, `maximum number of distinct queries of which the results are cached for reusing by identical queries, the least recently used ones are evicted. 0 for no cache`)
+}
+
+// name2taxidResult is the result of searching a query name.
+// The slices are shared and should not be modified.
+type name2taxidResult struct {
+	taxids  []uint32
+	dists   []int    // edit distances, for --edit-distance
+	names   []string // matched names, for --name-class and --regexp
+	classes []string // name classes of matched names, for --regexp with --name-class
+}
+
+// name2taxidCache is an LRU cache of query -> name2taxidResult,
+// safe for concurrent use.
+type name2taxidCache struct {
+	maxSize int
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List // values are *name2taxidCacheItem, the front is the most recently used
+}
+
+type name2taxidCacheItem struct {
+	query  string
+	result name2taxidResult
+}
+
+func newName2taxidCache(maxSize int) *name2taxidCache {
+	size := maxSize
+	if size > 1024 {
+		size = 1024
+	}
+	return &name2taxidCache{maxSize: maxSize, items: make(map[string]*list.Element, size), lru: list.New()}
+}
+
+// get returns the cached result of a query.
+func (c *name2taxidCache) get(query string) (name2taxidResult, bool) {
+	if c.maxSize == 0 {
+		return name2taxidResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[query]
+	if !ok {
+		return name2taxidResult{}, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*name2taxidCacheItem).result, true
+}
+
+// add caches the result of a query, and evicts the least recently used one if the cache is full.
+func (c *name2taxidCache) add(query string, result name2taxidResult) {
+	if c.maxSize == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[query]; ok { // added by another goroutine
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.maxSize {
+		e := c.lru.Back()
+		delete(c.items, e.Value.(*name2taxidCacheItem).query)
+		c.lru.Remove(e)
+	}
+	c.items[query] = c.lru.PushFront(&name2taxidCacheItem{query: query, result: result})
+}
+
+// getFlagPreferPolicy returns the policy of choosing the best TaxId for --prefer.
+func getFlagPreferPolicy(cmd *cobra.Command, flag string) string {
+	prefer := strings.ToLower(getFlagString(cmd, flag))
+	switch prefer {
+	case "", "smallest-taxid", "largest-subtree", "lowest-rank":
+	default:
+		checkError(fmt.Errorf(`invalid value of flag --%s: %s. available: smallest-taxid, largest-subtree, lowest-rank`, flag, prefer))
+	}
+	return prefer
+}
+
+// taxidSelector chooses the best TaxId from TaxIds sharing the same name, for --prefer.
+type taxidSelector struct {
+	policy string
+
+	rank      func(taxid uint32) string // for lowest-rank
+	rankOrder map[string]int            // for lowest-rank, a lower rank has a smaller order
+
+	children map[uint32][]uint32 // for largest-subtree
+	sizes    map[uint32]int      // cached numbers of descendants
+	mu       sync.Mutex
+}
+
+// newTaxidSelector creates a taxidSelector. parents (child -> parent) is
+// needed for largest-subtree, and rank is needed for lowest-rank.
+func newTaxidSelector(config Config, policy string, parents map[uint32]uint32, rank func(taxid uint32) string) *taxidSelector {
+	s := &taxidSelector{policy: policy, rank: rank}
+	switch policy {
+	case "largest-subtree":
+		s.children = make(map[uint32][]uint32, len(parents))
+		for child, parent := range parents {
+			if child != parent {
+				s.children[parent] = append(s.children[parent], child)
+			}
+		}
+		s.sizes = make(map[uint32]int, 1024)
+	case "lowest-rank":
+		var err error
+		s.rankOrder, _, err = readRankOrder(config, "")
+		checkError(err)
+	}
+	return s
+}
+
+// best returns the index of the best TaxId. Smaller edit distances are
+// preferred if dists is not nil, and ties are broken by smaller TaxIds.
+func (s *taxidSelector) best(taxids []uint32, dists []int) int {
+	var b int
+	var better bool
+	for i := 1; i < len(taxids); i++ {
+		if dists != nil && dists[i] != dists[b] {
+			if dists[i] < dists[b] {
+				b = i
+			}
+			continue
+		}
+
+		switch s.policy {
+		case "largest-subtree":
+			si, sb := s.subtreeSize(taxids[i]), s.subtreeSize(taxids[b])
+			better = si > sb || (si == sb && taxids[i] < taxids[b])
+		case "lowest-rank":
+			oi, ob := s.order(taxids[i]), s.order(taxids[b])
+			better = oi < ob || (oi == ob && taxids[i] < taxids[b])
+		default: // smallest-taxid
+			better = taxids[i] < taxids[b]
+		}
+		if better {
+			b = i
+		}
+	}
+	return b
+}
+
+// order returns the rank order of a TaxId, ranks without order come last.
+func (s *taxidSelector) order(taxid uint32) int {
+	if o, ok := s.rankOrder[s.rank(taxid)]; ok {
+		return o
+	}
+	return math.MaxInt32
+}
+
+// subtreeSize returns the number of descendants of a TaxId.
+func (s *taxidSelector) subtreeSize(taxid uint32) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n, ok := s.sizes[taxid]; ok {
+		return n
+	}
+
+	var n int
+	stack := append(make([]uint32, 0, 64), s.children[taxid]...)
+	var child uint32
+	for len(stack) > 0 {
+		child = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n++
+		stack = append(stack, s.children[child]...)
+	}
+	s.sizes[taxid] = n
+	return n
+}
+
+// normalizeSpace trims leading and trailing spaces, and collapses consecutive spaces.
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// normalizeNameKeys re-indexes names with normalized spaces.
+// TaxIds of names sharing the same normalized form are merged.
+func normalizeNameKeys(m map[string][]uint32) map[string][]uint32 {
+	m2 := make(map[string][]uint32, len(m))
+	var key string
+	var taxid, t uint32
+	var existed bool
+	for name, taxids := range m {
+		key = normalizeSpace(name)
+		for _, taxid = range taxids {
+			existed = false
+			for _, t = range m2[key] {
+				if t == taxid {
+					existed = true
+					break
+				}
+			}
+			if !existed {
+				m2[key] = append(m2[key], taxid)
+			}
+		}
+	}
+	return m2
+}
+
+// normalizeNameClassKeys re-indexes name classes with normalized spaces.
+func normalizeNameClassKeys(m map[string]map[uint32]string) map[string]map[uint32]string {
+	m2 := make(map[string]map[uint32]string, len(m))
+	var key string
+	var ok bool
+	for name, taxid2class := range m {
+		key = normalizeSpace(name)
+		if _, ok = m2[key]; !ok {
+			m2[key] = make(map[uint32]string, len(taxid2class))
+		}
+		for taxid, class := range taxid2class {
+			if _, ok = m2[key][taxid]; !ok {
+				m2[key][taxid] = class
+			}
+		}
+	}
+	return m2
+}
+
+// searchByEditDistance searches names sharing the same first letter with the query
+// and within the maximum edit distance. Names are sorted by distance and then name,
+// and at most maxCandidates names are returned.
+func searchByEditDistance(query string, initial2names map[rune][]string,
+	name2taxids map[string][]uint32, maxDist int, maxCandidates int) ([]uint32, []int, []string) {
+	var initial rune
+	for _, initial = range query {
+		break
+	}
+
+	type candidate struct {
+		name string
+		dist int
+	}
+	candidates := make([]candidate, 0, 8)
+	var d int
+	for _, name := range initial2names[initial] {
+		d = levenshtein(query, name, maxDist)
+		if d > maxDist {
+			continue
+		}
+		candidates = append(candidates, candidate{name, d})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist == candidates[j].dist {
+			return candidates[i].name < candidates[j].name
+		}
+		return candidates[i].dist < candidates[j].dist
+	})
+	if len(candidates) > maxCandidates {
+		candidates = candidates[:maxCandidates]
+	}
+
+	taxids := make([]uint32, 0, len(candidates))
+	dists := make([]int, 0, len(candidates))
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		for _, taxid := range name2taxids[c.name] {
+			taxids = append(taxids, taxid)
+			dists = append(dists, c.dist)
+			names = append(names, c.name)
+		}
+	}
+	return taxids, dists, names
 }
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// name2taxidTestFlags are flags used in tests, which are reset before each run.
+var name2taxidTestFlags = []string{"data-dir", "out-file", "threads",
+	"show-rank", "show-lineage", "edit-distance", "prefer", "cache-size"}
+
+// runName2taxid runs "taxonkit name2taxid" with the arguments, and returns the output.
+func runName2taxid(t testing.TB, args ...string) string {
+	outFile := filepath.Join(t.TempDir(), "out.tsv")
+	args = append([]string{"--data-dir", filepath.Join("testdata", "name2taxid"), "-o", outFile}, args...)
+	runCommand(t, name2taxidCmd, name2taxidTestFlags, args...)
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestName2taxidCache checks that the output is the same with or without
+// the cache, where queries are repeated and some are evicted from a tiny cache.
+func TestName2taxidCache(t *testing.T) {
+	queries := filepath.Join("testdata", "name2taxid", "queries.txt")
+	want, err := os.ReadFile(filepath.Join("testdata", "name2taxid", "queries.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, 2, 1 << 18} {
+		got := runName2taxid(t, "-r", "--show-lineage", "--edit-distance", "1",
+			"--cache-size", strconv.Itoa(size), "-j", "4", queries)
+		if got != string(want) {
+			t.Errorf("cache size %d: got:\n%s\nwant:\n%s", size, got, want)
+		}
+	}
+}
+
+func BenchmarkName2taxidCache(b *testing.B) {
+	queries, err := os.ReadFile(filepath.Join("testdata", "name2taxid", "queries.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	file := filepath.Join(b.TempDir(), "queries.txt")
+	if err = os.WriteFile(file, []byte(strings.Repeat(string(queries), 10000)), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1 << 18} {
+		b.Run("cache-size="+strconv.Itoa(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runName2taxid(b, "--edit-distance", "1", "--prefer", "smallest-taxid",
+					"--cache-size", strconv.Itoa(size), file)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// cpuProfile is the file of CPU profile, nil if not profiling.
+var cpuProfile *os.File
+
+// memProfileFile is the path of heap profile, empty if not profiling.
+var memProfileFile string
+
+var onceStopProfiling sync.Once
+
+// startProfiling starts CPU profiling and records the path of heap profile
+// according to the global flags --cpuprofile and --memprofile.
+func startProfiling(cmd *cobra.Command) {
+	memProfileFile = getFlagString(cmd, "memprofile")
+
+	file := getFlagString(cmd, "cpuprofile")
+	if file == "" {
+		return
+	}
+
+	fh, err := os.Create(file)
+	if err != nil {
+		checkError(fmt.Errorf("fail to create CPU profile: %s", err))
+	}
+	if err = pprof.StartCPUProfile(fh); err != nil {
+		fh.Close()
+		checkError(fmt.Errorf("fail to start CPU profiling: %s", err))
+	}
+	cpuProfile = fh
+}
+
+// stopProfiling stops CPU profiling and writes heap profile. It's called
+// when the program exits, either normally or via checkError, and only runs once.
+func stopProfiling() {
+	onceStopProfiling.Do(func() {
+		if cpuProfile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuProfile.Close(); err != nil {
+				log.Errorf("fail to close CPU profile: %s", err)
+			}
+		}
+
+		if memProfileFile != "" {
+			fh, err := os.Create(memProfileFile)
+			if err != nil {
+				log.Errorf("fail to create memory profile: %s", err)
+				return
+			}
+			runtime.GC() // get up-to-date statistics
+			if err = pprof.WriteHeapProfile(fh); err != nil {
+				log.Errorf("fail to write memory profile: %s", err)
+			}
+			if err = fh.Close(); err != nil {
+				log.Errorf("fail to close memory profile: %s", err)
+			}
+		}
+	})
+}
@@ -29,7 +29,7 @@ import (
 	"sync"
 
 	"github.com/shenwei356/bio/taxdump"
-	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 	"github.com/twotwotwo/sorts"
@@ -95,7 +95,10 @@ Attention:
 			log.Infof("loading Taxonomy from: %s", config.DataDir)
 		}
 
-		taxdb, err = taxdump.NewTaxonomyWithRankFromNCBI(config.NodesFile)
+		err = withLocalDumpFile(config.NodesFile, func(file string) (err error) {
+			taxdb, err = taxdump.NewTaxonomyWithRankFromNCBI(file)
+			return err
+		})
 		if err != nil {
 			checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
 		}
@@ -111,7 +114,7 @@ Attention:
 
 		go func() {
 			defer wg.Done()
-			err = taxdb.LoadNamesFromNCBI(config.NamesFile)
+			err = withLocalDumpFile(config.NamesFile, taxdb.LoadNamesFromNCBI)
 			if err != nil {
 				checkError(fmt.Errorf("err on loading Taxonomy names: %s", err))
 			}
@@ -123,12 +126,12 @@ Attention:
 
 		go func() {
 			defer wg.Done()
-			existed, err = pathutil.Exists(config.DelNodesFile)
+			existed, err = taxonomy.Exists(config.DelNodesFile)
 			if err != nil {
 				checkError(fmt.Errorf("err on checking file delnodes.dmp: %s", err))
 			}
 			if existed {
-				err = taxdb.LoadDeletedNodesFromNCBI(config.DelNodesFile)
+				err = withLocalDumpFile(config.DelNodesFile, taxdb.LoadDeletedNodesFromNCBI)
 				if err != nil {
 					checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
 				}
@@ -140,12 +143,12 @@ Attention:
 
 		go func() {
 			defer wg.Done()
-			existed, err = pathutil.Exists(config.MergedFile)
+			existed, err = taxonomy.Exists(config.MergedFile)
 			if err != nil {
 				checkError(fmt.Errorf("err on checking file merged.dmp: %s", err))
 			}
 			if existed {
-				err = taxdb.LoadMergedNodesFromNCBI(config.MergedFile)
+				err = withLocalDumpFile(config.MergedFile, taxdb.LoadMergedNodesFromNCBI)
 				if err != nil {
 					checkError(fmt.Errorf("err on loading Taxonomy merged nodes: %s", err))
 				}
@@ -176,7 +179,6 @@ Attention:
 		n := maxField + 1
 		items := make([]string, n)
 		// var line string
-		var _taxid int
 		var taxid uint32
 		var abd float64
 		var sum float64
@@ -194,11 +196,11 @@ Attention:
 				continue
 			}
 
-			_taxid, err = strconv.Atoi(items[fieldTaxid])
+			taxid, err = taxonomy.ParseTaxId(items[fieldTaxid])
 			if err != nil {
+				checkTaxIdRange(err)
 				checkError(fmt.Errorf("failed to parse taxid: %s", items[fieldTaxid]))
 			}
-			taxid = uint32(_taxid)
 
 			abd, err = strconv.ParseFloat(items[fieldAbd], 64)
 			if err != nil {
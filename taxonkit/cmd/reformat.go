@@ -21,11 +21,15 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/shenwei356/breader"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -45,6 +49,14 @@ Input:
     Plese specify the lineage field with flag -i/--lineage-field (default 2).
     Or specify the TaxId field with flag -I/--taxid-field (default 0),
     which overrides -i/--lineage-field.
+    So the input type is decided by the flag: lineage strings (names
+    delimited by -d/--delimiter) with -i, or TaxIds with -I.
+    The field can be any column of a wider table, and all columns of
+    the input line are kept in the output, e.g.,
+
+      $ echo -e "read1\t9606\t0.95" | taxonkit reformat -I 2 -f "{g};{s}"
+      read1   9606    0.95    Homo;Homo sapiens
+
   - Supporting (gzipped) file or STDIN.
 
 Output:
@@ -52,6 +64,11 @@ Output:
   1. Input line data.
   2. Reformated lineage.
   3. (Optional) TaxIds taxons in the lineage (-t/--show-lineage-taxids)
+     They are aligned with the reformatted lineage position by position, and
+     TaxIds of missing ranks, including the ones filled by -F/--fill-miss-rank,
+     are replaced by -R/--miss-taxid-repl. For the pseudo strain (-S),
+     the TaxId of the node with the lowest rank is used.
+  4. (Optional) Clades in the lineage (--keep-clades)
   
 Ambiguous names:
 
@@ -59,6 +76,31 @@ Ambiguous names:
     by default. You can use the flag -a/--output-ambiguous-result to
     return one possible result
 
+Unresolvable lineages:
+
+  - For invalid, deleted, or not found TaxIds, and lineages which can't
+    be queried or are ambiguous, the behavior is set by --on-error:
+      none:  output the record as it is, i.e., with blank values
+             (-r/--miss-rank-repl) for deleted or not found TaxIds,
+             and empty fields for others (default)
+      fill:  output the record with blank values for all of them
+      skip:  do not output the record
+      abort: exit with an error
+    The number of these records is reported in the end for "fill" and "skip".
+
+Rank collisions:
+
+  - Occasionally, multiple nodes in a lineage have the same rank, e.g., two
+    nodes of "genus", while only one can be outputted for the rank. The
+    behavior is set by --on-rank-collision, where nodes are ordered from
+    the TaxId to the root:
+      first: keep the first one, i.e., the lowest node (default)
+      last:  keep the last one, i.e., the highest node
+      warn:  the same as "first", with a warning for each lineage
+      error: exit with an error
+    The number of collisions is reported in the end with --verbose.
+    {t} (subspecies/strain) is not checked, as it accepts both ranks.
+
 Output format can be formated by flag --format, available placeholders:
 
     {r}: realm
@@ -75,6 +117,9 @@ Output format can be formated by flag --format, available placeholders:
     {S}: subspecies
     {T}: strain
 
+Other text in the format, e.g., delimiters, is outputted as it is, while
+unknown placeholders like "{x}" and "{genus}" are reported as errors.
+
 When these're no nodes of rank "subspecies" nor "strain",
 you can switch on -S/--pseudo-strain to use the node with lowest rank
 as subspecies/strain name, if which rank is lower than "species". 
@@ -82,15 +127,97 @@ This flag affects {t}, {S}, {T}.
     
 Output format can contains some escape charactors like "\t".
 
+Custom ranks (--rank-file):
+
+  For taxonomies using ranks other than the placeholders above, e.g., viruses,
+  you can provide a file of ordered ranks, one rank per line. Blank lines
+  and lines starting with "#" are ignored. Each node of the lineage is
+  mapped onto these ranks by the rank in nodes.dmp, and the output lineage
+  is delimited by -d/--delimiter. Nodes with ranks not in the file are
+  dropped by default, or appended to the end with --append-unlisted-ranks.
+  -F/--fill-miss-rank, -T/--trim, -r/--miss-rank-repl, and -R/--miss-taxid-repl
+  still work, while -f/--format, -P/--add-prefix and -S/--pseudo-strain are ignored.
+  Prefixes can be added for ranks via --prefix-map.
+
+Clades (--keep-clades):
+
+  Nodes with rank of "no rank" or "clade" (e.g., "FCB group") are not in the
+  reformatted lineage, they can be kept in a dedicated column with
+  --keep-clades, joined by --clade-delimiter. Two modes (--clade-mode):
+    concat:  all clades in the lineage (default).
+    per-gap: clades are grouped by their positions relative to ranks of the
+             output format (or --rank-file), and groups are delimited by
+             -d/--delimiter. The N-th group contains clades higher than the
+             N-th rank and lower than the previous one, and an extra group
+             at the end contains clades lower than the last rank.
+  The column is placed right after the reformatted lineage by default, or at
+  the end of the line with --clade-position end.
+
+    $ echo 1236 | taxonkit reformat -I 1 -f "{k};{p};{c}" --keep-clades
+    1236    Bacteria;Pseudomonadota;Gammaproteobacteria     cellular organisms
+
+    $ echo 1236 | taxonkit reformat -I 1 -f "{k};{p};{c}" --keep-clades --clade-mode per-gap
+    1236    Bacteria;Pseudomonadota;Gammaproteobacteria     cellular organisms;;;
+
+Trailing missing ranks (--trim-trailing):
+
+  By default, every rank in the format (or --rank-file) has a field in the
+  output, and missing ranks are replaced by -r/--miss-rank-repl, filled by
+  -F/--fill-miss-rank, or left empty. With --trim-trailing, missing ranks
+  after the lowest rank found in the lineage are removed entirely, including
+  the delimiters (the text of the format between them), so lineages have
+  variable lengths. It takes precedence over -r/--miss-rank-repl,
+  -R/--miss-taxid-repl, -F/--fill-miss-rank, and -P/--add-prefix for these
+  trailing ranks, while missing ranks in the middle are still handled by them.
+  -T/--trim is switched on. TaxIds of -t/--show-lineage-taxids are trimmed in
+  the same way, and lineages of unresolvable records are empty.
+
+    $ echo 561 | taxonkit reformat -I 1 -r NA
+    561     Bacteria;Pseudomonadota;Gammaproteobacteria;Enterobacterales;Enterobacteriaceae;Escherichia;NA
+
+    $ echo 561 | taxonkit reformat -I 1 -r NA --trim-trailing
+    561     Bacteria;Pseudomonadota;Gammaproteobacteria;Enterobacterales;Enterobacteriaceae;Escherichia
+
+Prefixes of ranks:
+
+  Use -P/--add-prefix to add prefixes defined by --prefix-X for all ranks,
+  or set prefixes for some ranks via --prefix-map, e.g.,
+  --prefix-map "superkingdom=d__,phylum=p__" outputs GTDB-style prefixes.
+
+Reversed lineages (--reverse-order):
+
+  The reformatted lineage is outputted from the lowest rank to the highest one.
+  For -f/--format, placeholders are reversed along with the delimiters between
+  them, while texts before the first placeholder and after the last one are
+  kept in place. For --rank-file, ranks in the file are reversed, and unlisted
+  ranks of --append-unlisted-ranks are placed at the beginning. Prefixes of
+  -P/--add-prefix and --prefix-map stay with their taxa, and the reversal is
+  applied after --trim-trailing, so missing ranks are removed from the
+  beginning. -t/--show-lineage-taxids and clades of --keep-clades (or groups
+  of "--clade-mode per-gap") are reversed in the same way.
+
+    $ echo 562 | taxonkit reformat -I 1 -f "{k};{p};{g};{s}" -P --reverse-order -t
+    562     s__Escherichia coli;g__Escherichia;p__Pseudomonadota;k__Bacteria    562;561;1224;2
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
 
+		var err error
+
 		format := getFlagString(cmd, "format")
 		delimiter := getFlagString(cmd, "delimiter")
 		blank := getFlagString(cmd, "miss-rank-repl")
 		prefix := getFlagString(cmd, "miss-rank-repl-prefix")
 		suffix := getFlagString(cmd, "miss-rank-repl-suffix")
+		stripS := getFlagString(cmd, "miss-rank-repl-strip")
+		var reStrip *regexp.Regexp
+		if stripS != "" {
+			reStrip, err = regexp.Compile(stripS)
+			if err != nil {
+				checkError(fmt.Errorf("failed to compile regular expression of -x/--miss-rank-repl-strip: %s", stripS))
+			}
+		}
 
 		iblank := getFlagString(cmd, "miss-taxid-repl")
 		fill := getFlagBool(cmd, "fill-miss-rank")
@@ -131,6 +258,90 @@ Output format can contains some escape charactors like "\t".
 		prefixT := getFlagString(cmd, "prefix-T")
 
 		trim := getFlagBool(cmd, "trim")
+		trimTrailing := getFlagBool(cmd, "trim-trailing")
+		if trimTrailing {
+			trim = true
+		}
+		showMissCount := getFlagBool(cmd, "show-miss-count")
+		reverseOrder := getFlagBool(cmd, "reverse-order")
+
+		keepClades := getFlagBool(cmd, "keep-clades")
+		cladeDelimiter := getFlagString(cmd, "clade-delimiter")
+		var cladePerGap bool
+		switch cladeMode := getFlagString(cmd, "clade-mode"); cladeMode {
+		case "concat":
+		case "per-gap":
+			cladePerGap = true
+		default:
+			checkError(fmt.Errorf("invalid value of flag --clade-mode: %s. available: concat, per-gap", cladeMode))
+		}
+		var cladeAtEnd bool
+		switch cladePosition := getFlagString(cmd, "clade-position"); cladePosition {
+		case "lineage":
+		case "end":
+			cladeAtEnd = true
+		default:
+			checkError(fmt.Errorf("invalid value of flag --clade-position: %s. available: lineage, end", cladePosition))
+		}
+
+		onError := getFlagString(cmd, "on-error")
+		switch onError {
+		case "none", "skip", "fill", "abort":
+		default:
+			checkError(fmt.Errorf("invalid value of flag --on-error: %s. available: none, skip, fill, abort", onError))
+		}
+
+		onRankCollision := getFlagString(cmd, "on-rank-collision")
+		switch onRankCollision {
+		case "first", "last", "warn", "error":
+		default:
+			checkError(fmt.Errorf("invalid value of flag --on-rank-collision: %s. available: first, last, warn, error", onRankCollision))
+		}
+		keepHigherRank := onRankCollision == "last"
+		var nRankCollisions int64
+		// checkRankCollisions counts and reports rank collisions in the lineage of a TaxId
+		checkRankCollisions := func(n int, taxid uint32) error {
+			if n == 0 {
+				return nil
+			}
+			atomic.AddInt64(&nRankCollisions, int64(n))
+			switch onRankCollision {
+			case "warn":
+				log.Warningf("%d rank collision(s) found in the lineage of TaxId %d", n, taxid)
+			case "error":
+				return fmt.Errorf("rank collision found in the lineage of TaxId %d", taxid)
+			}
+			return nil
+		}
+
+		// rank -> prefix
+		prefixMap := make(map[string]string)
+		for _, item := range getFlagStringSlice(cmd, "prefix-map") {
+			if item == "" {
+				continue
+			}
+			i := strings.Index(item, "=")
+			if i <= 0 {
+				checkError(fmt.Errorf("invalid value of --prefix-map: %s, format: rank=prefix", item))
+			}
+			prefixMap[strings.ToLower(strings.TrimSpace(item[:i]))] = item[i+1:]
+		}
+
+		rankFile := getFlagString(cmd, "rank-file")
+		appendUnlisted := getFlagBool(cmd, "append-unlisted-ranks")
+		var customRanks []string
+		if rankFile != "" {
+			customRanks, err = readRankList(rankFile)
+			checkError(err)
+			if cmd.Flags().Lookup("format").Changed {
+				log.Warningf("flag -f/--format is ignored when --rank-file is given")
+			}
+			if addPrefix || pseudoStrain {
+				log.Warningf("flag -P/--add-prefix and -S/--pseudo-strain are ignored when --rank-file is given, please use --prefix-map for prefixes")
+			}
+		} else if appendUnlisted {
+			checkError(fmt.Errorf("flag --append-unlisted-ranks should be used along with --rank-file"))
+		}
 
 		prefixes := map[string]string{
 			"r": prefixR,
@@ -147,21 +358,22 @@ Output format can contains some escape charactors like "\t".
 			"T": prefixT,
 		}
 
-		// check format
-		if !reRankPlaceHolder.MatchString(format) {
-			checkError(fmt.Errorf("placeholder of simplified rank not found in output format: %s", format))
-		}
-		matches := reRankPlaceHolder.FindAllStringSubmatch(format, -1)
-		flag := false
-		for _, match := range matches {
-			if _, ok := symbol2rank[match[1]]; !ok {
-				checkError(fmt.Errorf("invalid placeholder: %s", match[0]))
-			}
-			switch match[1] {
-			case "t", "S", "T":
-				flag = true
+		if len(prefixMap) > 0 && customRanks == nil {
+			for rank, p := range prefixMap {
+				srank, ok := rank2symbol[rank]
+				if !ok {
+					checkError(fmt.Errorf("invalid rank in --prefix-map: %s, available: %s", rank, strings.Join(rankList, ", ")))
+				}
+				prefixes[srank] = p
 			}
+			addPrefix = true
 		}
+
+		// check format
+		placeholders, flag, err := parseFormat(format) // unique placeholders
+		checkError(err)
+		// locations of placeholders, for --trim-trailing
+		matchLocs := reRankPlaceHolder.FindAllStringSubmatchIndex(format, -1)
 		if flag {
 			// do not require this.
 			// if pseudoStrain && !fill {
@@ -172,6 +384,30 @@ Output format can contains some escape charactors like "\t".
 			log.Warningf(`flag -S/--pseudo-strain will not work because none of "{t}", "{S}", "{T}" is found in -f/--format`)
 		}
 
+		// ranks in the output -> index, for grouping clades by gaps
+		var outRank2idx map[string]int
+		var nOutRanks int
+		if keepClades {
+			if customRanks != nil {
+				nOutRanks = len(customRanks)
+				outRank2idx = make(map[string]int, nOutRanks)
+				for i, rank := range customRanks {
+					outRank2idx[rank] = i
+				}
+			} else {
+				nOutRanks = len(placeholders)
+				outRank2idx = make(map[string]int, nOutRanks)
+				for i, srank := range placeholders {
+					if srank == "t" {
+						outRank2idx["subspecies"] = i
+						outRank2idx["strain"] = i
+						continue
+					}
+					outRank2idx[symbol2rank[srank]] = i
+				}
+			}
+		}
+
 		files := getFileList(args)
 
 		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
@@ -191,7 +427,7 @@ Output format can contains some escape charactors like "\t".
 		var delnodes0 map[uint32]struct{}
 		var merged0 map[uint32]uint32
 
-		tree0, ranks0, names0, delnodes0, merged0 = loadData(config, true, true)
+		tree0, ranks0, names0, delnodes0, merged0 = loadData(config, true, true, false)
 
 		// for querying taxid from lineage
 		var name2parent2taxid map[string]map[string]uint32
@@ -208,6 +444,9 @@ Output format can contains some escape charactors like "\t".
 			line      string
 			flineage  string
 			iflineage string
+			failed    bool // the lineage can not be resolved
+			nMiss     int  // number of missing ranks, for --show-miss-count
+			clades    string
 		}
 
 		unescape := stringutil.UnEscaper()
@@ -223,6 +462,42 @@ Output format can contains some escape charactors like "\t".
 			iblankS = re.ReplaceAllString(iblankS, iblank)
 		}
 
+		// empty results
+		var cblankS, ciblankS string
+		if customRanks != nil {
+			tmp := make([]string, len(customRanks))
+			for i := range tmp {
+				tmp[i] = blank
+			}
+			cblankS = strings.Join(tmp, delimiter)
+			for i := range tmp {
+				tmp[i] = iblank
+			}
+			ciblankS = strings.Join(tmp, delimiter)
+		} else {
+			cblankS = unescape(blankS)
+			ciblankS = unescape(iblankS)
+		}
+
+		if trimTrailing { // all ranks are missing
+			cblankS, ciblankS = "", ""
+		}
+
+		var cladesBlank string
+		if cladePerGap {
+			cladesBlank = strings.Repeat(delimiter, nOutRanks)
+		}
+
+		// blank-filled record for deleted or not found TaxIds
+		failedRecord := func(line string) line2flineage {
+			return line2flineage{line, cblankS, ciblankS, true, 0, cladesBlank}
+		}
+		// record with empty fields for other unresolvable lineages,
+		// filled with blank values only with "--on-error fill"
+		emptyRecord := func(line string) line2flineage {
+			return line2flineage{line, "", "", true, 0, ""}
+		}
+
 		fn := func(line string) (interface{}, bool, error) {
 			if len(line) == 0 || line[0] == '#' {
 				return nil, false, nil
@@ -246,7 +521,6 @@ Output format can contains some escape charactors like "\t".
 			var ok bool
 
 			var taxid uint32
-			var taxidInt int
 
 			var names []string
 			var ranks []string
@@ -257,18 +531,17 @@ Output format can contains some escape charactors like "\t".
 
 			if parsingTaxId { // directly from field
 
-				taxidInt, err = strconv.Atoi(data[taxIdField])
-				if err != nil || taxidInt < 0 {
+				taxid, err = taxonomy.ParseTaxId(data[taxIdField])
+				if err != nil {
 					// checkError(fmt.Errorf("invalid TaxId: %s", data[taxIdField]))
 					log.Warningf("invalid TaxId: %s", data[taxIdField])
-					return line2flineage{line, "", ""}, true, nil
+					return emptyRecord(line), true, nil
 				}
-				taxid = uint32(taxidInt)
 
 			} else { // query taxid by taxon names
 
 				if strings.Trim(data[field], " ") == "" { // empty, returns empty result
-					return line2flineage{line, "", ""}, true, nil
+					return emptyRecord(line), true, nil
 				}
 
 				// names
@@ -286,7 +559,7 @@ Output format can contains some escape charactors like "\t".
 						log.Warningf(`failed to query the TaxId of: %s. Possible reasons: `, data[field])
 						log.Warningf(`  1) the lineage were produced with different taxonomy data files, please re-run taxonkit lineage;`)
 						log.Warningf(`  2) some taxon names contain delimiter (%s), please re-run taxonkit lineage and taxonkit reformat with different flag value of -d, e.g., -d "/"`, delimiter)
-						return line2flineage{line, "", ""}, true, nil
+						return emptyRecord(line), true, nil
 					}
 
 					if len(*_taxids) == 1 { // found
@@ -300,7 +573,7 @@ Output format can contains some escape charactors like "\t".
 							strings.Join(tmp, ", "), data[field])
 
 						if !outputAmbigous {
-							return line2flineage{line, "", ""}, true, nil
+							return emptyRecord(line), true, nil
 						}
 					}
 
@@ -324,7 +597,7 @@ Output format can contains some escape charactors like "\t".
 							log.Warningf(`failed to query the TaxId of: %s. Possible reasons: `, data[field])
 							log.Warningf(`  1) the lineage were produced with different taxonomy data files, please re-run taxonkit lineage;`)
 							log.Warningf(`  2) some taxon names contain delimiter (%s), please re-run taxonkit lineage and taxonkit reformat with different flag value of -d, e.g., -d "/"`, delimiter)
-							return line2flineage{line, "", ""}, true, nil
+							return emptyRecord(line), true, nil
 						}
 
 						if len(*_taxids) == 1 { // found
@@ -338,7 +611,7 @@ Output format can contains some escape charactors like "\t".
 								strings.Join(tmp, ", "), data[field])
 
 							if !outputAmbigous {
-								return line2flineage{line, "", ""}, true, nil
+								return emptyRecord(line), true, nil
 							}
 						}
 					} else {
@@ -354,7 +627,7 @@ Output format can contains some escape charactors like "\t".
 								strings.Join(tmp, ", "), data[field])
 
 							if !outputAmbigous {
-								return line2flineage{line, "", ""}, true, nil
+								return emptyRecord(line), true, nil
 							}
 						}
 					}
@@ -366,8 +639,29 @@ Output format can contains some escape charactors like "\t".
 
 			names, ranks, taxids, ok = queryNamesRanksTaxids(tree0, ranks0, names0, delnodes0, merged0, taxid)
 			if !ok { // taxid not found
-				// return line2flineage{line, "", ""}, true, nil
-				return line2flineage{line, unescape(blankS), unescape(iblankS)}, true, nil
+				return failedRecord(line), true, nil
+			}
+
+			var clades string
+			if keepClades {
+				clades = collectClades(names, ranks, outRank2idx, nOutRanks, cladePerGap, cladeDelimiter, delimiter, reverseOrder)
+			}
+
+			if customRanks != nil {
+				flineage, iflineage, nMiss, nCollisions := reformatWithCustomRanks(names, ranks, taxids, customRanks,
+					delimiter, blank, iblank, fill, prefix, suffix, reStrip, trim, trimTrailing, appendUnlisted, printLineageInTaxid, prefixMap, reverseOrder, keepHigherRank)
+				if err := checkRankCollisions(nCollisions, taxids[len(taxids)-1]); err != nil {
+					return nil, false, err
+				}
+
+				ranks = ranks[:0]
+				poolStringsN16.Put(ranks)
+				names = names[:0]
+				poolStringsN16.Put(names)
+				taxids = taxids[:0]
+				poolUint32N16.Put(taxids)
+
+				return line2flineage{line, flineage, iflineage, false, nMiss, clades}, true, nil
 			}
 
 			sranks := poolStringsN16.Get().([]string)
@@ -379,25 +673,34 @@ Output format can contains some escape charactors like "\t".
 
 			// preprare replacements.
 			// find the orphan names and missing ranks
-			replacements := make(map[string]string, len(matches))
+			replacements := make(map[string]string, len(placeholders))
 
 			var ireplacements map[string]string
 			if printLineageInTaxid {
-				ireplacements = make(map[string]string, len(matches))
+				ireplacements = make(map[string]string, len(placeholders))
 			}
 
-			for _, match := range matches {
-				replacements[match[1]] = blank
+			for _, p := range placeholders {
+				replacements[p] = blank
 				if printLineageInTaxid {
-					ireplacements[match[1]] = iblank
+					ireplacements[p] = iblank
 				}
 			}
 
+			var nCollisions int
 			for i, name := range names {
 				rank = ranks[i]
 				taxid = taxids[i]
 
 				if srank, ok = rank2symbol[rank]; ok {
+					if _, ok = srank2idx[srank]; ok { // rank collision, the lower node overwrites the higher one by default
+						nCollisions++
+						if keepHigherRank {
+							sranks = append(sranks, "")
+							continue
+						}
+					}
+
 					// special symbol "{t}"
 					switch rank {
 					case "strain":
@@ -429,6 +732,9 @@ Output format can contains some escape charactors like "\t".
 					sranks = append(sranks, "")
 				}
 			}
+			if err := checkRankCollisions(nCollisions, taxids[len(taxids)-1]); err != nil {
+				return nil, false, err
+			}
 
 			if fill {
 				var j, lastI int
@@ -459,16 +765,13 @@ Output format can contains some escape charactors like "\t".
 						}
 					}
 
-					if suffix == "rank" {
-						replacements[srank] = prefix + names[lastI] + " " + symbol2rank[srank]
-						// replacements[srank] = fmt.Sprintf("%s%s %s", prefix, names[lastI], symbol2rank[srank])
-					} else {
-						replacements[srank] = prefix + names[lastI] + suffix
-					}
+					replacements[srank] = fillMissingRank(names[lastI], symbol2rank[srank], prefix, suffix, reStrip)
 				}
 			}
 
+			var pseudoSranks map[string]interface{} // ranks substituted by -S/--pseudo-strain
 			if pseudoStrain {
+				pseudoSranks = make(map[string]interface{}, 3)
 				_, hasRankSubspecies := srank2idx["S"]
 				_, hasRankStrain := srank2idx["T"]
 
@@ -504,16 +807,56 @@ Output format can contains some escape charactors like "\t".
 						!(hasRankSubspecies || hasRankStrain) && // does not have strain or subspecies
 						lastI < len(names)-1 { // not itself
 						replacements[srank] = names[len(names)-1]
+						if printLineageInTaxid { // keep TaxIds aligned with names
+							ireplacements[srank] = strconv.Itoa(int(taxids[len(taxids)-1]))
+						}
+						pseudoSranks[srank] = struct{}{}
 						continue
 					}
 				}
 			}
 
+			// count missing ranks in the format, trimmed ones are not counted
+			var nMiss int
+			if showMissCount {
+				for _, srank = range placeholders {
+					if _, ok = srank2idx[srank]; ok {
+						continue
+					}
+					if trim && symbol2weight[srank] > maxRankWeight {
+						continue
+					}
+					nMiss++
+				}
+			}
+
 			flineage := format
+			if trimTrailing { // cut the format after the last rank found
+				var cut int
+				var srankFound bool
+				for j, loc := range matchLocs {
+					srank = format[loc[2]:loc[3]]
+					if _, srankFound = srank2idx[srank]; !srankFound {
+						_, srankFound = pseudoSranks[srank]
+					}
+					if !srankFound {
+						continue
+					}
+					if j == len(matchLocs)-1 { // keep the text after the last placeholder
+						cut = len(format)
+					} else {
+						cut = loc[1]
+					}
+				}
+				flineage = format[:cut]
+			}
+			if reverseOrder {
+				flineage = reverseFormat(flineage, matchLocs)
+			}
 			var iflineage string
 
 			if printLineageInTaxid {
-				iflineage = format
+				iflineage = flineage
 			}
 
 			for srank, re := range reRankPlaceHolders {
@@ -543,11 +886,21 @@ Output format can contains some escape charactors like "\t".
 			taxids = taxids[:0]
 			poolUint32N16.Put(taxids)
 
-			return line2flineage{line, unescape(flineage), unescape(iflineage)}, true, nil
+			return line2flineage{line, unescape(flineage), unescape(iflineage), false, nMiss, clades}, true, nil
 		}
 
+		// records are processed by config.Threads goroutines in chunks, and
+		// breader returns chunks in the input order. With --line-buffered,
+		// lines are processed one by one, so outputs of streaming input
+		// are not held until a chunk is full.
+		chunkSize := 64
+		if config.LineBuffered {
+			chunkSize = 1
+		}
+
+		var nFailed int
 		for _, file := range files {
-			reader, err := breader.NewBufferedReader(file, config.Threads, 64, fn)
+			reader, err := breader.NewBufferedReader(file, config.Threads, chunkSize, fn)
 			checkError(err)
 
 			var l2s line2flineage
@@ -558,17 +911,55 @@ Output format can contains some escape charactors like "\t".
 				for _, data = range chunk.Data {
 					l2s = data.(line2flineage)
 
+					if l2s.failed {
+						nFailed++
+						switch onError {
+						case "skip":
+							continue
+						case "fill":
+							l2s.flineage, l2s.iflineage, l2s.clades = cblankS, ciblankS, cladesBlank
+						case "abort":
+							checkError(fmt.Errorf("failed to reformat the lineage of line: %s", l2s.line))
+						}
+					}
+
+					outfh.WriteString(l2s.line + "\t" + l2s.flineage)
+					if keepClades && !cladeAtEnd {
+						outfh.WriteString("\t" + l2s.clades)
+					}
 					if printLineageInTaxid {
-						outfh.WriteString(l2s.line + "\t" + l2s.flineage + "\t" + l2s.iflineage + "\n")
-					} else {
-						outfh.WriteString(l2s.line + "\t" + l2s.flineage + "\n")
+						outfh.WriteString("\t" + l2s.iflineage)
+					}
+					if showMissCount {
+						if l2s.failed {
+							outfh.WriteString("\t")
+						} else {
+							outfh.WriteString("\t" + strconv.Itoa(l2s.nMiss))
+						}
+					}
+					if keepClades && cladeAtEnd {
+						outfh.WriteString("\t" + l2s.clades)
 					}
+					outfh.WriteString("\n")
 					if config.LineBuffered {
 						outfh.Flush()
 					}
 				}
 			}
 		}
+
+		if config.Verbose {
+			log.Infof("%d rank collisions found in lineages", nRankCollisions)
+		}
+
+		if nFailed > 0 {
+			switch onError {
+			case "skip":
+				log.Warningf("%d records with unresolvable lineages were skipped", nFailed)
+			case "fill":
+				log.Warningf("%d records with unresolvable lineages were filled with blank values", nFailed)
+			}
+		}
 	},
 }
 
@@ -576,10 +967,11 @@ func init() {
 	RootCmd.AddCommand(flineageCmd)
 
 	flineageCmd.Flags().StringP("format", "f", "{k};{p};{c};{o};{f};{g};{s}", "output format, placeholders of rank are needed")
-	flineageCmd.Flags().StringP("delimiter", "d", ";", "field delimiter in input lineage")
+	flineageCmd.Flags().StringP("delimiter", "d", ";", "field delimiter in input lineage, also used as the delimiter of output lineage when --rank-file is given")
 	flineageCmd.Flags().StringP("miss-rank-repl", "r", "", `replacement string for missing rank`)
 	flineageCmd.Flags().StringP("miss-rank-repl-prefix", "p", "unclassified ", `prefix for estimated taxon names`)
 	flineageCmd.Flags().StringP("miss-rank-repl-suffix", "s", "rank", `suffix for estimated taxon names. "rank" for rank name, "" for no suffix`)
+	flineageCmd.Flags().StringP("miss-rank-repl-strip", "x", "", `regular expression of patterns to remove from the name of the higher rank before filling missing rank, e.g., "^unclassified " to avoid repeated prefixes`)
 	flineageCmd.Flags().StringP("miss-taxid-repl", "R", "", `replacement string for missing taxid`)
 
 	flineageCmd.Flags().BoolP("fill-miss-rank", "F", false, "fill missing rank with lineage information of the next higher rank")
@@ -589,6 +981,13 @@ func init() {
 	flineageCmd.Flags().IntP("taxid-field", "I", 0, "field index of taxid. input data should be tab-separated. it overrides -i/--lineage-field")
 	flineageCmd.Flags().BoolP("show-lineage-taxids", "t", false, `show corresponding taxids of reformated lineage`)
 	flineageCmd.Flags().BoolP("output-ambiguous-result", "a", false, `output one of the ambigous result`)
+	flineageCmd.Flags().BoolP("show-miss-count", "", false, `append a column of the number of ranks in the format (or --rank-file) missing in the lineage, i.e., being filled (-F/--fill-miss-rank), replaced (-r/--miss-rank-repl), or substituted (-S/--pseudo-strain). ranks removed by -T/--trim are not counted. it's empty for unresolvable records`)
+	flineageCmd.Flags().BoolP("keep-clades", "", false, `output nodes with rank of "no rank" or "clade" in a dedicated column. type "taxonkit reformat --help" for details`)
+	flineageCmd.Flags().StringP("clade-delimiter", "", ",", `delimiter for joining clades, used along with --keep-clades`)
+	flineageCmd.Flags().StringP("clade-mode", "", "concat", `how to output clades: "concat" for all in one group, "per-gap" for groups of clades between ranks, delimited by -d/--delimiter`)
+	flineageCmd.Flags().StringP("clade-position", "", "lineage", `position of the clade column: "lineage" for right after the reformatted lineage, "end" for the end of the line`)
+	flineageCmd.Flags().StringP("on-rank-collision", "", "first", `how to handle multiple nodes of the same rank in a lineage: "first" for keeping the lowest one, "last" for keeping the highest one, "warn" for "first" with a warning, "error" for exiting with an error`)
+	flineageCmd.Flags().StringP("on-error", "", "none", `how to handle records of which the lineages can not be resolved: "none" for outputting them as they are, "fill" for outputting blank values, "skip" for not outputting, "abort" for exiting with an error. type "taxonkit reformat --help" for details`)
 
 	flineageCmd.Flags().BoolP("add-prefix", "P", false, `add prefixes for all ranks, single prefix for a rank is defined by flag --prefix-X`)
 	flineageCmd.Flags().StringP("prefix-r", "", "r__", `prefix for realm, used along with flag -P/--add-prefix`)
@@ -604,5 +1003,235 @@ func init() {
 	flineageCmd.Flags().StringP("prefix-S", "", "S__", `prefix for subspecies, used along with flag -P/--add-prefix`)
 	flineageCmd.Flags().StringP("prefix-T", "", "T__", `prefix for strain, used along with flag -P/--add-prefix`)
 
+	flineageCmd.Flags().StringSliceP("prefix-map", "", []string{}, `prefixes for ranks in format of "rank=prefix", overriding --prefix-X and switching on -P/--add-prefix, also works for --rank-file. multiple values can be separated with comma (e.g., --prefix-map "superkingdom=d__,phylum=p__") or give multiple times`)
 	flineageCmd.Flags().BoolP("trim", "T", false, "do not fill or add prefix for missing rank lower than current rank")
+	flineageCmd.Flags().BoolP("trim-trailing", "", false, `remove missing ranks after the lowest rank found entirely, including the delimiters, for variable-length lineages. it switches on -T/--trim. type "taxonkit reformat --help" for details`)
+
+	flineageCmd.Flags().BoolP("reverse-order", "", false, `output lineages from the lowest rank to the highest one, e.g., species to superkingdom, also for -t/--show-lineage-taxids and --keep-clades. type "taxonkit reformat --help" for details`)
+	flineageCmd.Flags().StringP("rank-file", "", "", `file of ordered ranks to output, one rank per line, it overrides -f/--format. type "taxonkit reformat --help" for details`)
+	flineageCmd.Flags().BoolP("append-unlisted-ranks", "", false, `append taxa with ranks not in --rank-file to the end of the output lineage, instead of dropping them`)
+}
+
+// collectClades returns nodes with rank of "no rank" or "clade" in a lineage.
+// With perGap, clades are grouped by the next lower ranks in outRank2idx,
+// and an extra group is for clades lower than all these ranks.
+// With reverse, clades (or groups) are ordered from the bottom to the top.
+func collectClades(names, ranks []string, outRank2idx map[string]int, nOutRanks int,
+	perGap bool, cladeDelimiter string, delimiter string, reverse bool) string {
+	if !perGap {
+		clades := make([]string, 0, 4)
+		for j, rank := range ranks {
+			if _, ok := outRank2idx[rank]; ok {
+				continue
+			}
+			if rank == norank || rank == "clade" {
+				clades = append(clades, names[j])
+			}
+		}
+		if reverse {
+			stringutil.ReverseStringSliceInplace(clades)
+		}
+		return strings.Join(clades, cladeDelimiter)
+	}
+
+	groups := make([][]string, nOutRanks+1)
+	pending := make([]string, 0, 4)
+	var i int
+	var ok bool
+	for j, rank := range ranks {
+		if i, ok = outRank2idx[rank]; ok {
+			groups[i] = append(groups[i], pending...)
+			pending = pending[:0]
+			continue
+		}
+		if rank == norank || rank == "clade" {
+			pending = append(pending, names[j])
+		}
+	}
+	groups[nOutRanks] = pending
+
+	fields := make([]string, nOutRanks+1)
+	for i, group := range groups {
+		if reverse {
+			stringutil.ReverseStringSliceInplace(group)
+		}
+		fields[i] = strings.Join(group, cladeDelimiter)
+	}
+	if reverse {
+		stringutil.ReverseStringSliceInplace(fields)
+	}
+	return strings.Join(fields, delimiter)
+}
+
+// reverseFormat reverses the order of placeholders in a format, along with the
+// delimiters between them, while texts before the first placeholder and after
+// the last one are kept in place. locs are locations of placeholders in the
+// complete format, of which the ones beyond the (trimmed) format are ignored.
+func reverseFormat(format string, locs [][]int) string {
+	n := len(locs)
+	for n > 0 && locs[n-1][1] > len(format) {
+		n--
+	}
+	if n < 2 {
+		return format
+	}
+
+	var b strings.Builder
+	b.WriteString(format[:locs[0][0]])
+	for i := n - 1; i >= 0; i-- {
+		b.WriteString(format[locs[i][0]:locs[i][1]])
+		if i > 0 {
+			b.WriteString(format[locs[i-1][1]:locs[i][0]])
+		}
+	}
+	b.WriteString(format[locs[n-1][1]:])
+	return b.String()
+}
+
+// readRankList reads a list of ranks from a file, one rank per line.
+// Blank lines and lines starting with "#" are ignored.
+func readRankList(file string) ([]string, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, fmt.Errorf("read rank list from '%s': %s", file, err)
+	}
+	defer fh.Close()
+
+	ranks := make([]string, 0, 32)
+	seen := make(map[string]interface{}, 32)
+	scanner := bufio.NewScanner(fh)
+	var rank string
+	for scanner.Scan() {
+		rank = strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if rank == "" || rank[0] == '#' {
+			continue
+		}
+		if _, ok := seen[rank]; ok {
+			return nil, fmt.Errorf("duplicated rank in '%s': %s", file, rank)
+		}
+		seen[rank] = struct{}{}
+		ranks = append(ranks, rank)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read rank list from '%s': %s", file, err)
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("no ranks found in file: %s", file)
+	}
+	return ranks, nil
+}
+
+// fillMissingRank returns the value of a missing rank, filled with the name of
+// the nearest higher rank, from which patterns of reStrip are removed first to
+// avoid repeated prefixes or suffixes. A suffix of "rank" means the missing rank.
+func fillMissingRank(name string, rank string, prefix string, suffix string, reStrip *regexp.Regexp) string {
+	if reStrip != nil {
+		name = strings.TrimSpace(reStrip.ReplaceAllString(name, ""))
+	}
+	if suffix == "rank" {
+		return prefix + name + " " + rank
+	}
+	return prefix + name + suffix
+}
+
+// reformatWithCustomRanks maps the nodes of a lineage onto the given ordered ranks.
+// It returns the reformatted lineage, the corresponding TaxIds, and the number
+// of missing ranks (not counting the trimmed ones). With trimTrailing, fields
+// after the lowest rank found are removed. With reverse, fields are ordered
+// from the lowest rank to the highest one. Among nodes of the same rank, the
+// lowest one is kept, or the highest one with keepHigherRank, and the number
+// of these rank collisions is also returned.
+func reformatWithCustomRanks(names, ranks []string, taxids []uint32, customRanks []string,
+	delimiter, blank, iblank string, fill bool, prefix, suffix string, reStrip *regexp.Regexp,
+	trim bool, trimTrailing bool, appendUnlisted bool, printLineageInTaxid bool, prefixMap map[string]string,
+	reverse bool, keepHigherRank bool) (string, string, int, int) {
+
+	n := len(customRanks)
+	rank2idx := make(map[string]int, n)
+	for i, rank := range customRanks {
+		rank2idx[rank] = i
+	}
+
+	fields := make([]string, n)
+	ifields := make([]string, n)
+	found := make([]bool, n)
+	unlisted := make([]int, 0, 8) // index of nodes with unlisted ranks
+	maxIdx := -1
+
+	var i int
+	var ok bool
+	var nCollisions int
+	for j, rank := range ranks {
+		if i, ok = rank2idx[rank]; !ok {
+			unlisted = append(unlisted, j)
+			continue
+		}
+		if found[i] { // rank collision
+			nCollisions++
+			if keepHigherRank {
+				continue
+			}
+		}
+		fields[i] = names[j]
+		ifields[i] = strconv.Itoa(int(taxids[j]))
+		found[i] = true
+		if i > maxIdx {
+			maxIdx = i
+		}
+	}
+
+	var nMiss int
+	lastI := -1 // the nearest higher rank found
+	for i = 0; i < n; i++ {
+		if found[i] {
+			lastI = i
+			continue
+		}
+		ifields[i] = iblank
+		fields[i] = blank
+
+		if !(trim && i > maxIdx) {
+			nMiss++
+		}
+
+		if !fill || lastI < 0 || (trim && i > maxIdx) {
+			continue
+		}
+
+		fields[i] = fillMissingRank(fields[lastI], customRanks[i], prefix, suffix, reStrip)
+	}
+
+	if len(prefixMap) > 0 {
+		var p string
+		for i = 0; i < n; i++ {
+			if trim && fields[i] == "" {
+				continue
+			}
+			if p, ok = prefixMap[customRanks[i]]; ok {
+				fields[i] = p + fields[i]
+			}
+		}
+	}
+
+	if trimTrailing {
+		fields = fields[:maxIdx+1]
+		ifields = ifields[:maxIdx+1]
+	}
+
+	if appendUnlisted {
+		for _, j := range unlisted {
+			fields = append(fields, prefixMap[ranks[j]]+names[j])
+			ifields = append(ifields, strconv.Itoa(int(taxids[j])))
+		}
+	}
+
+	if reverse {
+		stringutil.ReverseStringSliceInplace(fields)
+		stringutil.ReverseStringSliceInplace(ifields)
+	}
+
+	if !printLineageInTaxid {
+		return strings.Join(fields, delimiter), "", nMiss, nCollisions
+	}
+	return strings.Join(fields, delimiter), strings.Join(ifields, delimiter), nMiss, nCollisions
 }
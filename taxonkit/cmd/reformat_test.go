@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// reformatTestFlags are flags used in tests, which are reset before each run.
+var reformatTestFlags = []string{"data-dir", "out-file", "threads", "quiet", "line-buffered",
+	"taxid-field", "fill-miss-rank"}
+
+// reformatTestTaxIds are all TaxIds in testdata/name2taxid.
+const reformatTestTaxIds = "1\n2\n2759\n1239\n1386\n1423\n1224\n561\n562\n6656\n55087\n"
+
+// runReformat runs "taxonkit reformat" on TaxIds in the file, and returns the output.
+func runReformat(t testing.TB, file string, args ...string) string {
+	outFile := filepath.Join(t.TempDir(), "out.tsv")
+	args = append([]string{"--data-dir", filepath.Join("testdata", "name2taxid"), "-o", outFile,
+		"--quiet", "-I", "1", "-F", file}, args...)
+	runCommand(t, flineageCmd, reformatTestFlags, args...)
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// writeReformatTestTaxIds writes n copies of reformatTestTaxIds to a file.
+func writeReformatTestTaxIds(t testing.TB, n int) string {
+	file := filepath.Join(t.TempDir(), "taxids.txt")
+	if err := os.WriteFile(file, []byte(strings.Repeat(reformatTestTaxIds, n)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// TestReformatThreads checks that the output order is the same as the input,
+// with multiple threads or processing lines one by one with --line-buffered.
+func TestReformatThreads(t *testing.T) {
+	file := writeReformatTestTaxIds(t, 100)
+	want := runReformat(t, file, "-j", "1")
+	if n := strings.Count(want, "\n"); n != 1100 {
+		t.Fatalf("got %d lines, want 1100", n)
+	}
+	for i, line := range strings.Split(strings.TrimSuffix(want, "\n"), "\n") {
+		taxid := strings.Split(reformatTestTaxIds, "\n")[i%11]
+		if !strings.HasPrefix(line, taxid+"\t") {
+			t.Fatalf("line %d: got %q, want TaxId %s", i+1, line, taxid)
+		}
+	}
+
+	for _, args := range [][]string{{"-j", "4"}, {"-j", "1", "--line-buffered"}, {"-j", "4", "--line-buffered"}} {
+		if got := runReformat(t, file, args...); got != want {
+			t.Errorf("%s: the output differs from that of -j 1", strings.Join(args, " "))
+		}
+	}
+}
+
+// BenchmarkReformat compares the performance of different numbers of threads,
+// where records are processed in chunks of 64 lines by default, or
+// one by one with --line-buffered.
+func BenchmarkReformat(b *testing.B) {
+	file := writeReformatTestTaxIds(b, 10000)
+
+	for _, threads := range []int{1, 4} {
+		for _, lineBuffered := range []bool{false, true} {
+			args := []string{"-j", strconv.Itoa(threads)}
+			name := "threads=" + strconv.Itoa(threads)
+			if lineBuffered {
+				args = append(args, "--line-buffered")
+				name += "/line-buffered"
+			}
+			b.Run(name, func(b *testing.B) {
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0)) // changed by -j/--threads
+				for i := 0; i < b.N; i++ {
+					runReformat(b, file, args...)
+				}
+			})
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		format       string
+		placeholders []string
+		hasStrain    bool
+		err          bool
+	}{
+		{"{k};{p};{c};{o};{f};{g};{s}", []string{"k", "p", "c", "o", "f", "g", "s"}, false, false},
+		{"{r};{K};{s};{t};{S};{T}", []string{"r", "K", "s", "t", "S", "T"}, true, false},
+		{"{s}", []string{"s"}, false, false},
+		{"{g} {s}", []string{"g", "s"}, false, false},
+		{"k__{k}|p__{p}|g: {g} (sp. {s})", []string{"k", "p", "g", "s"}, false, false},
+		{"{g};{s};{g}", []string{"g", "s"}, false, false}, // repeated placeholders
+		{"{s}{s}", []string{"s"}, false, false},
+		{"{{s}}", []string{"s"}, false, false},
+		{"{g}{}{s}", nil, false, true},
+		{"{genus};{s}", nil, false, true},
+		{"{g};{species}", nil, false, true},
+		{"{x}", nil, false, true},
+		{"{k};{x}", nil, false, true},
+		{"{1}", nil, false, true},
+		{"", nil, false, true}, // no placeholders
+		{"k;p;c", nil, false, true},
+		{"{ s }", nil, false, true},
+	}
+	for _, test := range tests {
+		placeholders, hasStrain, err := parseFormat(test.format)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: an error is expected", test.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(placeholders, test.placeholders) || hasStrain != test.hasStrain {
+			t.Errorf("%q: got %v, %v, want %v, %v", test.format, placeholders, hasStrain, test.placeholders, test.hasStrain)
+		}
+	}
+}
+
+func TestFillMissingRank(t *testing.T) {
+	reStrip := regexp.MustCompile(`^unclassified `)
+
+	tests := []struct {
+		name    string
+		rank    string
+		prefix  string
+		suffix  string
+		reStrip *regexp.Regexp
+		want    string
+	}{
+		{"Enterobacterales", "family", "unclassified ", "rank", nil, "unclassified Enterobacterales family"},
+		{"Enterobacterales", "family", "unclassified ", "", nil, "unclassified Enterobacterales"},
+		{"Enterobacterales", "family", "", " incertae sedis", nil, "Enterobacterales incertae sedis"},
+		{"unclassified Enterobacterales", "family", "unclassified ", "", nil, "unclassified unclassified Enterobacterales"},
+		{"unclassified Enterobacterales", "family", "unclassified ", "", reStrip, "unclassified Enterobacterales"},
+		{"unclassified Enterobacterales", "family", "unclassified ", "rank", reStrip, "unclassified Enterobacterales family"},
+		{"Enterobacterales", "family", "unclassified ", "", reStrip, "unclassified Enterobacterales"},
+		{"uncultured unclassified bacterium", "genus", "unclassified ", "", reStrip, "unclassified uncultured unclassified bacterium"},
+		{"Enterobacterales sp. ", "genus", "unclassified ", "", regexp.MustCompile(` sp\.`), "unclassified Enterobacterales"},
+	}
+	for _, test := range tests {
+		got := fillMissingRank(test.name, test.rank, test.prefix, test.suffix, test.reStrip)
+		if got != test.want {
+			t.Errorf("%q (%s, prefix: %q, suffix: %q, strip: %v): got %q, want %q",
+				test.name, test.rank, test.prefix, test.suffix, test.reStrip, got, test.want)
+		}
+	}
+}
+
+// TestReformatFillMissingRanks checks filled values of a lineage missing family and genus,
+// where the order is also an unclassified one.
+func TestReformatFillMissingRanks(t *testing.T) {
+	names := []string{"root", "Bacteria", "Pseudomonadota", "Gammaproteobacteria", "unclassified Enterobacterales", "Escherichia coli"}
+	ranks := []string{"no rank", "superkingdom", "phylum", "class", "order", "species"}
+	taxids := []uint32{1, 2, 1224, 1236, 91347, 562}
+	customRanks := []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species"}
+
+	tests := []struct {
+		prefix  string
+		suffix  string
+		reStrip *regexp.Regexp
+		want    string
+	}{
+		{"unclassified ", "", nil,
+			"Bacteria;Pseudomonadota;Gammaproteobacteria;unclassified Enterobacterales;" +
+				"unclassified unclassified Enterobacterales;unclassified unclassified Enterobacterales;Escherichia coli"},
+		{"unclassified ", "", regexp.MustCompile(`^unclassified `),
+			"Bacteria;Pseudomonadota;Gammaproteobacteria;unclassified Enterobacterales;" +
+				"unclassified Enterobacterales;unclassified Enterobacterales;Escherichia coli"},
+		{"unclassified ", "rank", regexp.MustCompile(`^unclassified `),
+			"Bacteria;Pseudomonadota;Gammaproteobacteria;unclassified Enterobacterales;" +
+				"unclassified Enterobacterales family;unclassified Enterobacterales genus;Escherichia coli"},
+	}
+	for _, test := range tests {
+		got, igot, nMiss, _ := reformatWithCustomRanks(names, ranks, taxids, customRanks,
+			";", "", "", true, test.prefix, test.suffix, test.reStrip,
+			false, false, false, true, nil, false, false)
+		if got != test.want {
+			t.Errorf("prefix: %q, suffix: %q, strip: %v: got:\n%s\nwant:\n%s",
+				test.prefix, test.suffix, test.reStrip, got, test.want)
+		}
+		if want := "2;1224;1236;91347;;;562"; igot != want {
+			t.Errorf("got TaxIds %s, want %s", igot, want)
+		}
+		if nMiss != 2 {
+			t.Errorf("got %d missing ranks, want 2", nMiss)
+		}
+	}
+}
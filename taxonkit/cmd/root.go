@@ -35,15 +35,22 @@ var RootCmd = &cobra.Command{
 	Use:   "taxonkit",
 	Short: "NCBI Taxonomy Toolkit",
 	Long:  "",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		startProfiling(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
+	err := RootCmd.Execute()
+	stopProfiling()
+	if err != nil {
+		discardAtomicOutput()
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(-1)
 	}
+	finishAtomicOutput()
 }
 
 // defaulDataDir is the directory of TaxonKit
@@ -75,8 +82,23 @@ Dataset:
     or some other directory, and later you can refer to using flag --data-dir,
     or environment variable TAXONKIT_DB.
 
-    When environment variable TAXONKIT_DB is set, explicitly setting --data-dir will
-    overide the value of TAXONKIT_DB.
+    These files can also be compressed with gzip (.gz), zstd (.zst), or xz (.xz),
+    e.g., "nodes.dmp.zst", the plain files are preferred if both exist.
+
+    The archive "taxdump.tar.gz" (or .tgz, .tar, .tar.zst, .tar.xz) can also be
+    used as the data directory directly, e.g., --data-dir taxdump.tar.gz. Only
+    needed files are extracted into memory, which is slower than using plain files,
+    and the binary index (taxonkit index) is not used. The command index does
+    not support archives.
+
+    TaxIds are stored as 32-bit unsigned integers, so the largest supported
+    TaxId is 4294967295. Larger TaxIds in dump files are reported as errors,
+    and the ones in input data are treated as invalid values.
+
+    The data directory is decided in this order:
+      1. the flag --data-dir, if explicitly given
+      2. the environment variable TAXONKIT_DB
+      3. the default directory above
 
 `, VERSION, defaulDataDir)
 
@@ -87,8 +109,15 @@ Dataset:
 
 	RootCmd.PersistentFlags().IntP("threads", "j", defaultThreads, "number of CPUs. 4 is enough")
 	RootCmd.PersistentFlags().StringP("out-file", "o", "-", `out file ("-" for stdout, suffix .gz for gzipped out)`)
-	RootCmd.PersistentFlags().StringP("data-dir", "", defaulDataDir, "directory containing nodes.dmp and names.dmp")
+	RootCmd.PersistentFlags().StringP("data-dir", "", defaulDataDir, "directory containing nodes.dmp and names.dmp, or the archive taxdump.tar.gz (not supported by index)")
 	RootCmd.PersistentFlags().BoolP("verbose", "", false, "print verbose information")
+	RootCmd.PersistentFlags().BoolP("atomic", "", false, `write -o/--out-file to a temporary file in the same directory first, which is renamed to the output file only if the command finishes successfully, otherwise the existing output file is kept unchanged. no effect for stdout`)
+	RootCmd.PersistentFlags().BoolP("quiet", "", false, "do not print warnings (e.g., merged, deleted, or not found TaxIds), errors are still printed")
+	RootCmd.PersistentFlags().BoolP("log-json", "", false, `output logs in JSON Lines format to stderr, with fields "time", "level", "message", and "code" and "taxids" for merged, deleted, and not found TaxIds`)
+	RootCmd.PersistentFlags().StringP("log-file", "", "", `append logs to this file instead of stderr. errors in parsing command-line arguments are still written to stderr, and errors causing exiting are written to both`)
+	RootCmd.PersistentFlags().StringP("cpuprofile", "", "", `write CPU profile to this file, for performance investigations with "go tool pprof"`)
+	RootCmd.PersistentFlags().StringP("memprofile", "", "", `write memory (heap) profile to this file before exiting, for performance investigations with "go tool pprof"`)
+	RootCmd.PersistentFlags().IntP("map-size-hint", "", 0, `initial size of maps for TaxIds, e.g., the number of nodes in nodes.dmp, to avoid rehashing when loading big taxonomy data. 0 for estimating it from the size of nodes.dmp`)
 	RootCmd.PersistentFlags().BoolP("line-buffered", "", false, "use line buffering on output, i.e., immediately writing to stdin/file for every line of output")
 
 	RootCmd.CompletionOptions.DisableDefaultCmd = true
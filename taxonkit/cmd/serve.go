@@ -0,0 +1,426 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start an HTTP server for taxonomy queries",
+	Long: `Start an HTTP server for taxonomy queries
+
+Taxonomy data are loaded once and kept in memory, which avoids repeated
+loading for frequent queries.
+
+Endpoints (GET, all responses are in JSON format):
+
+  /lineage/{taxid}      lineage of a TaxId, in the same format of "lineage -J"
+  /list/{taxid}         subtree of a TaxId, as nested objects with "children".
+                        query parameter "max-depth" limits the depth (default 1,
+                        -1 for no limit), e.g., /list/9605?max-depth=2.
+                        Subtrees with more than --max-nodes nodes are refused.
+  /name2taxid/{name}    TaxIds of a name (case-insensitive, all name classes)
+  /lca?ids=1,2,3        lowest common ancestor of TaxIds, separated by comma
+
+HTTP status codes:
+
+  200  OK
+  301  the TaxId was merged, the new TaxId is given in "merged_into" of the
+       body, and the "Location" header points to the URL of the new TaxId.
+       For /lca, merged TaxIds are replaced by new ones and listed in "merged".
+  400  invalid TaxIds or parameters
+  404  TaxIds or names not found
+  410  the TaxId was deleted
+  413  too many nodes in the subtree for /list, please use a smaller max-depth
+
+  Errors are returned as {"error": "message"}, along with "taxid" and
+  "merged_into" for deleted or merged TaxIds.
+
+Examples:
+
+    $ taxonkit serve --port 8080 &
+
+    $ curl -s localhost:8080/lineage/9606
+    {"query":"9606","taxid":9606,"ranks":{"superkingdom":{"taxid":2759,"name":"Eukaryota"},...
+
+    $ curl -s localhost:8080/lca?ids=9606,562
+    {"ids":[9606,562],"lca":{"taxid":131567,"name":"cellular organisms","rank":"no rank"},"merged":{}}
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		host := getFlagString(cmd, "host")
+		port := getFlagPositiveInt(cmd, "port")
+		cacheSize := getFlagNonNegativeInt(cmd, "cache-size")
+		maxNodes := getFlagPositiveInt(cmd, "max-nodes")
+
+		// -------------------- load data ----------------------
+
+		tree, ranks, names, delnodes, merged := loadData(config, true, true, false)
+
+		if config.Verbose {
+			log.Infof("parsing names file for name2taxid: %s", config.NamesFile)
+		}
+		name2taxids, _ := getTaxonName2Taxids(config.NamesFile, false, nil)
+
+		taxdb := &taxonomy.Taxonomy{Nodes: tree, Ranks: ranks, Names: names, DelNodes: delnodes, Merged: merged}
+
+		s := &taxonServer{
+			taxdb:       taxdb,
+			name2taxids: name2taxids,
+			cache:       newLineageCache(taxdb, cacheSize),
+			maxNodes:    maxNodes,
+			verbose:     config.Verbose,
+		}
+
+		// -------------------- serve ----------------------
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/lineage/", s.handleLineage)
+		mux.HandleFunc("/list/", s.handleList)
+		mux.HandleFunc("/name2taxid/", s.handleName2Taxid)
+		mux.HandleFunc("/lca", s.handleLCA)
+
+		addr := fmt.Sprintf("%s:%d", host, port)
+		server := &http.Server{Addr: addr, Handler: mux}
+
+		done := make(chan struct{})
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+
+			log.Infof("shutting down the server ...")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Warningf("failed to shut down the server gracefully: %s", err)
+			}
+			close(done)
+		}()
+
+		log.Infof("serving on http://%s", addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			checkError(err)
+		}
+		<-done
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringP("host", "", "localhost", `host to listen on, use "0.0.0.0" for all interfaces`)
+	serveCmd.Flags().IntP("port", "p", 8080, `port to listen on`)
+	serveCmd.Flags().IntP("cache-size", "", 1<<18, `maximum number of TaxIds of which the lineages are cached. 0 for no cache`)
+	serveCmd.Flags().IntP("max-nodes", "", 100000, `maximum number of nodes in a subtree returned by /list`)
+}
+
+// taxonServer holds taxonomy data for serving HTTP queries.
+type taxonServer struct {
+	taxdb       *taxonomy.Taxonomy
+	name2taxids map[string][]uint32 // lower-case names
+	cache       *lineageCache
+	maxNodes    int // for /list
+	verbose     bool
+}
+
+// writeJSON writes a JSON body with the status code.
+func (s *taxonServer) writeJSON(w http.ResponseWriter, r *http.Request, status int, body string) {
+	if s.verbose {
+		log.Infof("%s %s %d", r.Method, r.URL.RequestURI(), status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body + "\n"))
+}
+
+// writeError writes an error message with the status code.
+func (s *taxonServer) writeError(w http.ResponseWriter, r *http.Request, status int, format string, a ...interface{}) {
+	s.writeJSON(w, r, status, `{"error":`+jsonString(fmt.Sprintf(format, a...))+`}`)
+}
+
+// taxonJSON returns a JSON object of the TaxId, name, and rank of a TaxId.
+func (s *taxonServer) taxonJSON(taxid uint32) string {
+	return fmt.Sprintf(`{"taxid":%d,"name":%s,"rank":%s}`, taxid, jsonString(s.taxdb.Names[taxid]), jsonString(s.taxdb.Ranks[taxid]))
+}
+
+// taxidStatus checks a TaxId and returns the HTTP status code:
+// 200 for existed ones, 301 for merged ones along with the new TaxId,
+// 410 for deleted ones, and 404 for the others.
+func (s *taxonServer) taxidStatus(taxid uint32) (int, uint32) {
+	newtaxid, status := s.taxdb.Resolve(taxid)
+	switch status {
+	case taxonomy.Found:
+		return http.StatusOK, taxid
+	case taxonomy.Deleted:
+		return http.StatusGone, taxid
+	case taxonomy.Merged:
+		return http.StatusMovedPermanently, newtaxid
+	}
+	return http.StatusNotFound, taxid
+}
+
+// resolveTaxid parses the TaxId in the path after the prefix. If the TaxId is
+// not valid, an error response is written and false is returned.
+func (s *taxonServer) resolveTaxid(w http.ResponseWriter, r *http.Request, prefix string) (uint32, bool) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed: %s", r.Method)
+		return 0, false
+	}
+
+	query := strings.TrimPrefix(r.URL.Path, prefix)
+	id, err := strconv.ParseUint(query, 10, 32)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "invalid TaxId: %s", query)
+		return 0, false
+	}
+	taxid := uint32(id)
+
+	status, newtaxid := s.taxidStatus(taxid)
+	switch status {
+	case http.StatusOK:
+		return taxid, true
+	case http.StatusMovedPermanently:
+		w.Header().Set("Location", fmt.Sprintf("%s%d", prefix, newtaxid))
+		s.writeJSON(w, r, status, fmt.Sprintf(`{"error":%s,"taxid":%d,"merged_into":%d}`,
+			jsonString(fmt.Sprintf("taxid %d was merged into %d", taxid, newtaxid)), taxid, newtaxid))
+	case http.StatusGone:
+		s.writeJSON(w, r, status, fmt.Sprintf(`{"error":%s,"taxid":%d}`,
+			jsonString(fmt.Sprintf("taxid %d was deleted", taxid)), taxid))
+	default:
+		s.writeJSON(w, r, status, fmt.Sprintf(`{"error":%s,"taxid":%d}`,
+			jsonString(fmt.Sprintf("taxid %d not found", taxid)), taxid))
+	}
+	return 0, false
+}
+
+// handleLineage handles /lineage/{taxid}.
+func (s *taxonServer) handleLineage(w http.ResponseWriter, r *http.Request) {
+	taxid, ok := s.resolveTaxid(w, r, "/lineage/")
+	if !ok {
+		return
+	}
+	query := strings.TrimPrefix(r.URL.Path, "/lineage/")
+	s.writeJSON(w, r, http.StatusOK, lineageJSON(query, taxid, s.cache.path(taxid), s.taxdb.Names, s.taxdb.Ranks))
+}
+
+// handleList handles /list/{taxid}?max-depth=n.
+func (s *taxonServer) handleList(w http.ResponseWriter, r *http.Request) {
+	taxid, ok := s.resolveTaxid(w, r, "/list/")
+	if !ok {
+		return
+	}
+
+	maxDepth := 1
+	if v := r.URL.Query().Get("max-depth"); v != "" {
+		var err error
+		maxDepth, err = strconv.Atoi(v)
+		if err != nil || maxDepth < -1 {
+			s.writeError(w, r, http.StatusBadRequest, "invalid value of max-depth: %s", v)
+			return
+		}
+	}
+
+	taxids, depths := s.subtree(taxid, maxDepth, s.maxNodes)
+	if len(taxids) > s.maxNodes {
+		s.writeError(w, r, http.StatusRequestEntityTooLarge,
+			"more than %d nodes in the subtree of %d, please use a smaller max-depth", s.maxNodes, taxid)
+		return
+	}
+
+	if s.verbose {
+		log.Infof("%s %s %d", r.Method, r.URL.RequestURI(), http.StatusOK)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	writeSubtreeJSON(bw, taxids, depths, s.taxdb.Names, s.taxdb.Ranks)
+	bw.WriteString("\n")
+	bw.Flush()
+}
+
+// subtree returns TaxIds in the subtree of a TaxId in depth-first order,
+// along with their depths, where children deeper than maxDepth (negative for
+// no limit) are not included. It stops once more than limit nodes are found.
+// Nodes visited again because of cycles in malformed dump files are skipped.
+func (s *taxonServer) subtree(taxid uint32, maxDepth int, limit int) ([]uint32, []int) {
+	taxids := make([]uint32, 0, 64)
+	depths := make([]int, 0, 64)
+	visited := make(map[uint32]struct{}, 64)
+
+	stack := []listNode{{taxid: taxid, depth: 0}}
+	var node listNode
+	var children []uint32
+	var ok bool
+	var i int
+	for len(stack) > 0 && len(taxids) <= limit {
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok = visited[node.taxid]; ok {
+			continue
+		}
+		visited[node.taxid] = struct{}{}
+		taxids = append(taxids, node.taxid)
+		depths = append(depths, node.depth)
+
+		if maxDepth >= 0 && node.depth >= maxDepth {
+			continue
+		}
+		children = s.taxdb.Children(node.taxid)
+		for i = len(children) - 1; i >= 0; i-- { // children in ascending order
+			stack = append(stack, listNode{taxid: children[i], depth: node.depth + 1})
+		}
+	}
+	return taxids, depths
+}
+
+// writeSubtreeJSON writes nodes in depth-first order as nested JSON objects.
+func writeSubtreeJSON(w *bufio.Writer, taxids []uint32, depths []int, names map[uint32]string, ranks map[uint32]string) {
+	for i, taxid := range taxids {
+		if i > 0 && depths[i] <= depths[i-1] { // not the first child, close previous nodes
+			w.WriteString(strings.Repeat("]}", depths[i-1]-depths[i]+1))
+			w.WriteString(",")
+		}
+		w.WriteString(fmt.Sprintf(`{"taxid":%d,"name":%s,"rank":%s,"children":[`,
+			taxid, jsonString(names[taxid]), jsonString(ranks[taxid])))
+	}
+	if n := len(taxids); n > 0 {
+		w.WriteString(strings.Repeat("]}", depths[n-1]+1))
+	}
+}
+
+// handleName2Taxid handles /name2taxid/{name}.
+func (s *taxonServer) handleName2Taxid(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed: %s", r.Method)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/name2taxid/")
+	taxids := s.name2taxids[strings.ToLower(name)]
+	if len(taxids) == 0 {
+		s.writeError(w, r, http.StatusNotFound, "name not found: %s", name)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"name":` + jsonString(name) + `,"taxids":[`)
+	for i, taxid := range taxids {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(s.taxonJSON(taxid))
+	}
+	buf.WriteString("]}")
+	s.writeJSON(w, r, http.StatusOK, buf.String())
+}
+
+// handleLCA handles /lca?ids=1,2,3.
+func (s *taxonServer) handleLCA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed: %s", r.Method)
+		return
+	}
+
+	query := r.URL.Query().Get("ids")
+	if query == "" {
+		s.writeError(w, r, http.StatusBadRequest, `query parameter "ids" needed`)
+		return
+	}
+
+	ids := make([]uint32, 0, 8)
+	taxids := make([]uint32, 0, 8)
+	merges := make([][2]uint32, 0, 1)
+	var status int
+	var taxid, newtaxid uint32
+	for _, item := range strings.Split(query, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(item, 10, 32)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, "invalid TaxId: %s", item)
+			return
+		}
+		taxid = uint32(id)
+		ids = append(ids, taxid)
+
+		status, newtaxid = s.taxidStatus(taxid)
+		switch status {
+		case http.StatusOK:
+		case http.StatusMovedPermanently:
+			merges = append(merges, [2]uint32{taxid, newtaxid})
+			taxid = newtaxid
+		case http.StatusGone:
+			s.writeJSON(w, r, status, fmt.Sprintf(`{"error":%s,"taxid":%d}`,
+				jsonString(fmt.Sprintf("taxid %d was deleted", taxid)), taxid))
+			return
+		default:
+			s.writeJSON(w, r, status, fmt.Sprintf(`{"error":%s,"taxid":%d}`,
+				jsonString(fmt.Sprintf("taxid %d not found", taxid)), taxid))
+			return
+		}
+		taxids = append(taxids, taxid)
+	}
+	if len(taxids) == 0 {
+		s.writeError(w, r, http.StatusBadRequest, "no valid TaxIds given: %s", query)
+		return
+	}
+
+	taxid = s.taxdb.LCA(taxids...)
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"ids":[`)
+	for i, id := range ids {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(strconv.Itoa(int(id)))
+	}
+	buf.WriteString(`],"lca":` + s.taxonJSON(taxid) + `,"merged":{`)
+	for i, m := range merges {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(fmt.Sprintf(`"%d":%d`, m[0], m[1]))
+	}
+	buf.WriteString("}}")
+	s.writeJSON(w, r, http.StatusOK, buf.String())
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+)
+
+func TestServeList(t *testing.T) {
+	// 1 root
+	// ├── 2 Bacteria
+	// │   ├── 3 Bacillota
+	// │   └── 4 Pseudomonadota
+	// └── 5 Archaea
+	//
+	// and a cycle of 6 and 7, which are not connected to the root.
+	taxdb := &taxonomy.Taxonomy{
+		Nodes: map[uint32]uint32{1: 1, 2: 1, 3: 2, 4: 2, 5: 1, 6: 7, 7: 6},
+		Names: map[uint32]string{1: "root", 2: "Bacteria", 3: "Bacillota", 4: "Pseudomonadota", 5: "Archaea", 6: "a", 7: "b"},
+		Ranks: map[uint32]string{1: "no rank", 2: "superkingdom", 3: "phylum", 4: "phylum", 5: "superkingdom", 6: "no rank", 7: "no rank"},
+	}
+	s := &taxonServer{taxdb: taxdb, maxNodes: 4}
+
+	node := func(taxid, name, rank, children string) string {
+		return `{"taxid":` + taxid + `,"name":"` + name + `","rank":"` + rank + `","children":[` + children + `]}`
+	}
+	bacteria := node("2", "Bacteria", "superkingdom",
+		node("3", "Bacillota", "phylum", "")+","+node("4", "Pseudomonadota", "phylum", ""))
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/list/2", http.StatusOK, bacteria},
+		{"/list/2?max-depth=0", http.StatusOK, node("2", "Bacteria", "superkingdom", "")},
+		{"/list/1", http.StatusOK, node("1", "root", "no rank",
+			node("2", "Bacteria", "superkingdom", "")+","+node("5", "Archaea", "superkingdom", ""))},
+		{"/list/1?max-depth=-1", http.StatusRequestEntityTooLarge, ""},
+		{"/list/6?max-depth=-1", http.StatusOK, node("6", "a", "no rank", node("7", "b", "no rank", ""))},
+		{"/list/2?max-depth=x", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		s.handleList(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.url, rec.Code, test.status)
+			continue
+		}
+		if test.body != "" && rec.Body.String() != test.body+"\n" {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", test.url, rec.Body.String(), test.body)
+		}
+	}
+
+	// the whole tree
+	s.maxNodes = 100
+	taxids, depths := s.subtree(1, -1, s.maxNodes)
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeSubtreeJSON(w, taxids, depths, taxdb.Names, taxdb.Ranks)
+	w.Flush()
+	if want := node("1", "root", "no rank", bacteria+","+node("5", "Archaea", "superkingdom", "")); buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
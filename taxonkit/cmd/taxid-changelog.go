@@ -30,6 +30,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -113,6 +114,9 @@ Output format (CSV):
 
     # you can use csvtk to investigate them. e.g.,
     csvtk grep -f taxid -p 1390515 taxid-changelog.csv.gz
+
+    # or only output the history of some TaxIds and TaxIds they were merged into
+    taxonkit taxid-changelog -i archive -t 1313 -o 1313.csv
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -122,8 +126,23 @@ Output format (CSV):
 			checkError(fmt.Errorf("flag -i/--archive needed"))
 		}
 
+		queries := make([]uint32, 0, 8)
+		var id uint32
+		var err error
+		for _, s := range getFlagStringSlice(cmd, "taxid") {
+			if s == "" {
+				continue
+			}
+			id, err = taxonomy.ParseTaxId(s)
+			if err != nil || id == 0 {
+				checkTaxIdRange(err)
+				checkError(fmt.Errorf("invalid TaxId given by -t/--taxid: %s", s))
+			}
+			queries = append(queries, id)
+		}
+
 		dirs := checkArchives(config, archivePath)
-		createChangelog(config, archivePath, dirs)
+		createChangelog(config, archivePath, dirs, queries)
 	},
 }
 
@@ -131,6 +150,7 @@ func init() {
 	RootCmd.AddCommand(taxidlogCmd)
 
 	taxidlogCmd.Flags().StringP("archive", "i", "", "directory containing uncompressed dumped archives")
+	taxidlogCmd.Flags().StringSliceP("taxid", "t", []string{}, `only output changelog of these TaxIds and TaxIds they were merged into, multiple values can be separated with comma or give multiple times`)
 }
 
 // TaxidChangeCode represents code of taxid change type
@@ -287,7 +307,7 @@ func (c TaxidChange) String() string {
 	return buf.String()
 }
 
-func createChangelog(config Config, path string, dirs []string) {
+func createChangelog(config Config, path string, dirs []string, queries []uint32) {
 	outfh, err := xopen.Wopen(config.OutFile)
 	checkError(err)
 	defer outfh.Close()
@@ -344,7 +364,7 @@ func createChangelog(config Config, path string, dirs []string) {
 			} else if existed {
 				taxid2lineageTaxids, taxid2rank = getTaxid2LineageTaxids(_pathGz)
 			} else {
-				taxid2lineageTaxids, taxid2rank = getTaxid2LineageTaxids(_path)
+				taxid2lineageTaxids, taxid2rank = getTaxid2LineageTaxids(dumpFile(filepath.Join(path, dir), "nodes.dmp"))
 			}
 			wg.Done()
 		}()
@@ -356,7 +376,7 @@ func createChangelog(config Config, path string, dirs []string) {
 			} else if existed {
 				taxid2name = getTaxonNames(_pathGz)
 			} else {
-				taxid2name = getTaxonNames(_path)
+				taxid2name = getTaxonNames(dumpFile(filepath.Join(path, dir), "names.dmp"))
 			}
 			wg.Done()
 		}()
@@ -368,7 +388,7 @@ func createChangelog(config Config, path string, dirs []string) {
 			} else if existed {
 				delTaxids = getDelnodes(_pathGz)
 			} else {
-				delTaxids = getDelnodes(_path)
+				delTaxids = getDelnodes(dumpFile(filepath.Join(path, dir), "delnodes.dmp"))
 			}
 			wg.Done()
 		}()
@@ -380,7 +400,7 @@ func createChangelog(config Config, path string, dirs []string) {
 			} else if existed {
 				merges = getMergedNodes(_pathGz)
 			} else {
-				merges = getMergedNodes(_path)
+				merges = getMergedNodes(dumpFile(filepath.Join(path, dir), "merged.dmp"))
 			}
 			wg.Done()
 		}()
@@ -606,11 +626,43 @@ func createChangelog(config Config, path string, dirs []string) {
 	if config.Verbose {
 		log.Infof("sorting %d taxids", len(data))
 	}
-	taxids := make([]int, len(data))
-	i = 0
-	for taxid := range data {
-		taxids[i] = int(taxid)
-		i++
+	var taxids []int
+	if len(queries) > 0 {
+		// only the queried taxids and taxids they were merged into
+		selected := make(map[uint32]interface{}, len(queries))
+		toCheck := make([]uint32, len(queries))
+		copy(toCheck, queries)
+		var taxid uint32
+		for len(toCheck) > 0 {
+			taxid = toCheck[len(toCheck)-1]
+			toCheck = toCheck[:len(toCheck)-1]
+			if _, ok = selected[taxid]; ok {
+				continue
+			}
+			selected[taxid] = struct{}{}
+
+			for _, c = range data[taxid] {
+				if c.Change == TaxidMerge {
+					toCheck = append(toCheck, c.ChangeValue[0])
+				}
+			}
+		}
+
+		taxids = make([]int, 0, len(selected))
+		for taxid := range selected {
+			if _, ok = data[taxid]; !ok {
+				log.Warningf("taxid %d not found in archives", taxid)
+				continue
+			}
+			taxids = append(taxids, int(taxid))
+		}
+	} else {
+		taxids = make([]int, len(data))
+		i = 0
+		for taxid := range data {
+			taxids[i] = int(taxid)
+			i++
+		}
 	}
 	sort.Ints(taxids)
 
@@ -739,13 +791,12 @@ func checkArchives(config Config, path string) []string {
 }
 
 func checkFile(file string) {
-	if exists, err := pathutil.Exists(file); err != nil {
-		checkError(fmt.Errorf("checking %s: %s", file, err))
-	} else if !exists {
-		if exists, err := pathutil.Exists(file + ".gz"); err != nil {
-			checkError(fmt.Errorf("checking %s: %s", file+".gz", err))
-		} else if !exists {
-			checkError(fmt.Errorf("neither %s or %s found in %s", filepath.Base(file), filepath.Base(file)+".gz", filepath.Dir(file)))
+	for _, suffix := range taxonomy.DumpFileSuffixes {
+		if exists, err := pathutil.Exists(file + suffix); err != nil {
+			checkError(fmt.Errorf("checking %s: %s", file+suffix, err))
+		} else if exists {
+			return
 		}
 	}
+	checkError(fmt.Errorf("none of %s and its compressed files (.gz, .zst, .xz) found in %s", filepath.Base(file), filepath.Dir(file)))
 }
@@ -0,0 +1,92 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// atomicOutFile is the final path of the output file for --atomic,
+// empty if not used.
+var atomicOutFile string
+
+// atomicTmpFile is the temporary file written instead of atomicOutFile.
+var atomicTmpFile string
+
+var onceAtomicOutput sync.Once
+
+// startAtomicOutput returns a temporary file in the same directory of file,
+// which should be written instead of file, and renamed to file by
+// finishAtomicOutput when the command finishes successfully. The temporary
+// file has the same suffix as file, so the compression format is kept.
+// The temporary file is removed if the program is interrupted.
+func startAtomicOutput(file string) string {
+	if atomicTmpFile != "" { // already started
+		return atomicTmpFile
+	}
+	atomicOutFile = file
+	atomicTmpFile = filepath.Join(filepath.Dir(file),
+		fmt.Sprintf(".taxonkit-tmp-%d.%s", os.Getpid(), filepath.Base(file)))
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		discardAtomicOutput()
+		log.Errorf("interrupted by signal: %s, output file not changed: %s", sig, atomicOutFile)
+		os.Exit(-1)
+	}()
+
+	return atomicTmpFile
+}
+
+// finishAtomicOutput renames the temporary file to the output file.
+// It's called when the program exits normally, and only runs once.
+func finishAtomicOutput() {
+	if atomicTmpFile == "" {
+		return
+	}
+	onceAtomicOutput.Do(func() {
+		if _, err := os.Stat(atomicTmpFile); os.IsNotExist(err) { // nothing written
+			return
+		}
+		if err := os.Rename(atomicTmpFile, atomicOutFile); err != nil {
+			log.Errorf("fail to rename %s to %s: %s", atomicTmpFile, atomicOutFile, err)
+			os.Exit(-1)
+		}
+	})
+}
+
+// discardAtomicOutput removes the temporary file, keeping the output file
+// unchanged. It's called when the program exits via checkError, and only runs once.
+func discardAtomicOutput() {
+	if atomicTmpFile == "" {
+		return
+	}
+	onceAtomicOutput.Do(func() {
+		os.Remove(atomicTmpFile)
+	})
+}
@@ -22,16 +22,48 @@ package cmd
 
 import (
 	"bufio"
-	"strconv"
+	"os"
+	"path/filepath"
 	"sync"
 
-	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 )
 
 var mapInitialSize = 8 << 10
 
-func loadData(config Config, loadTree bool, recordRank bool) (
+// bytesPerNode is the approximate size of a record in nodes.dmp,
+// for estimating the number of nodes from the file size.
+const bytesPerNode = 64
+
+// setMapInitialSize sets the initial size of maps for TaxIds to hint, or to
+// the number of nodes estimated from the size of nodes.dmp if hint is 0.
+// The default size is kept for small, compressed, or archived dump files.
+func setMapInitialSize(hint int, nodesFile string, verbose bool) {
+	if hint == 0 {
+		if taxonomy.IsArchive(filepath.Dir(nodesFile)) || filepath.Ext(nodesFile) != ".dmp" {
+			return
+		}
+		info, err := os.Stat(nodesFile)
+		if err != nil {
+			return
+		}
+		if hint = int(info.Size() / bytesPerNode); hint <= mapInitialSize {
+			return
+		}
+	}
+
+	mapInitialSize = hint
+	taxonomy.SetMapInitialSize(hint)
+	if verbose {
+		log.Infof("initial size of maps for TaxIds: %d", hint)
+	}
+}
+
+// loadData loads the tree (with ranks) if loadTree, names, and deleted and
+// merged nodes unless nodesOnly, in which case empty maps are returned for them.
+// The index is not used with nodesOnly, as it's decoded as a whole.
+func loadData(config Config, loadTree bool, recordRank bool, nodesOnly bool) (
 	map[uint32]uint32,
 	map[uint32]string,
 	map[uint32]string,
@@ -45,6 +77,20 @@ func loadData(config Config, loadTree bool, recordRank bool) (
 	var delnodes map[uint32]struct{}
 	var merged map[uint32]uint32
 
+	var idx *taxdumpIndex
+	if !nodesOnly {
+		idx = loadIndex(config)
+	}
+	if idx != nil {
+		if loadTree {
+			tree = idx.Tree
+			if recordRank {
+				ranks = idx.Ranks
+			}
+		}
+		return tree, ranks, idx.Names, idx.delNodesMap(), idx.Merged
+	}
+
 	var wg sync.WaitGroup
 
 	// tree
@@ -75,6 +121,11 @@ func loadData(config Config, loadTree bool, recordRank bool) (
 		wg.Done()
 	}()
 
+	if nodesOnly {
+		wg.Wait()
+		return tree, ranks, names, map[uint32]struct{}{}, map[uint32]uint32{}
+	}
+
 	wg.Add(1)
 	go func() {
 		if config.Verbose {
@@ -106,131 +157,28 @@ func loadData(config Config, loadTree bool, recordRank bool) (
 
 // taxid -> name
 func getTaxonNames(file string) map[uint32]string {
-	fh, err := xopen.Ropen(file)
+	taxid2name, err := taxonomy.ReadNames(file)
 	checkError(err)
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	taxid2name := make(map[uint32]string, mapInitialSize)
-
-	items := make([]string, 8)
-	scanner := bufio.NewScanner(fh)
-	var id int
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 8, &items)
-		if len(items) < 8 {
-			continue
-		}
-		if items[6] != "scientific name" {
-			continue
-		}
-		id, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
-		}
-
-		taxid2name[uint32(id)] = items[2]
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
-	}
-
 	return taxid2name
 }
 
 // child -> parent. taxid -> rank
 func getNodes(file string, recordRank bool) (map[uint32]uint32, map[uint32]string) {
-	tree := make(map[uint32]uint32, mapInitialSize)
-	var ranks map[uint32]string
-	if recordRank {
-		ranks = make(map[uint32]string, mapInitialSize)
-	}
-
-	fh, err := xopen.Ropen(file)
+	tree, ranks, err := taxonomy.ReadNodes(file, recordRank)
 	checkError(err)
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	items := make([]string, 6)
-	scanner := bufio.NewScanner(fh)
-	var _child, _parent int
-	var child, parent uint32
-	var rank string
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 6, &items)
-		if len(items) < 6 {
-			continue
-		}
-
-		_child, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
-		}
-
-		_parent, err = strconv.Atoi(items[2])
-		if err != nil {
-			continue
-		}
-		child, parent, rank = uint32(_child), uint32(_parent), items[4]
-
-		// ----------------------------------
-
-		tree[child] = parent
-		if recordRank {
-			ranks[child] = rank
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
-	}
-
 	return tree, ranks
 }
 
 func getRanks(file string) map[uint32]string {
-	ranks := make(map[uint32]string, mapInitialSize)
-
-	fh, err := xopen.Ropen(file)
+	ranks, err := taxonomy.ReadRanks(file)
 	checkError(err)
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	items := make([]string, 6)
-	scanner := bufio.NewScanner(fh)
-	var _child int
-	var child uint32
-	var rank string
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 6, &items)
-		if len(items) < 6 {
-			continue
-		}
-
-		_child, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
-		}
-
-		child, rank = uint32(_child), items[4]
-
-		// ----------------------------------
-
-		ranks[child] = rank
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
-	}
-
 	return ranks
 }
 
 func getDelnodes(file string) []uint32 {
 	taxids := make([]uint32, 0, 1<<10)
 
-	existed, err := pathutil.Exists(file)
+	existed, err := taxonomy.Exists(file)
 	if err != nil {
 		checkError(err)
 	}
@@ -239,7 +187,7 @@ func getDelnodes(file string) []uint32 {
 		return taxids
 	}
 
-	fh, err := xopen.Ropen(file)
+	fh, err := taxonomy.Open(file)
 	if err == xopen.ErrNoContent {
 		return taxids
 	} else {
@@ -252,18 +200,19 @@ func getDelnodes(file string) []uint32 {
 	items := make([]string, 2)
 
 	scanner := bufio.NewScanner(fh)
-	var id int
+	var id uint32
 	for scanner.Scan() {
 		stringSplitN(scanner.Text(), "\t", 2, &items)
 		if len(items) < 2 {
 			continue
 		}
-		id, err = strconv.Atoi(items[0])
+		id, err = taxonomy.ParseTaxId(items[0])
 		if err != nil {
+			checkTaxIdRange(err)
 			continue
 		}
 
-		taxids = append(taxids, uint32(id))
+		taxids = append(taxids, id)
 	}
 	if err := scanner.Err(); err != nil {
 		checkError(err)
@@ -273,54 +222,22 @@ func getDelnodes(file string) []uint32 {
 }
 
 func getDelnodesMap(file string) map[uint32]struct{} {
-	taxids := make(map[uint32]struct{}, 1<<10)
-
-	existed, err := pathutil.Exists(file)
-	if err != nil {
-		checkError(err)
-	}
+	existed, err := taxonomy.Exists(file)
+	checkError(err)
 	if !existed {
 		log.Warningf("delnodes file not found: %s, deleted taxids will not be checked", file)
-		return taxids
-	}
-
-	fh, err := xopen.Ropen(file)
-	if err == xopen.ErrNoContent {
-		return taxids
-	} else {
-		checkError(err)
-	}
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	items := make([]string, 2)
-
-	scanner := bufio.NewScanner(fh)
-	var id int
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 2, &items)
-		if len(items) < 2 {
-			continue
-		}
-		id, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
-		}
-
-		taxids[uint32(id)] = struct{}{}
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
+		return make(map[uint32]struct{})
 	}
 
+	taxids, err := taxonomy.ReadDelNodes(file)
+	checkError(err)
 	return taxids
 }
 
 func getMergedNodes(file string) [][2]uint32 {
 	merges := make([][2]uint32, 0, 1<<10)
 
-	existed, err := pathutil.Exists(file)
+	existed, err := taxonomy.Exists(file)
 	if err != nil {
 		checkError(err)
 	}
@@ -329,7 +246,7 @@ func getMergedNodes(file string) [][2]uint32 {
 		return merges
 	}
 
-	fh, err := xopen.Ropen(file)
+	fh, err := taxonomy.Open(file)
 	if err == xopen.ErrNoContent {
 		return merges
 	} else {
@@ -342,22 +259,24 @@ func getMergedNodes(file string) [][2]uint32 {
 	items := make([]string, 4)
 
 	scanner := bufio.NewScanner(fh)
-	var from, to int
+	var from, to uint32
 	for scanner.Scan() {
 		stringSplitN(scanner.Text(), "\t", 4, &items)
 		if len(items) < 4 {
 			continue
 		}
-		from, err = strconv.Atoi(items[0])
+		from, err = taxonomy.ParseTaxId(items[0])
 		if err != nil {
+			checkTaxIdRange(err)
 			continue
 		}
-		to, err = strconv.Atoi(items[2])
+		to, err = taxonomy.ParseTaxId(items[2])
 		if err != nil {
+			checkTaxIdRange(err)
 			continue
 		}
 
-		merges = append(merges, [2]uint32{uint32(from), uint32(to)})
+		merges = append(merges, [2]uint32{from, to})
 	}
 	if err := scanner.Err(); err != nil {
 		checkError(err)
@@ -367,50 +286,14 @@ func getMergedNodes(file string) [][2]uint32 {
 }
 
 func getMergedNodesMap(file string) map[uint32]uint32 {
-	merges := make(map[uint32]uint32, 1<<10)
-
-	existed, err := pathutil.Exists(file)
-	if err != nil {
-		checkError(err)
-	}
+	existed, err := taxonomy.Exists(file)
+	checkError(err)
 	if !existed {
 		log.Warningf("merged file not found: %s, merged taxids will not be checked", file)
-		return merges
-	}
-
-	fh, err := xopen.Ropen(file)
-	if err == xopen.ErrNoContent {
-		return merges
-	} else {
-		checkError(err)
-	}
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	items := make([]string, 4)
-
-	scanner := bufio.NewScanner(fh)
-	var from, to int
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 4, &items)
-		if len(items) < 4 {
-			continue
-		}
-		from, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
-		}
-		to, err = strconv.Atoi(items[2])
-		if err != nil {
-			continue
-		}
-
-		merges[uint32(from)] = uint32(to)
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
+		return make(map[uint32]uint32)
 	}
 
+	merges, err := taxonomy.ReadMerged(file)
+	checkError(err)
 	return merges
 }
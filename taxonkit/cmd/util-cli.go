@@ -23,14 +23,16 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -54,28 +56,113 @@ type Config struct {
 	LineBuffered bool
 }
 
-func errDataNotFound(dataDir string) {
-	checkError(fmt.Errorf(`taxonomy data not found, please download and uncompress ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz, and copy "names.dmp", "nodes.dmp", "delnodes.dmp", and "merged.dmp" to %s`, dataDir))
+// errDataNotFound reports the missing file (empty for the missing directory),
+// and the data directory along with its source.
+func errDataNotFound(dataDir string, source string, missing string) {
+	if missing != "" {
+		checkError(fmt.Errorf(`taxonomy data file not found: %s (plain or compressed with gzip, zstd, or xz) in %s (set by %s). please download and uncompress ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz, and copy "names.dmp", "nodes.dmp", "delnodes.dmp", and "merged.dmp" to the directory`, missing, dataDir, source))
+	}
+	checkError(fmt.Errorf(`taxonomy data directory not found: %s (set by %s), an empty one is created. please download and uncompress ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz, and copy "names.dmp", "nodes.dmp", "delnodes.dmp", and "merged.dmp" (plain or compressed with gzip, zstd, or xz) to it`, dataDir, source))
+}
+
+// dumpFile returns the path of a dump file in dir, checking the plain file,
+// and then gzip, zstd, and xz-compressed ones. The path of the plain file is
+// returned if none exists.
+func dumpFile(dir string, name string) string {
+	file, err := taxonomy.DumpFile(dir, name)
+	checkError(err)
+	return file
+}
+
+// checkTaxIdRange exits for TaxIds larger than taxonomy.MaxTaxId,
+// which are not supported, while other invalid values are skipped by callers.
+func checkTaxIdRange(err error) {
+	if errors.Is(err, taxonomy.ErrTaxIdOutOfRange) {
+		checkError(fmt.Errorf("%s, the largest supported TaxId is %d", err, uint32(taxonomy.MaxTaxId)))
+	}
+}
+
+// checkDataDirNotArchive exits for commands not supporting
+// reading dump files from an archive.
+func checkDataDirNotArchive(dir string) {
+	if taxonomy.IsArchive(dir) {
+		checkError(fmt.Errorf("reading dump files from an archive is not supported by this command, please extract it: %s", dir))
+	}
+}
+
+// withLocalDumpFile calls fn with the path of a dump file on the disk, for
+// functions accepting only file paths, e.g., the ones in bio/taxdump.
+// A member of an archive is extracted into a temporary file, which is
+// removed after fn returns.
+func withLocalDumpFile(file string, fn func(file string) error) error {
+	if !taxonomy.IsArchive(filepath.Dir(file)) {
+		return fn(file)
+	}
+
+	tmp, err := ioutil.TempFile("", "taxonkit-*-"+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	fh, err := taxonomy.Open(file)
+	if err == nil {
+		_, err = io.Copy(tmp, fh)
+		fh.Close()
+	} else if err == xopen.ErrNoContent {
+		err = nil
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+
+	return fn(tmp.Name())
 }
 
 func getConfigs(cmd *cobra.Command) Config {
+	if logFile := getFlagString(cmd, "log-file"); logFile != "" {
+		useLogFile(logFile, getFlagBool(cmd, "log-json"))
+	} else if getFlagBool(cmd, "log-json") {
+		useJSONLog(os.Stderr)
+	}
+
+	if getFlagBool(cmd, "quiet") {
+		if getFlagBool(cmd, "verbose") {
+			checkError(fmt.Errorf("flag --quiet and --verbose are exclusive"))
+		}
+		quietLog()
+	}
+
+	outFile := getFlagString(cmd, "out-file")
+	if getFlagBool(cmd, "atomic") && !isStdin(outFile) {
+		outFile = startAtomicOutput(outFile)
+	}
+
 	threads := getFlagPositiveInt(cmd, "threads")
 
 	runtime.GOMAXPROCS(threads)
 	sorts.MaxProcs = threads
 
-	var val, dataDir string
-	if val = os.Getenv("TAXONKIT_DB"); val != "" {
-		if cmd.Flags().Lookup("data-dir").Changed { // users explicitly set the option
-			dataDir = getFlagString(cmd, "data-dir")
-		} else {
-			dataDir = val
-		}
+	// precedence of the data directory:
+	//   1. the flag --data-dir, if explicitly given
+	//   2. the environment variable TAXONKIT_DB
+	//   3. the default value of --data-dir, i.e., ~/.taxonkit
+	var val, dataDir, source string
+	if cmd.Flags().Lookup("data-dir").Changed { // users explicitly set the option
+		dataDir, source = getFlagString(cmd, "data-dir"), "flag --data-dir"
+	} else if val = os.Getenv("TAXONKIT_DB"); val != "" {
+		dataDir, source = val, "environment variable TAXONKIT_DB"
 	} else {
-		dataDir = getFlagString(cmd, "data-dir")
+		dataDir, source = getFlagString(cmd, "data-dir"), "default value of --data-dir"
+	}
+	if getFlagBool(cmd, "verbose") {
+		log.Infof("data directory: %s (set by %s)", dataDir, source)
 	}
 
-	whiteList := []string{"create-taxdump", "taxid-changelog"}
+	whiteList := []string{"create-taxdump", "taxid-changelog", "diff"}
 	var skipCheckingDataDir bool
 	currentCmd := cmd.Name()
 	for _, c := range whiteList {
@@ -85,33 +172,38 @@ func getConfigs(cmd *cobra.Command) Config {
 		}
 	}
 
-	existed, err := pathutil.DirExists(dataDir)
-	checkError(err)
-	if !existed && !skipCheckingDataDir {
-		checkError(os.MkdirAll(dataDir, 0777))
-		errDataNotFound(dataDir)
+	// a tar archive of dump files, e.g., taxdump.tar.gz, can be used as the data directory
+	if !taxonomy.IsArchive(dataDir) {
+		existed, err := pathutil.DirExists(dataDir)
+		checkError(err)
+		if !existed && !skipCheckingDataDir {
+			checkError(os.MkdirAll(dataDir, 0777))
+			errDataNotFound(dataDir, source, "")
+		}
 	}
 
-	nodesFile := filepath.Join(dataDir, "nodes.dmp")
-	existed, err = pathutil.Exists(nodesFile)
+	nodesFile := dumpFile(dataDir, "nodes.dmp")
+	existed, err := taxonomy.Exists(nodesFile)
 	checkError(err)
 	if !existed && !skipCheckingDataDir {
-		errDataNotFound(dataDir)
+		errDataNotFound(dataDir, source, "nodes.dmp")
 	}
 
-	namesFile := filepath.Join(dataDir, "names.dmp")
-	existed, err = pathutil.Exists(namesFile)
+	namesFile := dumpFile(dataDir, "names.dmp")
+	existed, err = taxonomy.Exists(namesFile)
 	checkError(err)
 	if !existed && !skipCheckingDataDir {
-		errDataNotFound(dataDir)
+		errDataNotFound(dataDir, source, "names.dmp")
 	}
 
-	delNodesFile := filepath.Join(dataDir, "delnodes.dmp")
-	mergedFile := filepath.Join(dataDir, "merged.dmp")
+	delNodesFile := dumpFile(dataDir, "delnodes.dmp")
+	mergedFile := dumpFile(dataDir, "merged.dmp")
+
+	setMapInitialSize(getFlagNonNegativeInt(cmd, "map-size-hint"), nodesFile, getFlagBool(cmd, "verbose"))
 
 	return Config{
 		Threads:      threads,
-		OutFile:      getFlagString(cmd, "out-file"),
+		OutFile:      outFile,
 		DataDir:      dataDir,
 		NodesFile:    nodesFile,
 		NamesFile:    namesFile,
@@ -137,17 +229,20 @@ func getFlagTaxonIDs(cmd *cobra.Command, flag string) []int {
 	}
 	idStrSlice := strings.Split(s, ",")
 	ids := make([]int, len(idStrSlice))
-	var id int
+	var id uint32
 	for i, s := range idStrSlice {
-		id, _ = strconv.Atoi(s)
-		ids[i] = id
+		id, err = taxonomy.ParseTaxId(s)
+		if err != nil {
+			checkError(fmt.Errorf("invalid value of flag %s: %s", flag, err))
+		}
+		ids[i] = int(id)
 	}
 	return ids
 }
 
 func getTaxonIDs(files []string) []int {
 	ids := make([]int, 0, 1024)
-	var id int
+	var id uint32
 	var line string
 	for _, file := range files {
 		if isStdin(file) && !xopen.IsStdin() {
@@ -162,12 +257,13 @@ func getTaxonIDs(files []string) []int {
 			if line == "" {
 				continue
 			}
-			id, err = strconv.Atoi(line)
+			id, err = taxonomy.ParseTaxId(line)
 			if err != nil {
+				checkTaxIdRange(err)
 				continue
 			}
 
-			ids = append(ids, id)
+			ids = append(ids, int(id))
 		}
 		if err := scanner.Err(); err != nil {
 			checkError(err)
@@ -179,6 +275,51 @@ func getTaxonIDs(files []string) []int {
 	return ids
 }
 
+// readTaxonIDsFromFile reads TaxIds from a file, one TaxId per line.
+// Blank lines and lines starting with "#" are ignored.
+func readTaxonIDsFromFile(file string) []int {
+	ids := make([]int, 0, 1024)
+	fh, err := xopen.Ropen(file)
+	checkError(err)
+
+	scanner := bufio.NewScanner(fh)
+	var line string
+	var id uint32
+	for scanner.Scan() {
+		line = strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		id, err = taxonomy.ParseTaxId(line)
+		if err != nil {
+			checkError(fmt.Errorf("invalid TaxId in file %s: %s", file, line))
+		}
+
+		ids = append(ids, int(id))
+	}
+	if err := scanner.Err(); err != nil {
+		checkError(err)
+	}
+
+	checkError(fh.Close())
+	return ids
+}
+
+// uniqueInts removes duplicated values, keeping the order of first appearance.
+func uniqueInts(list []int) []int {
+	seen := make(map[int]interface{}, len(list))
+	s := list[:0]
+	var ok bool
+	for _, v := range list {
+		if _, ok = seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		s = append(s, v)
+	}
+	return s
+}
+
 func makeOutDir(outDir string, force bool) {
 	pwd, _ := os.Getwd()
 	if outDir != "./" && outDir != "." && pwd != filepath.Clean(outDir) {
@@ -0,0 +1,121 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/spf13/cobra"
+)
+
+// maxCompletions is the maximum number of TaxIds suggested in shell completion.
+const maxCompletions = 100
+
+// completeTaxIds completes values of flags of comma-separated TaxIds, e.g.,
+// --ids, via the hidden command "__complete" of cobra. The last value is
+// matched as the prefix of TaxIds if it consists of digits, or the prefix of
+// scientific names (case-insensitive) otherwise. Candidates are TaxIds,
+// described by their scientific names.
+func completeTaxIds(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var given string
+	if i := strings.LastIndexByte(toComplete, ','); i >= 0 {
+		given, toComplete = toComplete[:i+1], toComplete[i+1:]
+	}
+
+	names := completionNames(cmd)
+	if len(names) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var byTaxId bool
+	if toComplete != "" {
+		_, err := strconv.ParseUint(toComplete, 10, 32)
+		byTaxId = err == nil
+	}
+	prefix := strings.ToLower(toComplete)
+
+	taxids := make([]uint32, 0, 128)
+	for taxid, name := range names {
+		if byTaxId {
+			if !strings.HasPrefix(strconv.Itoa(int(taxid)), toComplete) {
+				continue
+			}
+		} else if len(name) < len(prefix) || strings.ToLower(name[:len(prefix)]) != prefix {
+			continue
+		}
+		taxids = append(taxids, taxid)
+	}
+	sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+	if len(taxids) > maxCompletions {
+		taxids = taxids[:maxCompletions]
+	}
+
+	completions := make([]string, len(taxids))
+	for i, taxid := range taxids {
+		completions[i] = given + strconv.Itoa(int(taxid)) + "\t" + names[taxid]
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionNames returns scientific names for shell completion, from the
+// binary index if it is present and up to date, or names.dmp otherwise.
+// Unlike getConfigs, nothing is checked or created, and nil is returned on
+// errors, as completion should fail silently.
+func completionNames(cmd *cobra.Command) map[uint32]string {
+	dataDir := getFlagString(cmd, "data-dir")
+	if val := os.Getenv("TAXONKIT_DB"); val != "" && !cmd.Flags().Lookup("data-dir").Changed {
+		dataDir = val
+	}
+
+	var config Config
+	var err error
+	config.DataDir = dataDir
+	if config.NodesFile, err = taxonomy.DumpFile(dataDir, "nodes.dmp"); err != nil {
+		return nil
+	}
+	if config.NamesFile, err = taxonomy.DumpFile(dataDir, "names.dmp"); err != nil {
+		return nil
+	}
+	if config.DelNodesFile, err = taxonomy.DumpFile(dataDir, "delnodes.dmp"); err != nil {
+		return nil
+	}
+	if config.MergedFile, err = taxonomy.DumpFile(dataDir, "merged.dmp"); err != nil {
+		return nil
+	}
+
+	if !taxonomy.IsArchive(dataDir) {
+		if checksum, err := dumpChecksum(config); err == nil {
+			if idx, err := readIndex(indexFile(config), checksum); err == nil {
+				return idx.Names
+			}
+		}
+	}
+
+	names, err := taxonomy.ReadNames(config.NamesFile)
+	if err != nil {
+		return nil
+	}
+	return names
+}
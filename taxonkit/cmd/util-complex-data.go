@@ -22,31 +22,40 @@ package cmd
 
 import (
 	"bufio"
-	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/stringutil"
-	"github.com/shenwei356/xopen"
 )
 
 // ----------------------------------  name2taxid ---------------------------
 
 // names -> []taxid
-func getTaxonName2Taxids(file string, limit2SciName bool) map[string][]uint32 {
-	fh, err := xopen.Ropen(file)
+// getTaxonName2Taxids returns lowercase name -> taxids.
+// If nameClasses is not empty, only names of these classes are kept, and
+// the name classes are also returned as lowercase name -> taxid -> class.
+func getTaxonName2Taxids(file string, limit2SciName bool, nameClasses map[string]interface{}) (
+	map[string][]uint32, map[string]map[uint32]string) {
+	fh, err := taxonomy.Open(file)
 	checkError(err)
 	defer func() {
 		checkError(fh.Close())
 	}()
 
 	name2taxids := make(map[string][]uint32, mapInitialSize)
+	var name2classes map[string]map[uint32]string
+	recordClass := len(nameClasses) > 0
+	if recordClass {
+		name2classes = make(map[string]map[uint32]string, mapInitialSize)
+	}
 
 	items := make([]string, 8)
 	scanner := bufio.NewScanner(fh)
-	var id int
+	var id uint32
 	var name string
 	var ok bool
+	var class string
 	for scanner.Scan() {
 		stringSplitN(scanner.Text(), "\t", 8, &items)
 		if len(items) < 7 {
@@ -62,28 +71,114 @@ func getTaxonName2Taxids(file string, limit2SciName bool) map[string][]uint32 {
 		// 		continue
 		// 	}
 		// }
+		if recordClass {
+			if _, ok = nameClasses[items[6]]; !ok {
+				continue
+			}
+		}
 		name = items[2]
 
-		id, err = strconv.Atoi(items[0])
+		id, err = taxonomy.ParseTaxId(items[0])
 		if err != nil {
+			checkTaxIdRange(err)
 			continue
 		}
 
 		// -------------
 
 		name = strings.ToLower(name)
+
+		if recordClass {
+			if _, ok = name2classes[name]; !ok {
+				name2classes[name] = make(map[uint32]string, 1)
+			}
+			if class, ok = name2classes[name][id]; ok { // the same name in different classes
+				name2classes[name][id] = class + "," + items[6]
+				continue
+			}
+			name2classes[name][id] = items[6]
+		}
+
 		if _, ok = name2taxids[name]; !ok {
-			name2taxids[name] = []uint32{uint32(id)}
+			name2taxids[name] = []uint32{id}
 		} else {
-			name2taxids[name] = append(name2taxids[name], uint32(id))
+			name2taxids[name] = append(name2taxids[name], id)
+		}
+
+	}
+	if err := scanner.Err(); err != nil {
+		checkError(err)
+	}
+
+	return name2taxids, name2classes
+}
+
+// taxonName is a record in names.dmp.
+type taxonName struct {
+	Name  string
+	TaxId uint32
+	Class string
+}
+
+// getTaxonNameRecords returns records of names.dmp in the original case,
+// only scientific names with limit2SciName, or names of given classes.
+// Classes of the same name of a TaxId are joined with ",".
+func getTaxonNameRecords(file string, limit2SciName bool, nameClasses map[string]interface{}) []taxonName {
+	fh, err := taxonomy.Open(file)
+	checkError(err)
+	defer func() {
+		checkError(fh.Close())
+	}()
+
+	records := make([]taxonName, 0, mapInitialSize)
+	// names of the current TaxId -> index in records, as records of a TaxId are adjacent
+	name2idx := make(map[string]int, 8)
+	var preTaxid uint32
+
+	items := make([]string, 8)
+	scanner := bufio.NewScanner(fh)
+	var taxid uint32
+	var i int
+	var ok bool
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 8, &items)
+		if len(items) < 7 {
+			continue
+		}
+		if limit2SciName && items[6] != "scientific name" {
+			continue
+		}
+		if len(nameClasses) > 0 {
+			if _, ok = nameClasses[items[6]]; !ok {
+				continue
+			}
+		}
+
+		taxid, err = taxonomy.ParseTaxId(items[0])
+		if err != nil {
+			checkTaxIdRange(err)
+			continue
+		}
+
+		if taxid != preTaxid {
+			for k := range name2idx {
+				delete(name2idx, k)
+			}
+			preTaxid = taxid
+		}
+		if i, ok = name2idx[items[2]]; ok { // the same name in different classes
+			records[i].Class += "," + items[6]
+			continue
 		}
+		name2idx[items[2]] = len(records)
 
+		records = append(records, taxonName{Name: items[2], TaxId: taxid, Class: items[6]})
 	}
 	if err := scanner.Err(); err != nil {
 		checkError(err)
 	}
 
-	return name2taxids
+	return records
 }
 
 // ----------------------------------  taxid-changelog ---------------------------
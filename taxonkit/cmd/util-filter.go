@@ -30,6 +30,7 @@ import (
 	"sync"
 
 	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
 )
 
@@ -47,6 +48,8 @@ type rankFilter struct {
 	oHigher int
 	oEquals map[int]interface{}
 
+	equalNoRanks map[string]interface{} // ranks without order given by equals
+
 	limitLower  bool
 	limitHigher bool
 	limitEqual  bool
@@ -57,21 +60,61 @@ type rankFilter struct {
 	discardNorank   bool
 	saveKnownNoRank bool
 
-	cache map[uint32]bool
+	cache map[uint32]filterResult
 }
 
-func loadTaxonomy(opt *Config, withRank bool) *taxdump.Taxonomy {
+// filterResult is the result of checking a TaxId with rankFilter,
+// i.e., passed or the reason why it is discarded.
+type filterResult uint8
+
+const (
+	filterPassed      filterResult = iota
+	filterNotFound                 // TaxId not found or deleted
+	filterBlackListed              // rank in the black list
+	filterNoRank                   // rank without order
+	filterNotEqual                 // rank not given by -E/--equal-to
+	filterTooHigh                  // rank not lower than -L/--lower-than
+	filterTooLow                   // rank not higher than -H/--higher-than
+)
+
+// filterResults are all filterResults, in order.
+var filterResults = []filterResult{filterPassed, filterNotFound, filterBlackListed,
+	filterNoRank, filterNotEqual, filterTooHigh, filterTooLow}
+
+func (r filterResult) String() string {
+	switch r {
+	case filterPassed:
+		return "passed"
+	case filterNotFound:
+		return "unfound"
+	case filterBlackListed:
+		return "black-listed rank"
+	case filterNoRank:
+		return "no rank"
+	case filterNotEqual:
+		return "rank not equal"
+	case filterTooHigh:
+		return "rank too high"
+	case filterTooLow:
+		return "rank too low"
+	}
+	return "unknown"
+}
 
+// loadTaxonomy loads nodes.dmp, and delnodes.dmp and merged.dmp unless nodesOnly.
+func loadTaxonomy(opt *Config, withRank bool, nodesOnly bool) *taxdump.Taxonomy {
 	if opt.Verbose {
 		log.Infof("loading Taxonomy from: %s", opt.DataDir)
 	}
 	var t *taxdump.Taxonomy
-	var err error
-	if withRank {
-		t, err = taxdump.NewTaxonomyWithRankFromNCBI(filepath.Join(opt.DataDir, "nodes.dmp"))
-	} else {
-		t, err = taxdump.NewTaxonomyFromNCBI(filepath.Join(opt.DataDir, "nodes.dmp"))
-	}
+	err := withLocalDumpFile(opt.NodesFile, func(file string) (err error) {
+		if withRank {
+			t, err = taxdump.NewTaxonomyWithRankFromNCBI(file)
+		} else {
+			t, err = taxdump.NewTaxonomyFromNCBI(file)
+		}
+		return err
+	})
 	if err != nil {
 		checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
 	}
@@ -83,6 +126,11 @@ func loadTaxonomy(opt *Config, withRank bool) *taxdump.Taxonomy {
 		}
 	}
 
+	if nodesOnly {
+		t.CacheLCA()
+		return t
+	}
+
 	var existed bool
 
 	var wg sync.WaitGroup
@@ -90,12 +138,12 @@ func loadTaxonomy(opt *Config, withRank bool) *taxdump.Taxonomy {
 
 	go func() {
 		defer wg.Done()
-		existed, err = pathutil.Exists(filepath.Join(opt.DataDir, "delnodes.dmp"))
+		existed, err = taxonomy.Exists(opt.DelNodesFile)
 		if err != nil {
-			checkError(fmt.Errorf("err on checking file merged.dmp: %s", err))
+			checkError(fmt.Errorf("err on checking file delnodes.dmp: %s", err))
 		}
 		if existed {
-			err = t.LoadDeletedNodesFromNCBI(filepath.Join(opt.DataDir, "delnodes.dmp"))
+			err = withLocalDumpFile(opt.DelNodesFile, t.LoadDeletedNodesFromNCBI)
 			if err != nil {
 				checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
 			}
@@ -107,12 +155,12 @@ func loadTaxonomy(opt *Config, withRank bool) *taxdump.Taxonomy {
 
 	go func() {
 		defer wg.Done()
-		existed, err = pathutil.Exists(filepath.Join(opt.DataDir, "merged.dmp"))
+		existed, err = taxonomy.Exists(opt.MergedFile)
 		if err != nil {
 			checkError(fmt.Errorf("err on checking file merged.dmp: %s", err))
 		}
 		if existed {
-			err = t.LoadMergedNodesFromNCBI(filepath.Join(opt.DataDir, "merged.dmp"))
+			err = withLocalDumpFile(opt.MergedFile, t.LoadMergedNodesFromNCBI)
 			if err != nil {
 				checkError(fmt.Errorf("err on loading Taxonomy merged nodes: %s", err))
 			}
@@ -132,10 +180,6 @@ func loadTaxonomy(opt *Config, withRank bool) *taxdump.Taxonomy {
 func newRankFilter(taxondb *taxdump.Taxonomy, rankOrder map[string]int, noRanks map[string]interface{},
 	lower string, higher string, equals []string, blackList []string, discardNorank bool, saveKnownNoRank bool) (*rankFilter, error) {
 
-	if lower != "" && higher != "" {
-		return nil, fmt.Errorf("higher and lower can't be simultaneous given")
-	}
-
 	blackListMap := make(map[string]interface{})
 	for _, r := range blackList {
 		blackListMap[r] = struct{}{}
@@ -152,7 +196,7 @@ func newRankFilter(taxondb *taxdump.Taxonomy, rankOrder map[string]int, noRanks
 		blackLists:      blackListMap,
 		discardNorank:   discardNorank,
 		saveKnownNoRank: saveKnownNoRank,
-		cache:           make(map[uint32]bool, 1024),
+		cache:           make(map[uint32]filterResult, 1024),
 	}
 
 	var err error
@@ -170,10 +214,19 @@ func newRankFilter(taxondb *taxdump.Taxonomy, rankOrder map[string]int, noRanks
 		}
 		f.limitHigher = true
 	}
+	if f.limitLower && f.limitHigher && f.oLower-f.oHigher < 2 {
+		return nil, fmt.Errorf("no ranks are lower than %s and higher than %s at the same time", lower, higher)
+	}
 	if len(equals) > 0 {
 		f.oEquals = make(map[int]interface{}, len(equals))
+		f.equalNoRanks = make(map[string]interface{})
 		var oe int
+		var ok bool
 		for _, equal := range equals {
+			if _, ok = noRanks[equal]; ok {
+				f.equalNoRanks[equal] = struct{}{}
+				continue
+			}
 			oe, err = getRankOrder(dbRanks, rankOrder, equal)
 			if err != nil {
 				return nil, err
@@ -198,9 +251,15 @@ func getRankOrder(dbRanks map[string]interface{}, rankOrder map[string]int, rank
 }
 
 func (f *rankFilter) isPassed(taxid uint32) (bool, error) {
+	result, err := f.check(taxid)
+	return result == filterPassed, err
+}
+
+// check checks a TaxId and returns filterPassed or the reason why it is discarded.
+func (f *rankFilter) check(taxid uint32) (filterResult, error) {
 	rank := f.taxondb.Rank(taxid)
 	if rank == "" {
-		return false, nil
+		return filterNotFound, nil
 	}
 
 	rank = strings.ToLower(rank)
@@ -210,22 +269,31 @@ func (f *rankFilter) isPassed(taxid uint32) (bool, error) {
 	}
 
 	if _, ok := f.blackLists[rank]; ok {
-		f.cache[taxid] = false
-		return false, nil
+		f.cache[taxid] = filterBlackListed
+		return filterBlackListed, nil
 	}
 
 	var isNoRank bool
 	_, ok := f.noRanks[rank]
 	if ok {
+		if _, ok = f.equalNoRanks[rank]; ok { // given by equals
+			f.cache[taxid] = filterPassed
+			return filterPassed, nil
+		}
+		if f.limitEqual && !(f.limitLower || f.limitHigher) { // only ranks in equals
+			f.cache[taxid] = filterNotEqual
+			return filterNotEqual, nil
+		}
+
 		if f.discardNorank {
 			isNoRank = true
 			if !f.saveKnownNoRank {
-				f.cache[taxid] = false
-				return false, nil
+				f.cache[taxid] = filterNoRank
+				return filterNoRank, nil
 			}
 		} else { // all nonrank will be outputted if !discardNorank
-			f.cache[taxid] = true
-			return true, nil
+			f.cache[taxid] = filterPassed
+			return filterPassed, nil
 		}
 	}
 
@@ -233,17 +301,17 @@ func (f *rankFilter) isPassed(taxid uint32) (bool, error) {
 	if _, ok := f.taxondb.Nodes[taxid]; !ok {
 		if _, ok = f.taxondb.DelNodes[taxid]; ok {
 			log.Warningf("taxid %d was deleted", taxid)
-			return false, nil
+			return filterNotFound, nil
 		} else if newtaxid, ok := f.taxondb.MergeNodes[taxid]; ok {
 			log.Warningf("taxid %d was merged into %d", taxid, newtaxid)
 			taxid = newtaxid
 		} else {
 			log.Warningf("taxid %d not found", taxid)
-			return false, nil
+			return filterNotFound, nil
 		}
 	}
 
-	var pass bool
+	var result filterResult
 
 	if isNoRank && f.limitLower && f.saveKnownNoRank {
 		nodes := f.taxondb.Nodes
@@ -254,17 +322,23 @@ func (f *rankFilter) isPassed(taxid uint32) (bool, error) {
 		parent := nodes[taxid]
 		for {
 			if parent == 1 {
-				f.cache[taxid] = false
-				return false, nil
+				f.cache[taxid] = filterNoRank
+				return filterNoRank, nil
 			}
 
 			_rank = f.taxondb.Rank(parent)
 			_order, _ok = f.rankOrder[_rank]
 			if _ok {
-				pass = _order <= f.oLower
-
-				f.cache[taxid] = pass
-				return pass, nil
+				if _order > f.oLower {
+					result = filterTooHigh
+				} else if f.limitHigher && _order <= f.oHigher {
+					result = filterTooLow
+				} else {
+					result = filterPassed
+				}
+
+				f.cache[taxid] = result
+				return result, nil
 			}
 			parent = nodes[parent]
 		}
@@ -277,25 +351,21 @@ func (f *rankFilter) isPassed(taxid uint32) (bool, error) {
 	// }
 
 	if f.limitEqual {
-		if _, pass = f.oEquals[order]; pass {
-			// pass = true
-		} else if f.limitLower {
-			pass = order < f.oLower
-		} else if f.limitHigher {
-			pass = order > f.oHigher
+		if _, ok = f.oEquals[order]; ok {
+			result = filterPassed
+		} else if f.limitLower || f.limitHigher {
+			result = f.checkRange(order)
 		} else {
-			pass = false
+			result = filterNotEqual
 		}
-	} else if f.limitLower {
-		pass = order < f.oLower
-	} else if f.limitHigher {
-		pass = order > f.oHigher
+	} else if f.limitLower || f.limitHigher {
+		result = f.checkRange(order)
 	} else {
-		pass = true // no any filter
+		result = filterPassed // no any filter
 	}
 
-	f.cache[taxid] = pass
-	return pass, nil
+	f.cache[taxid] = result
+	return result, nil
 }
 
 func readRankOrderFromFile(file string) (map[string]int, map[string]interface{}, error) {
@@ -366,7 +436,11 @@ func readRankOrder(opt Config, rankFile string) (map[string]int, map[string]inte
 		return readRankOrderFromFile(rankFile)
 	}
 
-	defaultRankFile := filepath.Join(opt.DataDir, defaultRanksFile)
+	dir := opt.DataDir
+	if taxonomy.IsArchive(dir) { // the directory containing the archive
+		dir = filepath.Dir(dir)
+	}
+	defaultRankFile := filepath.Join(dir, defaultRanksFile)
 	existed, err := pathutil.Exists(defaultRankFile)
 	if err != nil {
 		return nil, nil, fmt.Errorf("check default rank file: %s", defaultRankFile)
@@ -500,3 +574,16 @@ subform,subforma
 strain
 isolate
 `
+
+// checkRange checks if a rank order is lower than the lower bound and/or
+// higher than the higher bound, and returns which bound is violated if not.
+// Both bounds are exclusive.
+func (f *rankFilter) checkRange(order int) filterResult {
+	if f.limitLower && order >= f.oLower {
+		return filterTooHigh
+	}
+	if f.limitHigher && order <= f.oHigher {
+		return filterTooLow
+	}
+	return filterPassed
+}
@@ -0,0 +1,268 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/util/pathutil"
+)
+
+// indexFileName is the name of binary index file in the data directory.
+const indexFileName = "taxonkit.idx"
+
+// indexFormatVersion should be increased when the format is changed.
+const indexFormatVersion = 1
+
+// taxdumpIndexHeader is written before the data, so outdated index
+// can be detected without decoding the whole file.
+type taxdumpIndexHeader struct {
+	Version  int
+	Checksum uint64
+}
+
+// taxdumpIndex contains all the data parsed from taxdump files.
+type taxdumpIndex struct {
+	Checksum uint64
+
+	Tree     map[uint32]uint32 // child -> parent
+	Ranks    map[uint32]string
+	Names    map[uint32]string
+	DelNodes []uint32
+	Merged   map[uint32]uint32
+}
+
+func indexFile(config Config) string {
+	return filepath.Join(config.DataDir, indexFileName)
+}
+
+// dumpChecksum computes a checksum from sizes and modification times of
+// the dump files, which is much faster than hashing the file contents.
+func dumpChecksum(config Config) (uint64, error) {
+	h := xxhash.New()
+	for _, file := range []string{config.NodesFile, config.NamesFile, config.DelNodesFile, config.MergedFile} {
+		h.WriteString(filepath.Base(file))
+		info, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				h.WriteString("-")
+				continue
+			}
+			return 0, err
+		}
+		h.WriteString(strconv.FormatInt(info.Size(), 10))
+		h.WriteString(strconv.FormatInt(info.ModTime().UnixNano(), 10))
+	}
+	return h.Sum64(), nil
+}
+
+// buildIndex parses all taxdump files.
+func buildIndex(config Config) *taxdumpIndex {
+	checksum, err := dumpChecksum(config)
+	checkError(err)
+
+	idx := &taxdumpIndex{Checksum: checksum}
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		if config.Verbose {
+			log.Infof("parsing nodes file: %s", config.NodesFile)
+		}
+		idx.Tree, idx.Ranks = getNodes(config.NodesFile, true)
+		wg.Done()
+	}()
+	go func() {
+		if config.Verbose {
+			log.Infof("parsing names file: %s", config.NamesFile)
+		}
+		idx.Names = getTaxonNames(config.NamesFile)
+		wg.Done()
+	}()
+	go func() {
+		if config.Verbose {
+			log.Infof("parsing delnodes file: %s", config.DelNodesFile)
+		}
+		delnodes := getDelnodesMap(config.DelNodesFile)
+		idx.DelNodes = make([]uint32, 0, len(delnodes))
+		for taxid := range delnodes {
+			idx.DelNodes = append(idx.DelNodes, taxid)
+		}
+		wg.Done()
+	}()
+	go func() {
+		if config.Verbose {
+			log.Infof("parsing merged file: %s", config.MergedFile)
+		}
+		idx.Merged = getMergedNodesMap(config.MergedFile)
+		wg.Done()
+	}()
+	wg.Wait()
+
+	return idx
+}
+
+// delNodesMap returns deleted TaxIds in a map.
+func (idx *taxdumpIndex) delNodesMap() map[uint32]struct{} {
+	delnodes := make(map[uint32]struct{}, len(idx.DelNodes))
+	for _, taxid := range idx.DelNodes {
+		delnodes[taxid] = struct{}{}
+	}
+	return delnodes
+}
+
+// createIndexTempFile creates a temporary file in the directory of the index file,
+// which also tells whether the directory is writable.
+func createIndexTempFile(file string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(file), indexFileName+".*.tmp")
+}
+
+// write saves the index to a file.
+func (idx *taxdumpIndex) write(file string) error {
+	fh, err := createIndexTempFile(file)
+	if err != nil {
+		return err
+	}
+	return idx.writeTo(fh, file)
+}
+
+// writeTo saves the index to a temporary file, and then renames it to the
+// index file, so other processes never see a half-written index.
+func (idx *taxdumpIndex) writeTo(fh *os.File, file string) error {
+	tmp := fh.Name()
+	fail := func(err error) error {
+		fh.Close()
+		os.Remove(tmp)
+		return errors.Wrap(err, file)
+	}
+
+	w := bufio.NewWriterSize(fh, 1<<20)
+	enc := gob.NewEncoder(w)
+	err := enc.Encode(taxdumpIndexHeader{Version: indexFormatVersion, Checksum: idx.Checksum})
+	if err != nil {
+		return fail(err)
+	}
+	if err = enc.Encode(idx); err != nil {
+		return fail(err)
+	}
+	if err = w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err = fh.Chmod(0644); err != nil { // temporary files are only readable by the owner
+		return fail(err)
+	}
+	if err = fh.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, file)
+	}
+
+	if err = os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, file)
+	}
+	return nil
+}
+
+// warn only once if an outdated index can not be rebuilt
+var onceIndexNotWritable sync.Once
+
+// loadIndex loads the index file in the data directory if it exists.
+// An outdated or broken index is rebuilt, and nil is returned if no index is found,
+// the data directory is an archive, or the index can not be rebuilt as the
+// directory is not writable, where callers parse the needed dump files instead.
+func loadIndex(config Config) *taxdumpIndex {
+	if taxonomy.IsArchive(config.DataDir) { // no index for archives
+		return nil
+	}
+	file := indexFile(config)
+	existed, err := pathutil.Exists(file)
+	checkError(err)
+	if !existed {
+		return nil
+	}
+
+	checksum, err := dumpChecksum(config)
+	checkError(err)
+
+	idx, err := readIndex(file, checksum)
+	if err == nil {
+		if config.Verbose {
+			log.Infof("index loaded: %s", file)
+		}
+		return idx
+	}
+
+	// rebuilding the whole index is slower than parsing only the needed dump files,
+	// so it's only done when the index can be saved.
+	fh, errTmp := createIndexTempFile(file)
+	if errTmp != nil {
+		onceIndexNotWritable.Do(func() {
+			log.Warningf("%s, but can not be rebuilt: %s. please rerun \"taxonkit index\" with write permission", err, errTmp)
+		})
+		return nil
+	}
+
+	log.Warningf("rebuilding index: %s", err)
+	idx = buildIndex(config)
+	if err = idx.writeTo(fh, file); err != nil {
+		log.Warningf("failed to save index: %s", err)
+	}
+	return idx
+}
+
+var errIndexOutdated = fmt.Errorf("index outdated")
+
+// readIndex reads an index file, and checks the format version and checksum.
+func readIndex(file string, checksum uint64) (*taxdumpIndex, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	dec := gob.NewDecoder(bufio.NewReaderSize(fh, 1<<20))
+
+	var header taxdumpIndexHeader
+	if err = dec.Decode(&header); err != nil {
+		return nil, errors.Wrap(err, file)
+	}
+	if header.Version != indexFormatVersion || header.Checksum != checksum {
+		return nil, errors.Wrap(errIndexOutdated, file)
+	}
+
+	idx := &taxdumpIndex{}
+	if err = dec.Decode(idx); err != nil {
+		return nil, errors.Wrap(err, file)
+	}
+	if idx.Checksum != checksum {
+		return nil, errors.Wrap(errIndexOutdated, file)
+	}
+	return idx, nil
+}
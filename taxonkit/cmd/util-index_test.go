@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndexWrite(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, indexFileName)
+
+	idx := &taxdumpIndex{
+		Checksum: 123,
+		Tree:     map[uint32]uint32{1: 1, 2: 1, 3: 2},
+		Ranks:    map[uint32]string{1: "no rank", 2: "superkingdom", 3: "phylum"},
+		Names:    map[uint32]string{1: "root", 2: "Bacteria", 3: "Bacillota"},
+		DelNodes: []uint32{4},
+		Merged:   map[uint32]uint32{5: 3},
+	}
+	for i := 0; i < 2; i++ { // the second one replaces the existing index
+		if err := idx.write(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readIndex(file, 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, idx) {
+		t.Errorf("got %+v, want %+v", got, idx)
+	}
+	if _, err = readIndex(file, 456); err == nil {
+		t.Errorf("an error is expected for an outdated index")
+	}
+
+	// no temporary files are left
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("only the index file is expected, got %d files", len(files))
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("unexpected permission of the index file: %s", info.Mode().Perm())
+	}
+}
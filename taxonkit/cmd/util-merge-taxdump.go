@@ -0,0 +1,442 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
+)
+
+// dumpName is a record in names.dmp.
+type dumpName struct {
+	Name   string
+	Unique string
+	Class  string
+}
+
+// taxdumpSource holds all records of a taxdump directory.
+type taxdumpSource struct {
+	Dir      string
+	Nodes    map[uint32]uint32 // child -> parent
+	Ranks    map[uint32]string
+	Names    map[uint32][]dumpName
+	SciNames map[uint32]string
+	Merged   map[uint32]uint32
+	DelNodes map[uint32]struct{}
+}
+
+func loadTaxdumpSource(dir string) *taxdumpSource {
+	existed, err := pathutil.DirExists(dir)
+	checkError(err)
+	if !existed {
+		checkError(fmt.Errorf("taxdump directory not found: %s", dir))
+	}
+
+	s := &taxdumpSource{Dir: dir}
+	s.Nodes, s.Ranks = getNodes(dumpFile(dir, "nodes.dmp"), true)
+	s.Names, s.SciNames = getAllTaxonNames(dumpFile(dir, "names.dmp"))
+	s.Merged = getMergedNodesMap(dumpFile(dir, "merged.dmp"))
+	s.DelNodes = getDelnodesMap(dumpFile(dir, "delnodes.dmp"))
+	return s
+}
+
+// taxid -> names of all classes, taxid -> scientific name
+func getAllTaxonNames(file string) (map[uint32][]dumpName, map[uint32]string) {
+	fh, err := xopen.Ropen(file)
+	checkError(err)
+	defer func() {
+		checkError(fh.Close())
+	}()
+
+	taxid2names := make(map[uint32][]dumpName, mapInitialSize)
+	taxid2name := make(map[uint32]string, mapInitialSize)
+
+	items := make([]string, 8)
+	scanner := bufio.NewScanner(fh)
+	var id uint32
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 8, &items)
+		if len(items) < 8 {
+			continue
+		}
+		id, err = taxonomy.ParseTaxId(items[0])
+		if err != nil {
+			checkTaxIdRange(err)
+			continue
+		}
+
+		taxid2names[id] = append(taxid2names[id], dumpName{items[2], items[4], items[6]})
+		if items[6] == "scientific name" {
+			taxid2name[id] = items[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		checkError(err)
+	}
+
+	return taxid2names, taxid2name
+}
+
+// taxdumpConflict is a resolved conflict during merging taxdump sources.
+type taxdumpConflict struct {
+	Type       string // taxid, name, parent, merged, delnodes
+	Source     string
+	TaxId      uint32
+	Rank       string
+	Name       string
+	Resolution string
+	NewTaxId   uint32
+}
+
+// mergeTaxdumpSources merges multiple taxdump sources, which are given in
+// the order of priority, i.e., records of the first source are preferred.
+//
+// Conflicts:
+//
+//  1. taxid: the same TaxId is used by different taxa (different ranks or
+//     scientific names), or used as a merged/deleted TaxId in a preferred source.
+//     A new TaxId is assigned to the taxon of the less preferred source.
+//  2. name: the same taxon (rank and scientific name) has different TaxIds.
+//     The TaxId of the less preferred source is merged into the preferred one.
+//  3. parent: the same taxon has different parents, the preferred one is kept.
+//  4. merged/delnodes: a merged/deleted TaxId is used in a preferred source,
+//     the record is discarded.
+func mergeTaxdumpSources(sources []*taxdumpSource) (*taxdumpSource, []taxdumpConflict) {
+	m := &taxdumpSource{
+		Nodes:    make(map[uint32]uint32, mapInitialSize),
+		Ranks:    make(map[uint32]string, mapInitialSize),
+		Names:    make(map[uint32][]dumpName, mapInitialSize),
+		SciNames: make(map[uint32]string, mapInitialSize),
+		Merged:   make(map[uint32]uint32, 1<<10),
+		DelNodes: make(map[uint32]struct{}, 1<<10),
+	}
+	conflicts := make([]taxdumpConflict, 0, 64)
+
+	// rank + name -> taxids, for detecting name conflicts
+	key2taxids := make(map[string][]uint32, mapInitialSize)
+	taxonKey := func(rank, name string) string {
+		return rank + "\t" + strings.ToLower(name)
+	}
+
+	// the max TaxId of all sources, for assigning new TaxIds
+	var maxTaxid uint32
+	for _, s := range sources {
+		for taxid := range s.Nodes {
+			if taxid > maxTaxid {
+				maxTaxid = taxid
+			}
+		}
+		for taxid := range s.Merged {
+			if taxid > maxTaxid {
+				maxTaxid = taxid
+			}
+		}
+		for taxid := range s.DelNodes {
+			if taxid > maxTaxid {
+				maxTaxid = taxid
+			}
+		}
+	}
+
+	used := func(taxid uint32) bool {
+		if _, ok := m.Nodes[taxid]; ok {
+			return true
+		}
+		if _, ok := m.Merged[taxid]; ok {
+			return true
+		}
+		_, ok := m.DelNodes[taxid]
+		return ok
+	}
+
+	var ok bool
+	var taxid, parent, newTaxid uint32
+	var taxids []uint32
+	for _, s := range sources {
+		cs := make([]taxdumpConflict, 0, 64)
+
+		// sorted TaxIds for deterministic results
+		taxids = make([]uint32, 0, len(s.Nodes))
+		for taxid = range s.Nodes {
+			taxids = append(taxids, taxid)
+		}
+		sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+
+		// ------------------------------------------------------------
+		// TaxId of the source -> TaxId in the merged taxdump
+
+		mapping := make(map[uint32]uint32, len(s.Nodes))
+		matched := make(map[uint32]bool, len(s.Nodes))
+		toReassign := make([]uint32, 0, 64)
+		for _, taxid = range taxids {
+			if parent = s.Nodes[taxid]; parent == taxid { // root
+				mapping[taxid] = 1
+				matched[taxid] = true
+				continue
+			}
+
+			if _taxids := key2taxids[taxonKey(s.Ranks[taxid], s.SciNames[taxid])]; len(_taxids) == 1 { // the same taxon
+				mapping[taxid] = _taxids[0]
+				matched[taxid] = true
+
+				if _taxids[0] != taxid {
+					cs = append(cs, taxdumpConflict{"name", s.Dir, taxid, s.Ranks[taxid], s.SciNames[taxid], "merged", _taxids[0]})
+				}
+				continue
+			}
+
+			if used(taxid) {
+				toReassign = append(toReassign, taxid)
+				continue
+			}
+
+			mapping[taxid] = taxid
+		}
+
+		for _, taxid = range toReassign {
+			for {
+				maxTaxid++
+				if !used(maxTaxid) {
+					if _, ok = s.Nodes[maxTaxid]; !ok {
+						break
+					}
+				}
+			}
+			mapping[taxid] = maxTaxid
+			cs = append(cs, taxdumpConflict{"taxid", s.Dir, taxid, s.Ranks[taxid], s.SciNames[taxid], "reassigned", maxTaxid})
+		}
+
+		// ------------------------------------------------------------
+		// nodes and names
+
+		// taxa in the same source are not compared by names
+		keys := make(map[string][]uint32, len(s.Nodes))
+
+		for _, taxid = range taxids {
+			newTaxid = mapping[taxid]
+			parent = mapping[s.Nodes[taxid]]
+
+			if matched[taxid] {
+				if _, ok = m.Nodes[newTaxid]; !ok { // root of the first source
+					m.Nodes[newTaxid] = newTaxid
+					m.Ranks[newTaxid] = s.Ranks[taxid]
+					m.Names[newTaxid] = s.Names[taxid]
+					m.SciNames[newTaxid] = s.SciNames[taxid]
+					continue
+				}
+
+				if newTaxid != 1 && m.Nodes[newTaxid] != parent {
+					cs = append(cs, taxdumpConflict{"parent", s.Dir, taxid, s.Ranks[taxid], s.SciNames[taxid], "kept", newTaxid})
+				}
+
+				// other names
+				for _, name := range s.Names[taxid] {
+					if name.Class == "scientific name" || hasDumpName(m.Names[newTaxid], name) {
+						continue
+					}
+					m.Names[newTaxid] = append(m.Names[newTaxid], name)
+				}
+				continue
+			}
+
+			m.Nodes[newTaxid] = parent
+			m.Ranks[newTaxid] = s.Ranks[taxid]
+			m.Names[newTaxid] = s.Names[taxid]
+			m.SciNames[newTaxid] = s.SciNames[taxid]
+
+			key := taxonKey(s.Ranks[taxid], s.SciNames[taxid])
+			keys[key] = append(keys[key], newTaxid)
+		}
+		for key, _taxids := range keys {
+			key2taxids[key] = append(key2taxids[key], _taxids...)
+		}
+
+		// the old TaxIds of merged taxa still point to the preferred ones
+		for _, taxid = range taxids {
+			if !matched[taxid] || mapping[taxid] == taxid || used(taxid) {
+				continue
+			}
+			m.Merged[taxid] = mapping[taxid]
+		}
+
+		// ------------------------------------------------------------
+		// merged.dmp and delnodes.dmp
+
+		for from, to := range s.Merged {
+			if used(from) {
+				if m.Merged[from] != mapping[to] {
+					cs = append(cs, taxdumpConflict{"merged", s.Dir, from, "", "", "discarded", 0})
+				}
+				continue
+			}
+			if newTaxid, ok = mapping[to]; !ok { // the new TaxId does not exist
+				continue
+			}
+			m.Merged[from] = newTaxid
+		}
+
+		for taxid = range s.DelNodes {
+			if used(taxid) {
+				if _, ok = m.DelNodes[taxid]; !ok {
+					cs = append(cs, taxdumpConflict{"delnodes", s.Dir, taxid, "", "", "discarded", 0})
+				}
+				continue
+			}
+			m.DelNodes[taxid] = struct{}{}
+		}
+
+		sort.Slice(cs, func(i, j int) bool {
+			if cs[i].Type == cs[j].Type {
+				return cs[i].TaxId < cs[j].TaxId
+			}
+			return cs[i].Type < cs[j].Type
+		})
+		conflicts = append(conflicts, cs...)
+	}
+
+	// chaining merging: A -> B, B -> C
+	var to uint32
+	var n int
+	for from := range m.Merged {
+		to = m.Merged[from]
+		n = 0
+		for {
+			if _, ok = m.Nodes[to]; ok {
+				break
+			}
+			if to, ok = m.Merged[to]; !ok || n > len(m.Merged) {
+				break
+			}
+			n++
+		}
+		if _, ok = m.Nodes[to]; ok {
+			m.Merged[from] = to
+		} else {
+			delete(m.Merged, from)
+			m.DelNodes[from] = struct{}{}
+		}
+	}
+
+	return m, conflicts
+}
+
+func hasDumpName(names []dumpName, name dumpName) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTaxdump writes nodes.dmp, names.dmp, merged.dmp, and delnodes.dmp.
+func writeTaxdump(s *taxdumpSource, outDir string) {
+	taxids := make([]uint32, 0, len(s.Nodes))
+	for taxid := range s.Nodes {
+		taxids = append(taxids, taxid)
+	}
+	sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+
+	// ------------------------------- nodes.dmp -------------------------
+
+	fileNodes := filepath.Join(outDir, "nodes.dmp")
+	outfhNodes, err := xopen.Wopen(fileNodes)
+	checkError(err)
+	for _, taxid := range taxids {
+		fmt.Fprintf(outfhNodes, "%d\t|\t%d\t|\t%s\t|\t%s\t|\t0\t|\t1\t|\t11\t|\t1\t|\t0\t|\t1\t|\t1\t|\t0\t|\t\t|\n", taxid, s.Nodes[taxid], s.Ranks[taxid], "XX")
+	}
+	checkError(outfhNodes.Close())
+	log.Infof("%d records saved to %s", len(taxids), fileNodes)
+
+	// ------------------------------- names.dmp -------------------------
+
+	fileNames := filepath.Join(outDir, "names.dmp")
+	outfhNames, err := xopen.Wopen(fileNames)
+	checkError(err)
+	var n int
+	for _, taxid := range taxids {
+		for _, name := range s.Names[taxid] {
+			fmt.Fprintf(outfhNames, "%d\t|\t%s\t|\t%s\t|\t%s\t|\n", taxid, name.Name, name.Unique, name.Class)
+			n++
+		}
+	}
+	checkError(outfhNames.Close())
+	log.Infof("%d records saved to %s", n, fileNames)
+
+	// ------------------------------- merged.dmp -------------------------
+
+	taxids = taxids[:0]
+	for taxid := range s.Merged {
+		taxids = append(taxids, taxid)
+	}
+	sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+
+	fileMerged := filepath.Join(outDir, "merged.dmp")
+	outfhMerged, err := xopen.Wopen(fileMerged)
+	checkError(err)
+	for _, taxid := range taxids {
+		fmt.Fprintf(outfhMerged, "%d\t|\t%d\t|\n", taxid, s.Merged[taxid])
+	}
+	checkError(outfhMerged.Close())
+	log.Infof("%d records saved to %s", len(taxids), fileMerged)
+
+	// ------------------------------- delnodes.dmp -------------------------
+
+	taxids = taxids[:0]
+	for taxid := range s.DelNodes {
+		taxids = append(taxids, taxid)
+	}
+	sort.Slice(taxids, func(i, j int) bool { return taxids[i] > taxids[j] })
+
+	fileDelNodes := filepath.Join(outDir, "delnodes.dmp")
+	outfhDelNodes, err := xopen.Wopen(fileDelNodes)
+	checkError(err)
+	for _, taxid := range taxids {
+		fmt.Fprintf(outfhDelNodes, "%d\t|\n", taxid)
+	}
+	checkError(outfhDelNodes.Close())
+	log.Infof("%d records saved to %s", len(taxids), fileDelNodes)
+}
+
+// writeTaxdumpConflicts writes resolved conflicts to a tab-delimited file.
+func writeTaxdumpConflicts(conflicts []taxdumpConflict, file string) {
+	outfh, err := xopen.Wopen(file)
+	checkError(err)
+	defer outfh.Close()
+
+	fmt.Fprintf(outfh, "type\tsource\ttaxid\trank\tname\tresolution\tnew_taxid\n")
+	var newTaxid string
+	for _, c := range conflicts {
+		newTaxid = ""
+		if c.NewTaxId > 0 {
+			newTaxid = strconv.Itoa(int(c.NewTaxId))
+		}
+		fmt.Fprintf(outfh, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", c.Type, c.Source, c.TaxId, c.Rank, c.Name, c.Resolution, newTaxid)
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+)
+
+// checkSameFiles checks files in outDir are the same as the ones in wantDir.
+func checkSameFiles(t *testing.T, outDir string, wantDir string, files []string) {
+	for _, file := range files {
+		got, err := os.ReadFile(filepath.Join(outDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(filepath.Join(wantDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", file, got, want)
+		}
+	}
+}
+
+// TestMergeTaxdumpSources merges two taxdump directories in testdata/merge,
+// where the custom one clashes with the preferred one in all ways:
+//
+//   - taxid: 11 is used by different species, which is reassigned to 31.
+//   - name: genus Escherichia (20) and species Escherichia coli (21) have
+//     different TaxIds, which are merged into 10 and 11.
+//   - parent: phylum Pseudomonadota (3) has a different parent, which is kept.
+//   - merged: 6 deleted in the preferred one is merged, which is discarded.
+//   - delnodes: 10 used in the preferred one is deleted, which is discarded.
+func TestMergeTaxdumpSources(t *testing.T) {
+	sources := []*taxdumpSource{
+		loadTaxdumpSource(filepath.Join("testdata", "merge", "ncbi")),
+		loadTaxdumpSource(filepath.Join("testdata", "merge", "custom")),
+	}
+	merged, conflicts := mergeTaxdumpSources(sources)
+
+	outDir := t.TempDir()
+	writeTaxdump(merged, outDir)
+	writeTaxdumpConflicts(conflicts, filepath.Join(outDir, "conflicts.tsv"))
+
+	checkSameFiles(t, outDir, filepath.Join("testdata", "merge", "merged"),
+		[]string{"nodes.dmp", "names.dmp", "merged.dmp", "delnodes.dmp", "conflicts.tsv"})
+
+	// the merged taxdump is valid
+	taxdb, err := taxonomy.Load(outDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, taxid := range []uint32{20, 21} {
+		if _, status := taxdb.Resolve(taxid); status != taxonomy.Merged {
+			t.Errorf("TaxId %d should be merged, got: %s", taxid, status)
+		}
+	}
+	if lineage := taxdb.Lineage(31); len(lineage) != 4 || lineage[2] != 10 {
+		t.Errorf("unexpected lineage of the reassigned TaxId 31: %v", lineage)
+	}
+}
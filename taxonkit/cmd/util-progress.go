@@ -0,0 +1,73 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// isTerminal tells whether a file is a terminal (character device).
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar shows the number of processed items out of the total on stderr,
+// in one line refreshed at most every progressInterval.
+type progressBar struct {
+	total int
+	unit  string
+	last  time.Time
+}
+
+const progressInterval = 100 * time.Millisecond
+
+func newProgressBar(total int, unit string) *progressBar {
+	return &progressBar{total: total, unit: unit}
+}
+
+// update shows n processed items, unless it was refreshed recently.
+func (p *progressBar) update(n int) {
+	if time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+	p.print(n)
+}
+
+// finish shows that all items are processed and ends the line.
+func (p *progressBar) finish() {
+	p.print(p.total)
+	fmt.Fprintln(os.Stderr)
+}
+
+func (p *progressBar) print(n int) {
+	var percent float64
+	if p.total > 0 {
+		percent = float64(n) / float64(p.total) * 100
+	}
+	fmt.Fprintf(os.Stderr, "\rprocessed %d/%d %s (%.1f%%)", n, p.total, p.unit, percent)
+}
@@ -20,7 +20,10 @@
 
 package cmd
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+)
 
 var rankList = []string{
 	"",
@@ -99,6 +102,39 @@ var symbol2weight = map[string]float32{
 
 var reRankPlaceHolder = regexp.MustCompile(`\{(\w)\}`)
 
+// reAnyPlaceHolder matches any text in braces, for detecting invalid placeholders.
+var reAnyPlaceHolder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// parseFormat checks placeholders in the output format of reformat, and returns
+// the unique symbols of ranks in order, and whether any of "{t}", "{S}", "{T}" is used.
+func parseFormat(format string) (placeholders []string, hasStrain bool, err error) {
+	for _, placeholder := range reAnyPlaceHolder.FindAllString(format, -1) {
+		if !reRankPlaceHolder.MatchString(placeholder) {
+			return nil, false, fmt.Errorf("invalid placeholder: %s", placeholder)
+		}
+	}
+	matches := reRankPlaceHolder.FindAllStringSubmatch(format, -1)
+	if len(matches) == 0 {
+		return nil, false, fmt.Errorf("placeholder of simplified rank not found in output format: %s", format)
+	}
+	placeholders = make([]string, 0, len(matches))
+	seen := make(map[string]interface{}, len(matches))
+	for _, match := range matches {
+		if _, ok := symbol2rank[match[1]]; !ok {
+			return nil, false, fmt.Errorf("invalid placeholder: %s", match[0])
+		}
+		if _, ok := seen[match[1]]; !ok {
+			seen[match[1]] = struct{}{}
+			placeholders = append(placeholders, match[1])
+		}
+		switch match[1] {
+		case "t", "S", "T":
+			hasStrain = true
+		}
+	}
+	return placeholders, hasStrain, nil
+}
+
 var reRankPlaceHolders = map[string]*regexp.Regexp{
 	"r": regexp.MustCompile(`\{r\}`),
 	"k": regexp.MustCompile(`\{k\}`),
@@ -0,0 +1,230 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
+)
+
+// taxidMap is a persisted mapping of lineage keys to TaxIds, used by
+// create-taxdump to keep TaxIds stable across rebuilds.
+//
+// File format (tab-delimited, sorted by keys):
+//
+//	lineage-key  taxid  [deleted]
+//
+// A lineage key is made of "rank:name" of all non-null taxa from the top to
+// the taxon, joined with ";", where ranks and names are in lower case, e.g.,
+//
+//	superkingdom:bacteria;phylum:firmicutes;class:bacilli
+//
+// Like the hashed TaxIds, keys are case-insensitive, so taxa whose lineages
+// differ only in case, e.g., "Bacillus" and "bacillus" of the same parent,
+// share a key and thus a TaxId.
+//
+// Keys of taxa no longer present are kept and marked as "deleted", so their
+// TaxIds are never assigned to other taxa, and are revived if the taxa return.
+type taxidMap struct {
+	File string
+
+	Key2Taxid map[string]uint32
+	Deleted   map[string]struct{} // keys marked as deleted in the file
+	Taxids    map[uint32]struct{} // all TaxIds in use or reserved
+	Seen      map[string]struct{} // keys seen in current input
+	NLoaded   int
+}
+
+const taxidMapDeleted = "deleted"
+
+// loadTaxidMap reads the mapping file, a nonexistent file is allowed
+// and will be created by write().
+func loadTaxidMap(file string) *taxidMap {
+	m := &taxidMap{
+		File:      file,
+		Key2Taxid: make(map[string]uint32, mapInitialSize),
+		Deleted:   make(map[string]struct{}, 1024),
+		Taxids:    make(map[uint32]struct{}, mapInitialSize),
+		Seen:      make(map[string]struct{}, mapInitialSize),
+	}
+
+	existed, err := pathutil.Exists(file)
+	checkError(err)
+	if !existed {
+		return m
+	}
+
+	fh, err := xopen.Ropen(file)
+	checkError(err)
+	defer func() {
+		checkError(fh.Close())
+	}()
+
+	taxid2key := make(map[uint32]string, mapInitialSize)
+	scanner := bufio.NewScanner(fh)
+	buf := make([]byte, bufio.MaxScanTokenSize)
+	scanner.Buffer(buf, 1<<30)
+	var line, key string
+	var items []string
+	var taxid uint64
+	var _key string
+	var ok bool
+	var n int
+	for scanner.Scan() {
+		n++
+		line = strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" {
+			continue
+		}
+		items = strings.Split(line, "\t")
+		if len(items) < 2 || len(items) > 3 || (len(items) == 3 && items[2] != taxidMapDeleted) {
+			checkError(fmt.Errorf("invalid TaxId map record at line %d: %s", n, line))
+		}
+
+		key = items[0]
+		taxid, err = strconv.ParseUint(items[1], 10, 32)
+		if err != nil || taxid <= 1 {
+			checkError(fmt.Errorf("invalid TaxId at line %d: %s", n, line))
+		}
+		if _, ok = m.Key2Taxid[key]; ok {
+			checkError(fmt.Errorf("duplicated lineage key at line %d: %s", n, key))
+		}
+		if _key, ok = taxid2key[uint32(taxid)]; ok {
+			checkError(fmt.Errorf("TaxId %d is assigned to two lineage keys: %s, %s", taxid, _key, key))
+		}
+
+		taxid2key[uint32(taxid)] = key
+		m.Key2Taxid[key] = uint32(taxid)
+		m.Taxids[uint32(taxid)] = struct{}{}
+		if len(items) == 3 {
+			m.Deleted[key] = struct{}{}
+		}
+	}
+	checkError(scanner.Err())
+
+	m.NLoaded = len(m.Key2Taxid)
+	return m
+}
+
+// keys returns lineage keys of all ranks of a taxon, empty for null taxa.
+func (m *taxidMap) keys(rankNames []string, t *_Taxon) []string {
+	keys := make([]string, len(t.TaxIds))
+	var buf strings.Builder
+	for i, taxid := range t.TaxIds {
+		if taxid == 0 || t.Names[i] == "" {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(';')
+		}
+		buf.WriteString(strings.ToLower(rankNames[i]))
+		buf.WriteByte(':')
+		buf.WriteString(strings.ToLower(t.Names[i]))
+		keys[i] = buf.String()
+	}
+	return keys
+}
+
+// assign replaces TaxIds of known lineage keys with the recorded ones,
+// and moves TaxIds of new taxa away from recorded TaxIds.
+func (m *taxidMap) assign(keys []string, t *_Taxon) {
+	var taxid uint32
+	var ok bool
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		if taxid, ok = m.Key2Taxid[key]; ok {
+			t.TaxIds[i] = taxid
+			continue
+		}
+		t.TaxIds[i] = m.nextFree(t.TaxIds[i])
+	}
+}
+
+// nextFree returns the first TaxId not recorded, starting from taxid.
+func (m *taxidMap) nextFree(taxid uint32) uint32 {
+	var ok bool
+	for {
+		if _, ok = m.Taxids[taxid]; !ok {
+			return taxid
+		}
+		taxid++
+	}
+}
+
+// record saves the final TaxIds of a taxon.
+func (m *taxidMap) record(keys []string, t *_Taxon) {
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		m.Seen[key] = struct{}{}
+		delete(m.Deleted, key)
+		m.Key2Taxid[key] = t.TaxIds[i]
+		m.Taxids[t.TaxIds[i]] = struct{}{}
+	}
+}
+
+// removed marks keys not seen in current input as deleted,
+// and returns their TaxIds.
+func (m *taxidMap) removed() []uint32 {
+	taxids := make([]uint32, 0, 1024)
+	var ok bool
+	for key, taxid := range m.Key2Taxid {
+		if _, ok = m.Seen[key]; ok {
+			continue
+		}
+		m.Deleted[key] = struct{}{}
+		taxids = append(taxids, taxid)
+	}
+	return taxids
+}
+
+// write saves the mapping to the file.
+func (m *taxidMap) write() {
+	outfh, err := xopen.Wopen(m.File)
+	checkError(err)
+	defer func() {
+		checkError(outfh.Close())
+	}()
+
+	keys := make([]string, 0, len(m.Key2Taxid))
+	for key := range m.Key2Taxid {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ok bool
+	for _, key := range keys {
+		if _, ok = m.Deleted[key]; ok {
+			fmt.Fprintf(outfh, "%s\t%d\t%s\n", key, m.Key2Taxid[key], taxidMapDeleted)
+			continue
+		}
+		fmt.Fprintf(outfh, "%s\t%d\n", key, m.Key2Taxid[key])
+	}
+}
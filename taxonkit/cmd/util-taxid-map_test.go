@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var taxidMapTestRanks = []string{"superkingdom", "phylum", "genus"}
+
+// runTaxidMap mimics a run of create-taxdump with a TaxId map file,
+// and returns the final TaxIds of all taxa.
+func runTaxidMap(file string, taxa []*_Taxon) ([][]uint32, []uint32) {
+	m := loadTaxidMap(file)
+	taxids := make([][]uint32, len(taxa))
+	for i, t := range taxa {
+		keys := m.keys(taxidMapTestRanks, t)
+		m.assign(keys, t)
+		m.record(keys, t)
+		taxids[i] = append([]uint32{}, t.TaxIds...)
+	}
+	removed := m.removed()
+	m.write()
+	return taxids, removed
+}
+
+func newTaxidMapTestTaxon(names []string, taxids []uint32) *_Taxon {
+	return &_Taxon{Names: names, TaxIds: append([]uint32{}, taxids...)}
+}
+
+func TestTaxidMapCycle(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "taxid-map.tsv")
+
+	bacillus := []string{"Bacteria", "Bacillota", "Bacillus"}
+	escherichia := []string{"Bacteria", "Pseudomonadota", "Escherichia"}
+
+	// 1st run: new taxa keep their TaxIds
+	taxids, removed := runTaxidMap(file, []*_Taxon{
+		newTaxidMapTestTaxon(bacillus, []uint32{2, 3, 4}),
+		newTaxidMapTestTaxon(escherichia, []uint32{2, 5, 6}),
+	})
+	if want := [][]uint32{{2, 3, 4}, {2, 5, 6}}; !reflect.DeepEqual(taxids, want) {
+		t.Errorf("run 1: got %v, want %v", taxids, want)
+	}
+	if len(removed) != 0 {
+		t.Errorf("run 1: nothing should be removed, got %v", removed)
+	}
+
+	// 2nd run: Escherichia is removed, and a new genus hashed to its TaxId
+	// is moved away. Recorded TaxIds win over the hashed ones.
+	taxids, removed = runTaxidMap(file, []*_Taxon{
+		newTaxidMapTestTaxon(bacillus, []uint32{20, 30, 40}),
+		newTaxidMapTestTaxon([]string{"Bacteria", "Bacillota", "Listeria"}, []uint32{2, 3, 6}),
+	})
+	if want := [][]uint32{{2, 3, 4}, {2, 3, 7}}; !reflect.DeepEqual(taxids, want) {
+		t.Errorf("run 2: got %v, want %v", taxids, want)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	if want := []uint32{5, 6}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("run 2: removed TaxIds: got %v, want %v", removed, want)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `superkingdom:bacteria	2
+superkingdom:bacteria;phylum:bacillota	3
+superkingdom:bacteria;phylum:bacillota;genus:bacillus	4
+superkingdom:bacteria;phylum:bacillota;genus:listeria	7
+superkingdom:bacteria;phylum:pseudomonadota	5	deleted
+superkingdom:bacteria;phylum:pseudomonadota;genus:escherichia	6	deleted
+`
+	if string(data) != want {
+		t.Errorf("run 2: map file: got:\n%s\nwant:\n%s", data, want)
+	}
+
+	// 3rd run: Escherichia returns with its old TaxIds
+	taxids, removed = runTaxidMap(file, []*_Taxon{
+		newTaxidMapTestTaxon(bacillus, []uint32{2, 3, 4}),
+		newTaxidMapTestTaxon([]string{"Bacteria", "Bacillota", "Listeria"}, []uint32{2, 3, 6}),
+		newTaxidMapTestTaxon(escherichia, []uint32{2, 50, 60}),
+	})
+	if want := [][]uint32{{2, 3, 4}, {2, 3, 7}, {2, 5, 6}}; !reflect.DeepEqual(taxids, want) {
+		t.Errorf("run 3: got %v, want %v", taxids, want)
+	}
+	if len(removed) != 0 {
+		t.Errorf("run 3: nothing should be removed, got %v", removed)
+	}
+	m := loadTaxidMap(file)
+	if len(m.Deleted) != 0 || m.NLoaded != 6 {
+		t.Errorf("run 3: %d records (%d deleted) saved, want 6 (0 deleted)", m.NLoaded, len(m.Deleted))
+	}
+}
+
+func TestTaxidMapKeys(t *testing.T) {
+	m := loadTaxidMap(filepath.Join(t.TempDir(), "taxid-map.tsv"))
+
+	// null taxa have no keys, and are skipped in keys of their descendants
+	keys := m.keys(taxidMapTestRanks, newTaxidMapTestTaxon([]string{"Bacteria", "", "Bacillus"}, []uint32{2, 0, 4}))
+	if want := []string{"superkingdom:bacteria", "", "superkingdom:bacteria;genus:bacillus"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys with a null taxon: got %q, want %q", keys, want)
+	}
+
+	// keys are case-insensitive: "Bacillus" and "bacillus" share the TaxId
+	upper := newTaxidMapTestTaxon([]string{"Bacteria", "Bacillota", "Bacillus"}, []uint32{2, 3, 4})
+	lower := newTaxidMapTestTaxon([]string{"bacteria", "bacillota", "bacillus"}, []uint32{2, 3, 5})
+	keysUpper := m.keys(taxidMapTestRanks, upper)
+	m.assign(keysUpper, upper)
+	m.record(keysUpper, upper)
+	keysLower := m.keys(taxidMapTestRanks, lower)
+	if !reflect.DeepEqual(keysUpper, keysLower) {
+		t.Errorf("keys differ in case: %q, %q", keysUpper, keysLower)
+	}
+	m.assign(keysLower, lower)
+	if !reflect.DeepEqual(lower.TaxIds, upper.TaxIds) {
+		t.Errorf("TaxIds of taxa differing in case: got %v, want %v", lower.TaxIds, upper.TaxIds)
+	}
+}
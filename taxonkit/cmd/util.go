@@ -21,8 +21,13 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
+	"strconv"
 	"strings"
 	"unsafe"
+
+	"github.com/shenwei356/xopen"
 )
 
 func stringSplitN(s string, sep string, n int, a *[]string) {
@@ -69,6 +74,35 @@ func stringSplitNByByte(s string, sep byte, n int, a *[]string) {
 	(*a) = (*a)[:i+1]
 }
 
+// isUnranked tells whether a rank is "no rank" or "clade".
+func isUnranked(rank string) bool {
+	return rank == "no rank" || rank == "clade"
+}
+
+// padFields appends value to fields to make exactly n fields,
+// or truncates fields to the first n ones. n <= 0 means no change.
+func padFields(fields []string, n int, value string) []string {
+	if n <= 0 {
+		return fields
+	}
+	if len(fields) >= n {
+		return fields[:n]
+	}
+	for len(fields) < n {
+		fields = append(fields, value)
+	}
+	return fields
+}
+
+// joinUint32s joins integers with the separator.
+func joinUint32s(s []uint32, sep string) string {
+	items := make([]string, len(s))
+	for i, v := range s {
+		items[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(items, sep)
+}
+
 func reverseUint32s(s []uint32) {
 	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
 		s[i], s[j] = s[j], s[i]
@@ -90,3 +124,76 @@ func CopyString(s string) string {
 	copy(b, s)
 	return *(*string)(unsafe.Pointer(&b))
 }
+
+// jsonString returns the quoted and escaped JSON string of s.
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// levenshtein returns the Levenshtein distance between two strings.
+// It returns max+1 once the distance is known to be larger than max.
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(ra)-len(rb) > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	var cost, rowMin int
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin = i
+		for j := 1; j <= len(rb); j++ {
+			cost = 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInts(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInts(a int, vals ...int) int {
+	min := a
+	for _, v := range vals {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// setWriterBufferSize replaces the buffer of a writer with a new one of given size.
+func setWriterBufferSize(outfh *xopen.Writer, size int) {
+	outfh.Writer = bufio.NewWriterSize(flushingWriter{outfh.Writer}, size)
+}
+
+// flushingWriter flushes the underlying buffered writer after each write,
+// so the data is not kept in two buffers.
+type flushingWriter struct {
+	w *bufio.Writer
+}
+
+func (fw flushingWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, fw.w.Flush()
+}
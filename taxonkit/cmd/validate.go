@@ -0,0 +1,254 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the integrity of taxonomy data",
+	Long: `Validate the integrity of taxonomy data
+
+Checks:
+
+  root:             there should be a single root node (parent is itself) with TaxId 1
+  dangling-parent:  parents of TaxIds should exist in nodes.dmp
+  cycle:            TaxIds should be able to reach the root, i.e., not in a cycle
+  no-name:          TaxIds in nodes.dmp should have scientific names in names.dmp
+  deleted-in-nodes: TaxIds in delnodes.dmp should not exist in nodes.dmp
+  merged-in-nodes:  merged TaxIds in merged.dmp should not exist in nodes.dmp
+  merge-target:     targets of merged TaxIds in merged.dmp should exist in nodes.dmp
+
+Output (tab-delimited):
+
+  1. Check
+  2. Number of problematic TaxIds
+  3. Examples of problematic TaxIds (sorted, --max-examples)
+
+The dump files are read directly, so the binary index (taxonkit.idx) is not used.
+It exits with a non-zero status if any problems are found.
+
+Examples:
+
+    $ taxonkit validate
+    check             problems  examples
+    root              0
+    dangling-parent   0
+    ...
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		maxExamples := getFlagNonNegativeInt(cmd, "max-examples")
+
+		// -------------------- load data ----------------------
+
+		var tree map[uint32]uint32
+		var names map[uint32]string
+		var delnodes map[uint32]struct{}
+		var merged map[uint32]uint32
+
+		var wg sync.WaitGroup
+		wg.Add(4)
+		go func() {
+			tree, _ = getNodes(config.NodesFile, false)
+			wg.Done()
+		}()
+		go func() {
+			names = getTaxonNames(config.NamesFile)
+			wg.Done()
+		}()
+		go func() {
+			delnodes = getDelnodesMap(config.DelNodesFile)
+			wg.Done()
+		}()
+		go func() {
+			merged = getMergedNodesMap(config.MergedFile)
+			wg.Done()
+		}()
+		wg.Wait()
+
+		if config.Verbose {
+			log.Infof("%d nodes, %d names, %d deleted nodes, and %d merged nodes loaded",
+				len(tree), len(names), len(delnodes), len(merged))
+		}
+
+		// -------------------- check ----------------------
+
+		problems := validateTaxdump(tree, names, delnodes, merged)
+
+		// -------------------- output ----------------------
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+
+		var nProblems int
+		var taxids []uint32
+		var n int
+		examples := make([]string, 0, 8)
+		outfh.WriteString("check\tproblems\texamples\n")
+		for _, check := range validationChecks {
+			taxids = problems[check]
+			sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+			nProblems += len(taxids)
+
+			n = len(taxids)
+			if maxExamples > 0 && n > maxExamples {
+				n = maxExamples
+			}
+			examples = examples[:0]
+			for _, taxid := range taxids[:n] {
+				examples = append(examples, strconv.Itoa(int(taxid)))
+			}
+			outfh.WriteString(fmt.Sprintf("%s\t%d\t%s\n", check, len(taxids), strings.Join(examples, ",")))
+		}
+		checkError(outfh.Close())
+
+		if nProblems > 0 {
+			checkError(fmt.Errorf("%d problems found in taxonomy data: %s", nProblems, config.DataDir))
+		}
+		log.Infof("no problems found in taxonomy data: %s", config.DataDir)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().IntP("max-examples", "", 5, `maximum number of problematic TaxIds to show for each check, 0 for all`)
+}
+
+// validationChecks are the names of checks of validateTaxdump, in order.
+var validationChecks = []string{"root", "dangling-parent", "cycle", "no-name", "deleted-in-nodes", "merged-in-nodes", "merge-target"}
+
+// validateTaxdump checks the integrity of taxonomy data, and returns
+// problematic TaxIds (unsorted) of each check in validationChecks.
+func validateTaxdump(
+	tree map[uint32]uint32,
+	names map[uint32]string,
+	delnodes map[uint32]struct{},
+	merged map[uint32]uint32,
+) map[string][]uint32 {
+	problems := make(map[string][]uint32, len(validationChecks))
+
+	var ok bool
+	var roots []uint32
+	for taxid, parent := range tree {
+		if parent == taxid {
+			roots = append(roots, taxid)
+			continue
+		}
+		if _, ok = tree[parent]; !ok {
+			problems["dangling-parent"] = append(problems["dangling-parent"], taxid)
+		}
+		if _, ok = names[taxid]; !ok {
+			problems["no-name"] = append(problems["no-name"], taxid)
+		}
+	}
+	if len(roots) != 1 || roots[0] != 1 {
+		problems["root"] = roots
+		if len(roots) == 0 {
+			problems["root"] = []uint32{0}
+		}
+	}
+	for _, root := range roots {
+		if _, ok = names[root]; !ok {
+			problems["no-name"] = append(problems["no-name"], root)
+		}
+	}
+
+	problems["cycle"] = taxidsInCycles(tree)
+
+	for taxid := range delnodes {
+		if _, ok = tree[taxid]; ok {
+			problems["deleted-in-nodes"] = append(problems["deleted-in-nodes"], taxid)
+		}
+	}
+	for from, to := range merged {
+		if _, ok = tree[from]; ok {
+			problems["merged-in-nodes"] = append(problems["merged-in-nodes"], from)
+		}
+		if _, ok = tree[to]; !ok {
+			problems["merge-target"] = append(problems["merge-target"], from)
+		}
+	}
+
+	return problems
+}
+
+// taxidsInCycles returns TaxIds in cycles of the tree, i.e., nodes not reaching
+// the root by following parents, where dangling parents are treated as roots.
+func taxidsInCycles(tree map[uint32]uint32) []uint32 {
+	// 1: visiting, 2: done
+	state := make(map[uint32]uint8, len(tree))
+	taxids := make([]uint32, 0, 8)
+
+	path := make([]uint32, 0, 64)
+	var taxid, parent uint32
+	var ok bool
+	var s uint8
+	for start := range tree {
+		if state[start] == 2 {
+			continue
+		}
+
+		path = path[:0]
+		taxid = start
+		for {
+			if s = state[taxid]; s == 2 {
+				break
+			} else if s == 1 { // cycle found, nodes from taxid to the end of path
+				for i := len(path) - 1; i >= 0; i-- {
+					taxids = append(taxids, path[i])
+					if path[i] == taxid {
+						break
+					}
+				}
+				break
+			}
+
+			state[taxid] = 1
+			path = append(path, taxid)
+
+			parent, ok = tree[taxid]
+			if !ok || parent == taxid {
+				break
+			}
+			taxid = parent
+		}
+
+		for _, taxid = range path {
+			state[taxid] = 2
+		}
+	}
+
+	return taxids
+}
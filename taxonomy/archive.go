@@ -0,0 +1,214 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/xopen"
+)
+
+// ArchiveSuffixes are suffixes of tar archives of dump files, e.g.,
+// taxdump.tar.gz from NCBI, which can be used in place of directories.
+var ArchiveSuffixes = []string{".tar.gz", ".tgz", ".tar", ".tar.zst", ".tar.xz"}
+
+// IsArchive tells whether a path is a tar archive of dump files,
+// i.e., a regular file with one of ArchiveSuffixes.
+func IsArchive(file string) bool {
+	lower := strings.ToLower(file)
+	for _, suffix := range ArchiveSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			info, err := os.Stat(file)
+			return err == nil && info.Mode().IsRegular()
+		}
+	}
+	return false
+}
+
+// splitArchiveMember splits the path of a member in an archive, e.g.,
+// "taxdump.tar.gz/nodes.dmp", as returned by DumpFile.
+func splitArchiveMember(file string) (archive string, member string, ok bool) {
+	archive, member = filepath.Dir(file), filepath.Base(file)
+	return archive, member, IsArchive(archive)
+}
+
+// archiveMember is the cached content of a member in an archive.
+type archiveMember struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// archiveMembers caches contents of members read from archives,
+// only the ones actually opened are read and cached.
+var archiveMembers = struct {
+	sync.Mutex
+	m map[string]*archiveMember
+}{m: make(map[string]*archiveMember, 4)}
+
+// scanArchive calls fn with the base name and the content of each regular
+// file in an archive, until fn returns true.
+func scanArchive(archive string, fn func(name string, r io.Reader) (bool, error)) error {
+	fh, err := xopen.Ropen(archive)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	tr := tar.NewReader(fh)
+	var hdr *tar.Header
+	var stop bool
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive %s: %s", archive, err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		stop, err = fn(path.Base(hdr.Name), tr)
+		if err != nil || stop {
+			return err
+		}
+	}
+}
+
+// readArchiveMember returns the content of a member in an archive,
+// which is read only once and cached in memory.
+func readArchiveMember(archive string, member string) ([]byte, error) {
+	key := filepath.Join(archive, member)
+	archiveMembers.Lock()
+	m, ok := archiveMembers.m[key]
+	if !ok {
+		m = &archiveMember{}
+		archiveMembers.m[key] = m
+	}
+	archiveMembers.Unlock()
+
+	m.once.Do(func() {
+		var found bool
+		m.err = scanArchive(archive, func(name string, r io.Reader) (bool, error) {
+			if name != member {
+				return false, nil
+			}
+			found = true
+			var err error
+			m.data, err = io.ReadAll(r)
+			return true, err
+		})
+		if m.err == nil && !found {
+			m.err = &os.PathError{Op: "open", Path: key, Err: os.ErrNotExist}
+		}
+	})
+	return m.data, m.err
+}
+
+// Open opens a dump file for reading. Compressed files are decompressed
+// transparently, and members of archives (paths returned by DumpFile for
+// archives) are read from the archives. Like xopen.Ropen, xopen.ErrNoContent
+// is returned for empty files.
+func Open(file string) (io.ReadCloser, error) {
+	archive, member, ok := splitArchiveMember(file)
+	if !ok {
+		fh, err := xopen.Ropen(file)
+		if err != nil {
+			return nil, err
+		}
+		return fh, nil
+	}
+
+	data, err := readArchiveMember(archive, member)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, xopen.ErrNoContent
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Exists tells whether a dump file exists, which can be a member of
+// an archive. Only names of members are read and cached in checking.
+func Exists(file string) (bool, error) {
+	archive, member, ok := splitArchiveMember(file)
+	if !ok {
+		_, err := os.Stat(file)
+		if err == nil {
+			return true, nil
+		}
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	names, err := listArchive(archive)
+	if err != nil {
+		return false, err
+	}
+	_, ok = names[member]
+	return ok, nil
+}
+
+// archiveList is the cached list of members in an archive.
+type archiveList struct {
+	once  sync.Once
+	names map[string]struct{}
+	err   error
+}
+
+// archiveLists caches lists of members in archives.
+var archiveLists = struct {
+	sync.Mutex
+	m map[string]*archiveList
+}{m: make(map[string]*archiveList, 1)}
+
+// listArchive returns base names of regular files in an archive,
+// which are read only once and cached in memory.
+func listArchive(archive string) (map[string]struct{}, error) {
+	archiveLists.Lock()
+	l, ok := archiveLists.m[archive]
+	if !ok {
+		l = &archiveList{}
+		archiveLists.m[archive] = l
+	}
+	archiveLists.Unlock()
+
+	l.once.Do(func() {
+		l.names = make(map[string]struct{}, 16)
+		l.err = scanArchive(archive, func(name string, r io.Reader) (bool, error) {
+			l.names[name] = struct{}{}
+			return false, nil
+		})
+	})
+	return l.names, l.err
+}
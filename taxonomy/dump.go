@@ -0,0 +1,265 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+)
+
+// mapInitialSize is the initial size of maps for TaxIds.
+var mapInitialSize = 8 << 10
+
+// SetMapInitialSize sets the initial size of maps for TaxIds, e.g., the
+// number of nodes, to avoid rehashing when reading big dump files.
+// It should be called before reading. Non-positive values are ignored.
+func SetMapInitialSize(n int) {
+	if n > 0 {
+		mapInitialSize = n
+	}
+}
+
+// DumpFileSuffixes are suffixes of dump files, compressed files are
+// decompressed by xopen transparently.
+var DumpFileSuffixes = []string{"", ".gz", ".zst", ".xz"}
+
+// DumpFile returns the path of a dump file in dir, checking the plain file,
+// and then gzip, zstd, and xz-compressed ones. The path of the plain file is
+// returned if none exists. If dir is an archive (see IsArchive), the path of
+// the member is returned, which should be opened with Open.
+func DumpFile(dir string, name string) (string, error) {
+	if IsArchive(dir) {
+		return filepath.Join(dir, name), nil
+	}
+	var file string
+	for _, suffix := range DumpFileSuffixes {
+		file = filepath.Join(dir, name+suffix)
+		_, err := os.Stat(file)
+		if err == nil {
+			return file, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// MaxTaxId is the largest supported TaxId, as TaxIds are stored in uint32.
+const MaxTaxId = math.MaxUint32
+
+// ErrTaxIdOutOfRange means a TaxId is larger than MaxTaxId, which would be
+// truncated silently if converted to uint32.
+var ErrTaxIdOutOfRange = errors.New("taxonomy: TaxId out of range")
+
+// ParseTaxId parses a TaxId. ErrTaxIdOutOfRange is returned for TaxIds
+// larger than MaxTaxId, and strconv.ErrSyntax for other invalid values,
+// wrapped in *strconv.NumError.
+func ParseTaxId(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+			return 0, fmt.Errorf("%w: %s", ErrTaxIdOutOfRange, s)
+		}
+		return 0, err
+	}
+	return uint32(id), nil
+}
+
+// parseTaxIdOfDump parses a TaxId in a dump file, ok is false for
+// invalid values which should be skipped, e.g., in a header line.
+func parseTaxIdOfDump(s string) (id uint32, ok bool, err error) {
+	id, err = ParseTaxId(s)
+	if err != nil {
+		if errors.Is(err, ErrTaxIdOutOfRange) {
+			return 0, false, err
+		}
+		return 0, false, nil
+	}
+	return id, true, nil
+}
+
+// ReadNodes reads nodes.dmp, and returns the map of child -> parent,
+// and the map of TaxId -> rank if withRank is true.
+func ReadNodes(file string, withRank bool) (map[uint32]uint32, map[uint32]string, error) {
+	tree := make(map[uint32]uint32, mapInitialSize)
+	var ranks map[uint32]string
+	if withRank {
+		ranks = make(map[uint32]string, mapInitialSize)
+	}
+
+	err := scanDumpFile(file, 6, func(items []string) error {
+		child, ok, err := parseTaxIdOfDump(items[0])
+		if !ok {
+			return err
+		}
+		parent, ok, err := parseTaxIdOfDump(items[2])
+		if !ok {
+			return err
+		}
+
+		tree[child] = parent
+		if withRank {
+			ranks[child] = items[4]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree, ranks, nil
+}
+
+// ReadRanks reads nodes.dmp, and returns the map of TaxId -> rank.
+func ReadRanks(file string) (map[uint32]string, error) {
+	ranks := make(map[uint32]string, mapInitialSize)
+
+	err := scanDumpFile(file, 6, func(items []string) error {
+		child, ok, err := parseTaxIdOfDump(items[0])
+		if !ok {
+			return err
+		}
+		ranks[child] = items[4]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
+
+// ReadNames reads names.dmp, and returns the map of TaxId -> scientific name.
+func ReadNames(file string) (map[uint32]string, error) {
+	names := make(map[uint32]string, mapInitialSize)
+
+	err := scanDumpFile(file, 8, func(items []string) error {
+		if items[6] != "scientific name" {
+			return nil
+		}
+		id, ok, err := parseTaxIdOfDump(items[0])
+		if !ok {
+			return err
+		}
+		names[id] = items[2]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// ReadDelNodes reads delnodes.dmp, and returns the set of deleted TaxIds.
+// An empty file is allowed.
+func ReadDelNodes(file string) (map[uint32]struct{}, error) {
+	taxids := make(map[uint32]struct{}, 1<<10)
+
+	err := scanDumpFile(file, 2, func(items []string) error {
+		id, ok, err := parseTaxIdOfDump(items[0])
+		if !ok {
+			return err
+		}
+		taxids[id] = struct{}{}
+		return nil
+	})
+	if err != nil && err != xopen.ErrNoContent {
+		return nil, err
+	}
+	return taxids, nil
+}
+
+// ReadMerged reads merged.dmp, and returns the map of old TaxId -> new TaxId.
+// An empty file is allowed.
+func ReadMerged(file string) (map[uint32]uint32, error) {
+	merged := make(map[uint32]uint32, 1<<10)
+
+	err := scanDumpFile(file, 4, func(items []string) error {
+		from, ok, err := parseTaxIdOfDump(items[0])
+		if !ok {
+			return err
+		}
+		to, ok, err := parseTaxIdOfDump(items[2])
+		if !ok {
+			return err
+		}
+		merged[from] = to
+		return nil
+	})
+	if err != nil && err != xopen.ErrNoContent {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// scanDumpFile calls fn for each line of a dump file split into at most n
+// fields by tabs, lines with less than n fields are skipped.
+// It stops at the first error returned by fn.
+func scanDumpFile(file string, n int, fn func(items []string) error) error {
+	fh, err := Open(file)
+	if err != nil {
+		return err
+	}
+
+	items := make([]string, n)
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		splitN(scanner.Text(), "\t", n, &items)
+		if len(items) < n {
+			continue
+		}
+		if err = fn(items); err != nil {
+			fh.Close()
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// splitN splits s by sep into at most n fields, reusing the slice a.
+func splitN(s string, sep string, n int, a *[]string) {
+	*a = (*a)[:n]
+	n--
+	i := 0
+	for i < n {
+		m := strings.Index(s, sep)
+		if m < 0 {
+			break
+		}
+		(*a)[i] = s[:m]
+		s = s[m+len(sep):]
+		i++
+	}
+	(*a)[i] = s
+
+	*a = (*a)[:i+1]
+}
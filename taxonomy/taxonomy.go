@@ -0,0 +1,279 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package taxonomy provides reading and querying of NCBI-style taxonomy
+// dump files (nodes.dmp, names.dmp, delnodes.dmp, and merged.dmp),
+// which is the core of TaxonKit.
+//
+// Example:
+//
+//	t, err := taxonomy.Load("/home/user/.taxonkit", true)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	for _, taxid := range t.Lineage(9606) {
+//		fmt.Println(taxid, t.Ranks[taxid], t.Names[taxid])
+//	}
+package taxonomy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Status is the status of a TaxId in the taxonomy.
+type Status int
+
+const (
+	// NotFound means the TaxId is not found in any dump file.
+	NotFound Status = iota
+	// Found means the TaxId is in nodes.dmp.
+	Found
+	// Deleted means the TaxId is in delnodes.dmp.
+	Deleted
+	// Merged means the TaxId is merged into another one in merged.dmp.
+	Merged
+)
+
+func (s Status) String() string {
+	switch s {
+	case Found:
+		return "found"
+	case Deleted:
+		return "deleted"
+	case Merged:
+		return "merged"
+	default:
+		return "not found"
+	}
+}
+
+// ErrNodesNotFound means nodes.dmp is not found in the directory.
+var ErrNodesNotFound = errors.New("taxonomy: nodes.dmp not found")
+
+// ErrNamesNotFound means names.dmp is not found in the directory.
+var ErrNamesNotFound = errors.New("taxonomy: names.dmp not found")
+
+// Taxonomy holds the data of a taxonomy. The maps are read-only after
+// loading, and all methods are safe for concurrent use.
+type Taxonomy struct {
+	Nodes    map[uint32]uint32   // child -> parent
+	Ranks    map[uint32]string   // TaxId -> rank, nil if not loaded
+	Names    map[uint32]string   // TaxId -> scientific name
+	DelNodes map[uint32]struct{} // deleted TaxIds
+	Merged   map[uint32]uint32   // old TaxId -> new TaxId
+
+	onceChildren sync.Once
+	children     map[uint32][]uint32 // parent -> sorted children
+}
+
+// Load reads dump files from a directory or an archive (see IsArchive),
+// where files can be plain or compressed with gzip, zstd, or xz.
+// nodes.dmp and names.dmp are required, while delnodes.dmp and merged.dmp are optional.
+func Load(dir string, withRank bool) (*Taxonomy, error) {
+	files := make(map[string]string, 4)
+	for _, name := range []string{"nodes.dmp", "names.dmp", "delnodes.dmp", "merged.dmp"} {
+		file, err := DumpFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = file
+	}
+	for name, e := range map[string]error{"nodes.dmp": ErrNodesNotFound, "names.dmp": ErrNamesNotFound} {
+		ok, err := Exists(files[name])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", e, dir)
+		}
+	}
+
+	t := &Taxonomy{}
+	errs := make([]error, 4)
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		t.Nodes, t.Ranks, errs[0] = ReadNodes(files["nodes.dmp"], withRank)
+	}()
+	go func() {
+		defer wg.Done()
+		t.Names, errs[1] = ReadNames(files["names.dmp"])
+	}()
+	go func() {
+		defer wg.Done()
+		if exists(files["delnodes.dmp"]) {
+			t.DelNodes, errs[2] = ReadDelNodes(files["delnodes.dmp"])
+		} else {
+			t.DelNodes = make(map[uint32]struct{})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if exists(files["merged.dmp"]) {
+			t.Merged, errs[3] = ReadMerged(files["merged.dmp"])
+		} else {
+			t.Merged = make(map[uint32]uint32)
+		}
+	}()
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// exists tells whether a file exists.
+func exists(file string) bool {
+	ok, _ := Exists(file)
+	return ok
+}
+
+// Resolve returns the current TaxId of a TaxId and its status. The TaxId
+// itself is returned for found ones, the new TaxId for merged ones, and 0
+// for deleted and not found ones.
+func (t *Taxonomy) Resolve(taxid uint32) (uint32, Status) {
+	if _, ok := t.Nodes[taxid]; ok {
+		return taxid, Found
+	}
+	if _, ok := t.DelNodes[taxid]; ok {
+		return 0, Deleted
+	}
+	if to, ok := t.Merged[taxid]; ok {
+		return to, Merged
+	}
+	return 0, NotFound
+}
+
+// Lineage returns the TaxIds from the top (the root 1 excluded, unless it's
+// the given TaxId) to the given TaxId, which is the last one. nil is returned
+// if the TaxId is not in nodes.dmp, please call Resolve first for merged ones.
+// Cycles in malformed dump files are detected and broken.
+func (t *Taxonomy) Lineage(taxid uint32) []uint32 {
+	if _, ok := t.Nodes[taxid]; !ok {
+		return nil
+	}
+	lineage := make([]uint32, 0, 32)
+	visited := make(map[uint32]struct{}, 32)
+	var parent uint32
+	var ok bool
+	for {
+		if _, ok = visited[taxid]; ok {
+			break
+		}
+		visited[taxid] = struct{}{}
+		lineage = append(lineage, taxid)
+
+		parent, ok = t.Nodes[taxid]
+		if !ok || parent == 1 || parent == taxid {
+			break
+		}
+		taxid = parent
+	}
+	for i, j := 0, len(lineage)-1; i < j; i, j = i+1, j-1 {
+		lineage[i], lineage[j] = lineage[j], lineage[i]
+	}
+	return lineage
+}
+
+// Children returns the children of a TaxId, sorted by TaxIds.
+// The returned slice should not be modified.
+func (t *Taxonomy) Children(taxid uint32) []uint32 {
+	t.onceChildren.Do(func() {
+		t.children = make(map[uint32][]uint32, len(t.Nodes)>>2)
+		for child, parent := range t.Nodes {
+			if child == parent {
+				continue
+			}
+			t.children[parent] = append(t.children[parent], child)
+		}
+		for _, children := range t.children {
+			sort.Slice(children, func(i, j int) bool { return children[i] < children[j] })
+		}
+	})
+	return t.children[taxid]
+}
+
+// Subtree returns the TaxId and all its descendants in depth-first order,
+// with children sorted by TaxIds. nil is returned if the TaxId is not in nodes.dmp.
+func (t *Taxonomy) Subtree(taxid uint32) []uint32 {
+	if _, ok := t.Nodes[taxid]; !ok {
+		return nil
+	}
+	taxids := make([]uint32, 0, 64)
+	visited := make(map[uint32]struct{}, 64)
+	stack := []uint32{taxid}
+	var children []uint32
+	var ok bool
+	for len(stack) > 0 {
+		taxid = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok = visited[taxid]; ok { // cycles in malformed dump files
+			continue
+		}
+		visited[taxid] = struct{}{}
+		taxids = append(taxids, taxid)
+
+		children = t.Children(taxid)
+		for i := len(children) - 1; i >= 0; i-- {
+			stack = append(stack, children[i])
+		}
+	}
+	return taxids
+}
+
+// LCA returns the lowest common ancestor of TaxIds, 0 is returned if any
+// TaxId is not in nodes.dmp, please call Resolve first for merged ones.
+func (t *Taxonomy) LCA(taxids ...uint32) uint32 {
+	if len(taxids) == 0 {
+		return 0
+	}
+	lineage := t.Lineage(taxids[0])
+	if lineage == nil {
+		return 0
+	}
+	var n, i int
+	var l2 []uint32
+	n = len(lineage)
+	for _, taxid := range taxids[1:] {
+		if l2 = t.Lineage(taxid); l2 == nil {
+			return 0
+		}
+		if len(l2) < n {
+			n = len(l2)
+		}
+		for i = 0; i < n; i++ {
+			if lineage[i] != l2[i] {
+				break
+			}
+		}
+		n = i
+	}
+	if n == 0 {
+		return 1 // the root
+	}
+	return lineage[n-1]
+}
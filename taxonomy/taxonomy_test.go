@@ -0,0 +1,224 @@
+package taxonomy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testDumps are dump files of a small taxonomy:
+//
+//	1 root
+//	├── 2 Bacteria (superkingdom)
+//	│   ├── 3 Pseudomonadota (phylum)
+//	│   │   └── 4 Escherichia (genus)
+//	│   │       ├── 5 Escherichia coli (species)
+//	│   │       └── 6 Escherichia albertii (species)
+//	│   └── 7 Bacillota (phylum)
+//	└── 8 Archaea (superkingdom)
+//
+// 10 is merged into 5, and 11 is deleted.
+var testDumps = map[string]string{
+	"nodes.dmp": `1	|	1	|	no rank	|
+2	|	1	|	superkingdom	|
+3	|	2	|	phylum	|
+4	|	3	|	genus	|
+5	|	4	|	species	|
+6	|	4	|	species	|
+7	|	2	|	phylum	|
+8	|	1	|	superkingdom	|
+`,
+	"names.dmp": `1	|	root	|		|	scientific name	|
+2	|	Bacteria	|		|	scientific name	|
+2	|	eubacteria	|		|	genbank common name	|
+3	|	Pseudomonadota	|		|	scientific name	|
+4	|	Escherichia	|		|	scientific name	|
+5	|	Escherichia coli	|		|	scientific name	|
+6	|	Escherichia albertii	|		|	scientific name	|
+7	|	Bacillota	|		|	scientific name	|
+8	|	Archaea	|		|	scientific name	|
+`,
+	"merged.dmp":   "10\t|\t5\t|\n",
+	"delnodes.dmp": "11\t|\n",
+}
+
+// writeTestDumps writes testDumps into a directory.
+func writeTestDumps(t *testing.T, dir string) {
+	for name, data := range testDumps {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeTestArchive writes testDumps into a gzip-compressed tar archive.
+func writeTestArchive(t *testing.T, file string) {
+	fh, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(fh)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"nodes.dmp", "names.dmp", "merged.dmp", "delnodes.dmp"} {
+		data := testDumps[name]
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gw, fh} {
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func loadTestTaxonomy(t *testing.T) *Taxonomy {
+	dir := t.TempDir()
+	writeTestDumps(t, dir)
+	tax, err := Load(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tax
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDumps(t, dir)
+	archive := filepath.Join(t.TempDir(), "taxdump.tar.gz")
+	writeTestArchive(t, archive)
+
+	for _, path := range []string{dir, archive} {
+		tax, err := Load(path, true)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if len(tax.Nodes) != 8 || len(tax.Names) != 8 || len(tax.Ranks) != 8 ||
+			len(tax.DelNodes) != 1 || len(tax.Merged) != 1 {
+			t.Errorf("%s: unexpected numbers of records: %d nodes, %d names, %d ranks, %d deleted, %d merged",
+				path, len(tax.Nodes), len(tax.Names), len(tax.Ranks), len(tax.DelNodes), len(tax.Merged))
+		}
+		if tax.Names[2] != "Bacteria" {
+			t.Errorf("%s: only scientific names should be loaded, got: %s", path, tax.Names[2])
+		}
+		if tax.Ranks[4] != "genus" {
+			t.Errorf("%s: unexpected rank of 4: %s", path, tax.Ranks[4])
+		}
+	}
+
+	tax, err := Load(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tax.Ranks != nil {
+		t.Errorf("ranks should not be loaded")
+	}
+
+	if _, err = Load(t.TempDir(), false); err == nil {
+		t.Errorf("an error is expected for a directory without dump files")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tax := loadTestTaxonomy(t)
+
+	tests := []struct {
+		taxid  uint32
+		want   uint32
+		status Status
+	}{
+		{5, 5, Found},
+		{1, 1, Found},
+		{10, 5, Merged},
+		{11, 0, Deleted},
+		{12, 0, NotFound},
+	}
+	for _, test := range tests {
+		taxid, status := tax.Resolve(test.taxid)
+		if taxid != test.want || status != test.status {
+			t.Errorf("Resolve(%d): got %d (%s), want %d (%s)", test.taxid, taxid, status, test.want, test.status)
+		}
+	}
+}
+
+func TestLineage(t *testing.T) {
+	tax := loadTestTaxonomy(t)
+
+	tests := []struct {
+		taxid uint32
+		want  []uint32
+	}{
+		{5, []uint32{2, 3, 4, 5}},
+		{8, []uint32{8}},
+		{1, []uint32{1}},
+		{10, nil}, // merged
+		{12, nil}, // not found
+	}
+	for _, test := range tests {
+		if got := tax.Lineage(test.taxid); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Lineage(%d): got %v, want %v", test.taxid, got, test.want)
+		}
+	}
+
+	// a cycle in a malformed taxonomy
+	tax = &Taxonomy{Nodes: map[uint32]uint32{1: 1, 2: 3, 3: 4, 4: 2}}
+	if got, want := tax.Lineage(2), []uint32{4, 3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Lineage(2) with a cycle: got %v, want %v", got, want)
+	}
+}
+
+func TestChildrenAndSubtree(t *testing.T) {
+	tax := loadTestTaxonomy(t)
+
+	if got, want := tax.Children(1), []uint32{2, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Children(1): got %v, want %v", got, want)
+	}
+	if got, want := tax.Children(4), []uint32{5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Children(4): got %v, want %v", got, want)
+	}
+	if got := tax.Children(5); len(got) != 0 {
+		t.Errorf("Children(5): got %v, want none", got)
+	}
+
+	if got, want := tax.Subtree(1), []uint32{1, 2, 3, 4, 5, 6, 7, 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Subtree(1): got %v, want %v", got, want)
+	}
+	if got, want := tax.Subtree(3), []uint32{3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Subtree(3): got %v, want %v", got, want)
+	}
+	if got := tax.Subtree(12); got != nil {
+		t.Errorf("Subtree(12): got %v, want nil", got)
+	}
+}
+
+func TestLCA(t *testing.T) {
+	tax := loadTestTaxonomy(t)
+
+	tests := []struct {
+		taxids []uint32
+		want   uint32
+	}{
+		{[]uint32{5, 6}, 4},
+		{[]uint32{5, 6, 7}, 2},
+		{[]uint32{5, 4}, 4},
+		{[]uint32{5, 8}, 1},
+		{[]uint32{5, 1}, 1},
+		{[]uint32{5, 5}, 5},
+		{[]uint32{5}, 5},
+		{[]uint32{5, 12}, 0}, // not found
+		{[]uint32{5, 10}, 0}, // merged, not resolved
+		{nil, 0},
+	}
+	for _, test := range tests {
+		if got := tax.LCA(test.taxids...); got != test.want {
+			t.Errorf("LCA(%v): got %d, want %d", test.taxids, got, test.want)
+		}
+	}
+}